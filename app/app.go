@@ -3,12 +3,15 @@ package app
 import (
 	"context"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"time"
 
 	"auth/config"
 	"auth/internal/controller"
+	"auth/internal/helper"
+	"auth/internal/mail"
 	middlewares "auth/internal/middleware"
 	"auth/internal/repository"
 	"auth/internal/router"
@@ -16,6 +19,7 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/jmoiron/sqlx"
 	"github.com/redis/go-redis/v9"
 )
@@ -24,24 +28,64 @@ type App struct {
 	router http.Handler
 	db     *sqlx.DB
 	rdb    *redis.Client
+	mail   *mail.Queue
+	keys   service.SigningKeyService
 }
 
 func New() *App {
 	db := config.InitDb()
-	config.GoogleConfig()
+
+	providers, err := config.OAuthProviders()
+	if err != nil {
+		log.Fatalf("Cannot load oauth providers %v", err)
+	}
+
+	sessionPolicies, err := config.SessionPolicyConfig()
+	if err != nil {
+		log.Fatalf("Cannot load session policy %v", err)
+	}
+	helper.UseSessionPolicies(sessionPolicies)
+
+	if _, err := helper.SigningKeys(); err != nil {
+		log.Fatalf("Cannot load jwt signing key %v", err)
+	}
 
 	redisAddr := os.Getenv("REDIS_ADDR")
 	if redisAddr == "" {
 		redisAddr = "localhost:6379"
 	}
 
+	// a single node, the session scripts build keys and do not run on a cluster
 	redisClient := redis.NewClient(&redis.Options{
 		Addr: redisAddr,
 	})
 
+	helper.UseAccessTokenDenylist(redisClient)
+	helper.UseDPoPReplayCache(redisClient)
+
+	mailConfig, err := config.Mail()
+	if err != nil {
+		log.Fatalf("Cannot load mail config %v", err)
+	}
+	mailer, err := mail.New(mailConfig)
+	if err != nil {
+		log.Fatalf("Cannot create mailer %v", err)
+	}
+	mailQueue := mail.NewQueue(redisClient, mailer)
+
+	webAuthn, err := webauthn.New(config.WebAuthn())
+	if err != nil {
+		log.Fatalf("Cannot create webauthn relying party %v", err)
+	}
+
 	repo := repository.NewRepository(db)
 
-	service := service.NewService(repo, redisClient)
+	keys := service.NewSigningKeyService(repo)
+	if err := keys.Load(context.Background()); err != nil {
+		log.Fatalf("Cannot load signing keys %v", err)
+	}
+
+	service := service.NewService(repo, redisClient, service.NewOAuthRegistry(providers), mailQueue, webAuthn)
 
 	controller := controller.NewController(service)
 
@@ -51,6 +95,8 @@ func New() *App {
 		router: router,
 		db:     db,
 		rdb:    redisClient,
+		mail:   mailQueue,
+		keys:   keys,
 	}
 }
 
@@ -61,18 +107,68 @@ func initRoutes(ctrl controller.Controller) *chi.Mux {
 	r.Use(middleware.Recoverer)
 
 	r.Use(middlewares.RateLimit)
+	r.Use(middlewares.Locale)
+	r.Use(middlewares.DPoP)
+	r.Use(middlewares.SessionInfo)
 	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("Server is running!"))
 	})
-	r.Get("/google_login", func(w http.ResponseWriter, r *http.Request) {
-		controller.GoogleLogin(w, r)
+	oauth := ctrl.OAuth()
+	r.Get("/google_login", oauth.GoogleLogin)
+	r.Get("/google_callback", oauth.GoogleCallback)
+	r.Route("/.well-known", func(r chi.Router) {
+		router.WellKnownRoutes(r, ctrl.WellKnown())
 	})
 	r.Route("/user", func(r chi.Router) {
 		router.UserRoutes(r, ctrl.User())
+
+		r.Route("/me/identities", func(r chi.Router) {
+			router.IdentityRoutes(r, ctrl.Identity())
+		})
+
+		r.Route("/me/mfa", func(r chi.Router) {
+			router.MFARoutes(r, ctrl.MFA())
+		})
+
+		r.Route("/me/webauthn", func(r chi.Router) {
+			router.WebAuthnRoutes(r, ctrl.WebAuthn())
+		})
+
+		r.Route("/me/sessions", func(r chi.Router) {
+			router.SessionRoutes(r, ctrl.Session())
+		})
+
+		r.Route("/{id}/mfa", func(r chi.Router) {
+			router.AdminMFARoutes(r, ctrl.MFA())
+		})
+
+		r.Route("/{id}/sessions", func(r chi.Router) {
+			router.AdminSessionRoutes(r, ctrl.Session())
+		})
+	})
+	r.Route("/admin", func(r chi.Router) {
+		r.Route("/signing-keys", func(r chi.Router) {
+			router.SigningKeyRoutes(r, ctrl.SigningKey())
+		})
+	})
+	r.Route("/oauth", func(r chi.Router) {
+		router.OIDCRoutes(r, ctrl.OIDC())
+
+		r.Route("/clients", func(r chi.Router) {
+			router.OAuthClientRoutes(r, ctrl.OAuthClient())
+		})
 	})
 	r.Route("/auth", func(r chi.Router) {
 		router.AuthRoutes(r, ctrl.Auth())
+
+		r.Route("/oauth", func(r chi.Router) {
+			router.OAuthRoutes(r, ctrl.OAuth())
+		})
+
+		r.Route("/webauthn", func(r chi.Router) {
+			router.WebAuthnAuthRoutes(r, ctrl.WebAuthn())
+		})
 	})
 
 	return r
@@ -94,6 +190,9 @@ func (a *App) Start(ctx context.Context) error {
 		return fmt.Errorf("failed to connect to redis: %w", err)
 	}
 
+	go a.mail.Run(ctx)
+	go a.keys.Run(ctx)
+
 	fmt.Println("Starting server on:", port)
 
 	ch := make(chan error, 1)
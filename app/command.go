@@ -0,0 +1,32 @@
+package app
+
+import (
+	"context"
+	"fmt"
+
+	"auth/config"
+	"auth/internal/helper"
+	"auth/internal/repository"
+	"auth/internal/service"
+)
+
+// RunCommand handles the one-off subcommands, `auth rotate-keys` publishes a new signing key,
+// a running instance makes it active once the jwks caches have expired
+func RunCommand(ctx context.Context, args []string) error {
+	switch args[0] {
+	case "rotate-keys":
+		db := config.InitDb()
+		defer db.Close()
+
+		keys := service.NewSigningKeyService(repository.NewRepository(db))
+		key, err := keys.Rotate(ctx)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("New signing key %s, it signs once the key set caches expired in %s\n", key.KID, helper.JWKSMaxAge)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -12,6 +13,21 @@ import (
 
 var databaseInstance *sqlx.DB
 
+// BaseURL is the public url of this service, used to build links and callbacks
+func BaseURL() string {
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+	return strings.TrimSuffix(baseURL, "/")
+}
+
+// Development relaxes defaults that are only safe on a developer machine,
+// it has to be asked for with APP_ENV=development
+func Development() bool {
+	return os.Getenv("APP_ENV") == "development"
+}
+
 func InitDb() *sqlx.DB {
 	var err error
 
@@ -1,36 +1,36 @@
 package config
 
 import (
-	"log"
 	"os"
-
-	"github.com/joho/godotenv"
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
 )
 
-type Config struct {
-	GoogleLoginConfig oauth2.Config
-}
-
-var AppConfig Config
+// google keeps its env based setup, so existing deployments
+// do not need a providers file to keep google login working
+func googleProviderConfig() (OAuthProviderConfig, bool) {
+	clientID := os.Getenv("GOOGLE_CLIENT_ID")
+	if clientID == "" {
+		return OAuthProviderConfig{}, false
+	}
 
-func GoogleConfig() oauth2.Config {
-	err := godotenv.Load(".env")
-	if err != nil {
-		log.Fatalf("Some error occured. Err: %s", err)
+	redirectURL := os.Getenv("GOOGLE_REDIRECT_URL")
+	if redirectURL == "" {
+		redirectURL = "http://localhost:8080/google_callback"
 	}
 
-	AppConfig.GoogleLoginConfig = oauth2.Config{
-		RedirectURL:  "http://localhost:8080/google_callback",
-		ClientID:     os.Getenv("GOOGLE_CLIENT_ID"),
-		ClientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
-		Scopes: []string{
-			"https://www.googleapis.com/auth/userinfo.email",
-			"https://www.googleapis.com/auth/userinfo.profile",
-		},
-		Endpoint: google.Endpoint,
+	// issuer can be pointed to a local fake OIDC server,
+	// the endpoints are then taken from its discovery document
+	issuer := os.Getenv("GOOGLE_ISSUER")
+	if issuer == "" {
+		issuer = "https://accounts.google.com"
 	}
 
-	return AppConfig.GoogleLoginConfig
+	return OAuthProviderConfig{
+		Name:         "google",
+		Type:         OAuthTypeOIDC,
+		Issuer:       issuer,
+		ClientID:     clientID,
+		ClientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"openid", "email", "profile"},
+	}, true
 }
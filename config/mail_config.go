@@ -0,0 +1,48 @@
+package config
+
+import (
+	"errors"
+	"os"
+)
+
+type MailConfig struct {
+	Driver       string
+	From         string
+	Dir          string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+}
+
+// Mail reads the mailer settings. Outside development MAIL_DRIVER has to be
+// set, a deploy that forgets it must not quietly write reset links to disk.
+func Mail() (MailConfig, error) {
+	cfg := MailConfig{
+		Driver:       os.Getenv("MAIL_DRIVER"),
+		From:         os.Getenv("MAIL_FROM"),
+		Dir:          os.Getenv("MAIL_DIR"),
+		SMTPHost:     os.Getenv("SMTP_HOST"),
+		SMTPPort:     os.Getenv("SMTP_PORT"),
+		SMTPUsername: os.Getenv("SMTP_USERNAME"),
+		SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+	}
+
+	if cfg.Driver == "" {
+		if !Development() {
+			return MailConfig{}, errors.New("MAIL_DRIVER is required, the file driver is only a default with APP_ENV=development")
+		}
+		cfg.Driver = "file"
+	}
+	if cfg.From == "" {
+		cfg.From = "no-reply@localhost"
+	}
+	if cfg.Dir == "" {
+		cfg.Dir = "tmp/mail"
+	}
+	if cfg.SMTPPort == "" {
+		cfg.SMTPPort = "587"
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,26 @@
+package config
+
+import "testing"
+
+func TestMailNeedsDriverOutsideDevelopment(t *testing.T) {
+	t.Setenv("MAIL_DRIVER", "")
+	t.Setenv("APP_ENV", "")
+	if _, err := Mail(); err == nil {
+		t.Fatal("missing MAIL_DRIVER was accepted outside development")
+	}
+
+	t.Setenv("APP_ENV", "development")
+	cfg, err := Mail()
+	if err != nil {
+		t.Fatalf("Mail: %v", err)
+	}
+	if cfg.Driver != "file" {
+		t.Fatalf("driver = %q, want file in development", cfg.Driver)
+	}
+
+	t.Setenv("APP_ENV", "")
+	t.Setenv("MAIL_DRIVER", "smtp")
+	if cfg, err := Mail(); err != nil || cfg.Driver != "smtp" {
+		t.Fatalf("got %+v %v, want the smtp driver", cfg, err)
+	}
+}
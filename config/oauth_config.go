@@ -0,0 +1,133 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+const (
+	OAuthTypeOIDC   = "oidc"
+	OAuthTypeOAuth2 = "oauth2"
+)
+
+// OAuthClaimMapping tells which claim (dotted path for nested objects)
+// of the id token or userinfo response fills which user field
+type OAuthClaimMapping struct {
+	Subject       string `json:"subject"`
+	Email         string `json:"email"`
+	EmailVerified string `json:"email_verified"`
+	Name          string `json:"name"`
+	Username      string `json:"username"`
+}
+
+type OAuthProviderConfig struct {
+	Name         string            `json:"name"`
+	Type         string            `json:"type"`
+	Issuer       string            `json:"issuer"`
+	ClientID     string            `json:"client_id"`
+	ClientSecret string            `json:"client_secret"`
+	AuthURL      string            `json:"auth_url"`
+	TokenURL     string            `json:"token_url"`
+	UserInfoURL  string            `json:"userinfo_url"`
+	RedirectURL  string            `json:"redirect_url"`
+	Scopes       []string          `json:"scopes"`
+	Claims       OAuthClaimMapping `json:"claims"`
+}
+
+// OAuthProviders loads the social login providers from OAUTH_PROVIDERS_FILE.
+// ${VAR} references in the file are expanded from env, so secrets stay out of it.
+func OAuthProviders() ([]OAuthProviderConfig, error) {
+	path := os.Getenv("OAUTH_PROVIDERS_FILE")
+	if path == "" {
+		path = "oauth_providers.json"
+	}
+
+	providers := []OAuthProviderConfig{}
+
+	raw, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed reading %s: %w", path, err)
+	}
+	if err == nil {
+		if err := json.Unmarshal([]byte(os.ExpandEnv(string(raw))), &providers); err != nil {
+			return nil, fmt.Errorf("failed parsing %s: %w", path, err)
+		}
+	}
+
+	seen := map[string]bool{}
+	for i := range providers {
+		p := &providers[i]
+		if err := p.normalize(); err != nil {
+			return nil, err
+		}
+		if seen[p.Name] {
+			return nil, fmt.Errorf("oauth provider %q configured twice", p.Name)
+		}
+		seen[p.Name] = true
+	}
+
+	if google, ok := googleProviderConfig(); ok && !seen[google.Name] {
+		if err := google.normalize(); err != nil {
+			return nil, err
+		}
+		providers = append(providers, google)
+	}
+
+	return providers, nil
+}
+
+func (p *OAuthProviderConfig) normalize() error {
+	p.Name = strings.ToLower(strings.TrimSpace(p.Name))
+	if p.Name == "" {
+		return fmt.Errorf("oauth provider without name")
+	}
+	if p.ClientID == "" {
+		return fmt.Errorf("oauth provider %q: client_id is required", p.Name)
+	}
+
+	if p.Type == "" {
+		p.Type = OAuthTypeOIDC
+	}
+
+	switch p.Type {
+	case OAuthTypeOIDC:
+		if p.Issuer == "" {
+			return fmt.Errorf("oauth provider %q: issuer is required for oidc", p.Name)
+		}
+		if len(p.Scopes) == 0 {
+			p.Scopes = []string{"openid", "email", "profile"}
+		}
+	case OAuthTypeOAuth2:
+		if p.AuthURL == "" || p.TokenURL == "" || p.UserInfoURL == "" {
+			return fmt.Errorf("oauth provider %q: auth_url, token_url and userinfo_url are required for oauth2", p.Name)
+		}
+	default:
+		return fmt.Errorf("oauth provider %q: unknown type %q", p.Name, p.Type)
+	}
+
+	if p.RedirectURL == "" {
+		p.RedirectURL = BaseURL() + "/auth/oauth/" + p.Name + "/callback"
+	}
+
+	if p.Claims.Subject == "" {
+		p.Claims.Subject = "sub"
+	}
+	if p.Claims.Email == "" {
+		p.Claims.Email = "email"
+	}
+	if p.Claims.EmailVerified == "" {
+		p.Claims.EmailVerified = "email_verified"
+	}
+	if p.Claims.Name == "" {
+		p.Claims.Name = "name"
+	}
+	if p.Claims.Username == "" {
+		p.Claims.Username = "preferred_username"
+	}
+
+	return nil
+}
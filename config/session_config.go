@@ -0,0 +1,204 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration reads "30m", "12h" or "7d" from the policy file
+type Duration time.Duration
+
+func parseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := parseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", s, err)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// SessionLifetime bounds a session. Idle is how long it lives without a
+// refresh, Absolute how long it lives at all counted from the login.
+type SessionLifetime struct {
+	Idle     Duration `json:"idle"`
+	Absolute Duration `json:"absolute"`
+}
+
+const (
+	// a login over the cap fails until another session is signed out
+	SessionLimitReject = "reject"
+	// a login over the cap signs out the oldest session
+	SessionLimitEvictOldest = "evict_oldest"
+)
+
+// SessionPolicy is the lifetime of a normal login and of a "remember me" one.
+// MaxSessions caps the sessions a user has at once, unset inherits the cap of
+// the policy below and 0 turns it off. OnSessionLimit says what a login over
+// the cap does.
+type SessionPolicy struct {
+	SessionLifetime
+	Remember       SessionLifetime `json:"remember"`
+	MaxSessions    *int            `json:"max_sessions"`
+	OnSessionLimit string          `json:"on_session_limit"`
+}
+
+// SessionPolicies picks the policy of a session, a client entry wins over a
+// role entry and unset fields fall back to the default
+type SessionPolicies struct {
+	Default SessionPolicy            `json:"default"`
+	Roles   map[string]SessionPolicy `json:"roles"`
+	Clients map[string]SessionPolicy `json:"clients"`
+}
+
+// defaultSessionPolicy keeps the refresh lifetime of JWT_REFRESH_EXPIRED,
+// a session lives at least 30 days and never less than its idle lifetime
+func defaultSessionPolicy() (SessionPolicy, error) {
+	idle := 7 * 24 * time.Hour
+	if expiry := os.Getenv("JWT_REFRESH_EXPIRED"); expiry != "" {
+		v, err := parseDuration(expiry)
+		if err != nil {
+			return SessionPolicy{}, fmt.Errorf("invalid JWT_REFRESH_EXPIRED: %w", err)
+		}
+		idle = v
+	}
+
+	return SessionPolicy{
+		SessionLifetime: SessionLifetime{
+			Idle:     Duration(idle),
+			Absolute: Duration(max(idle, 30*24*time.Hour)),
+		},
+		Remember: SessionLifetime{
+			Idle:     Duration(30 * 24 * time.Hour),
+			Absolute: Duration(90 * 24 * time.Hour),
+		},
+		OnSessionLimit: SessionLimitReject,
+	}, nil
+}
+
+// SessionPolicyConfig loads SESSION_POLICY_FILE, without the file
+// every session gets the default policy
+func SessionPolicyConfig() (*SessionPolicies, error) {
+	path := os.Getenv("SESSION_POLICY_FILE")
+	if path == "" {
+		path = "session_policy.json"
+	}
+
+	policies := &SessionPolicies{}
+
+	raw, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed reading %s: %w", path, err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(raw, policies); err != nil {
+			return nil, fmt.Errorf("failed parsing %s: %w", path, err)
+		}
+	}
+
+	fallback, err := defaultSessionPolicy()
+	if err != nil {
+		return nil, err
+	}
+	policies.Default = policies.Default.merge(fallback)
+
+	if err := policies.Default.validate("default"); err != nil {
+		return nil, err
+	}
+	for role, policy := range policies.Roles {
+		if err := policy.merge(policies.Default).validate("role " + role); err != nil {
+			return nil, err
+		}
+	}
+	for clientID, policy := range policies.Clients {
+		if err := policy.merge(policies.Default).validate("client " + clientID); err != nil {
+			return nil, err
+		}
+	}
+
+	return policies, nil
+}
+
+// For is the policy of a session of the role, through the client when
+// clientID is set
+func (p *SessionPolicies) For(role string, clientID string) SessionPolicy {
+	policy := p.Default
+	if rolePolicy, ok := p.Roles[role]; ok {
+		policy = rolePolicy.merge(policy)
+	}
+	if clientID != "" {
+		if clientPolicy, ok := p.Clients[clientID]; ok {
+			policy = clientPolicy.merge(policy)
+		}
+	}
+	return policy
+}
+
+// Lifetime is the lifetime of a normal or a "remember me" session
+func (p SessionPolicy) Lifetime(remember bool) (time.Duration, time.Duration) {
+	if remember {
+		return time.Duration(p.Remember.Idle), time.Duration(p.Remember.Absolute)
+	}
+	return time.Duration(p.Idle), time.Duration(p.Absolute)
+}
+
+func (l SessionLifetime) merge(fallback SessionLifetime) SessionLifetime {
+	if l.Idle == 0 {
+		l.Idle = fallback.Idle
+	}
+	if l.Absolute == 0 {
+		l.Absolute = fallback.Absolute
+	}
+	return l
+}
+
+func (p SessionPolicy) merge(fallback SessionPolicy) SessionPolicy {
+	p.SessionLifetime = p.SessionLifetime.merge(fallback.SessionLifetime)
+	p.Remember = p.Remember.merge(fallback.Remember)
+	if p.MaxSessions == nil {
+		p.MaxSessions = fallback.MaxSessions
+	}
+	if p.OnSessionLimit == "" {
+		p.OnSessionLimit = fallback.OnSessionLimit
+	}
+	return p
+}
+
+func (p SessionPolicy) validate(name string) error {
+	if p.MaxSessions != nil && *p.MaxSessions < 0 {
+		return fmt.Errorf("session policy %s: max_sessions cannot be negative", name)
+	}
+	if p.OnSessionLimit != SessionLimitReject && p.OnSessionLimit != SessionLimitEvictOldest {
+		return fmt.Errorf("session policy %s: unknown on_session_limit %q", name, p.OnSessionLimit)
+	}
+	for _, l := range []SessionLifetime{p.SessionLifetime, p.Remember} {
+		if l.Idle <= 0 || l.Absolute <= 0 {
+			return fmt.Errorf("session policy %s: lifetimes must be positive", name)
+		}
+		if l.Idle > l.Absolute {
+			return fmt.Errorf("session policy %s: idle cannot be longer than absolute", name)
+		}
+	}
+	return nil
+}
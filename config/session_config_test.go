@@ -0,0 +1,94 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func loadTestPolicies(t *testing.T, raw string) *SessionPolicies {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "session_policy.json")
+	if err := os.WriteFile(path, []byte(raw), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SESSION_POLICY_FILE", path)
+	t.Setenv("JWT_REFRESH_EXPIRED", "")
+
+	policies, err := SessionPolicyConfig()
+	if err != nil {
+		t.Fatalf("SessionPolicyConfig: %v", err)
+	}
+	return policies
+}
+
+func maxSessions(p SessionPolicy) int {
+	if p.MaxSessions == nil {
+		return -1
+	}
+	return *p.MaxSessions
+}
+
+func TestSessionPolicyCapOverrides(t *testing.T) {
+	policies := loadTestPolicies(t, `{
+		"default": {"max_sessions": 3},
+		"roles": {
+			"admin": {"max_sessions": 0},
+			"user": {"idle": "1h"}
+		},
+		"clients": {
+			"kiosk": {"max_sessions": 1}
+		}
+	}`)
+
+	cases := []struct {
+		role, client string
+		want         int
+	}{
+		{"admin", "", 0},
+		{"user", "", 3},
+		{"support", "", 3},
+		{"admin", "kiosk", 1},
+		{"user", "other-client", 3},
+	}
+	for _, tc := range cases {
+		if got := maxSessions(policies.For(tc.role, tc.client)); got != tc.want {
+			t.Errorf("%s through %q: max_sessions %d, want %d", tc.role, tc.client, got, tc.want)
+		}
+	}
+}
+
+func TestSessionPolicyRejectsNegativeCap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session_policy.json")
+	if err := os.WriteFile(path, []byte(`{"roles": {"user": {"max_sessions": -1}}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SESSION_POLICY_FILE", path)
+
+	if _, err := SessionPolicyConfig(); err == nil {
+		t.Fatal("negative max_sessions was accepted")
+	}
+}
+
+// a refresh lifetime over the 30 day default must not fail the idle <= absolute check
+func TestDefaultSessionPolicyFollowsRefreshExpiry(t *testing.T) {
+	t.Setenv("SESSION_POLICY_FILE", filepath.Join(t.TempDir(), "missing.json"))
+
+	for expiry, want := range map[string]time.Duration{
+		"":    30 * 24 * time.Hour,
+		"14d": 30 * 24 * time.Hour,
+		"60d": 60 * 24 * time.Hour,
+	} {
+		t.Setenv("JWT_REFRESH_EXPIRED", expiry)
+
+		policies, err := SessionPolicyConfig()
+		if err != nil {
+			t.Fatalf("JWT_REFRESH_EXPIRED=%q: %v", expiry, err)
+		}
+		if _, absolute := policies.Default.Lifetime(false); absolute != want {
+			t.Fatalf("JWT_REFRESH_EXPIRED=%q: absolute %s, want %s", expiry, absolute, want)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// WebAuthn builds the relying party config, the rp id defaults to the
+// host of BASE_URL and the allowed origins to BASE_URL itself
+func WebAuthn() *webauthn.Config {
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		rpID = "localhost"
+		if u, err := url.Parse(BaseURL()); err == nil && u.Hostname() != "" {
+			rpID = u.Hostname()
+		}
+	}
+
+	rpName := os.Getenv("WEBAUTHN_RP_NAME")
+	if rpName == "" {
+		rpName = "auth-service"
+	}
+
+	origins := []string{}
+	for _, origin := range strings.Split(os.Getenv("WEBAUTHN_RP_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, strings.TrimSuffix(origin, "/"))
+		}
+	}
+	if len(origins) == 0 {
+		origins = []string{BaseURL()}
+	}
+
+	return &webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: rpName,
+		RPOrigins:     origins,
+	}
+}
+
+// WebAuthnReauthMaxAge is how long after a full login a passkey may still be
+// registered without signing in again, WEBAUTHN_REAUTH_MAX_AGE defaults to 5m
+func WebAuthnReauthMaxAge() time.Duration {
+	if maxAge, err := parseDuration(os.Getenv("WEBAUTHN_REAUTH_MAX_AGE")); err == nil && maxAge > 0 {
+		return maxAge
+	}
+	return 5 * time.Minute
+}
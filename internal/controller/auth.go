@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"auth/internal/helper"
 	"auth/internal/model"
@@ -29,6 +30,10 @@ func (h *AuthController) Register(w http.ResponseWriter, r *http.Request) {
 	s := h.service.Auth()
 	res, err := s.Create(r.Context(), user)
 	if err != nil {
+		if errors.Is(err, service.ErrEmailTaken) {
+			helper.RespondError(w, http.StatusConflict, err)
+			return
+		}
 		helper.RespondError(w, http.StatusBadRequest, err)
 		return
 	}
@@ -37,34 +42,95 @@ func (h *AuthController) Register(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *AuthController) Login(w http.ResponseWriter, r *http.Request) {
-	user := model.User{}
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+	body := model.Logincredential{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		helper.RespondError(w, http.StatusBadRequest, err)
 		return
 	}
+	user := model.User{Username: body.Username, Password: body.Password}
+
+	ctx := helper.WithRememberMe(r.Context(), body.RememberMe)
 
 	s := h.service.Auth()
-	res, refreshToken, token, err := s.Login(r.Context(), user)
+	res, refreshToken, token, err := s.Login(ctx, user)
 	if err != nil {
-		helper.RespondError(w, http.StatusUnauthorized, err)
+		if respondMFARequired(w, err) {
+			return
+		}
+		helper.RespondError(w, loginErrorStatus(err), err)
 		return
 	}
 
-	cookie := http.Cookie{
-		Name:     "refresh_token",
-		Value:    refreshToken,
-		MaxAge:   3600 * 24 * 7,
+	setRefreshCookie(w, refreshToken)
+	helper.RespondSuccess(w, http.StatusOK, res, &token)
+}
+
+// loginErrorStatus is 401 for a failed login, a login refused by the
+// concurrent session cap is a conflict the user can resolve
+func loginErrorStatus(err error) int {
+	if errors.Is(err, service.ErrSessionLimit) {
+		return http.StatusConflict
+	}
+	return http.StatusUnauthorized
+}
+
+// respondMFARequired answers a login that still needs the second factor
+func respondMFARequired(w http.ResponseWriter, err error) bool {
+	var mfaErr *service.MFARequiredError
+	if !errors.As(err, &mfaErr) {
+		return false
+	}
+
+	helper.RespondSuccess(w, http.StatusOK, model.MFAChallenge{
+		MFARequired: true,
+		MFAToken:    mfaErr.Token,
+		Methods:     mfaErr.Methods,
+	}, nil)
+	return true
+}
+
+// the refresh cookie only goes where it is read: refresh and logout under
+// /auth, authorize and the device page under /oauth, where it is the
+// session, and the session list and password change under /user/me
+const refreshCookie = "refresh_token"
+
+var refreshCookiePaths = []string{"/auth", "/oauth", "/user/me"}
+
+// setRefreshCookie lives as long as the token, which follows the session policy
+func setRefreshCookie(w http.ResponseWriter, refreshToken string) {
+	writeRefreshCookie(w, refreshToken, helper.RefreshCookieMaxAge(refreshToken))
+}
+
+func clearRefreshCookie(w http.ResponseWriter) {
+	writeRefreshCookie(w, "", -1)
+}
+
+func writeRefreshCookie(w http.ResponseWriter, value string, maxAge int) {
+	for _, path := range refreshCookiePaths {
+		http.SetCookie(w, &http.Cookie{
+			Name:     refreshCookie,
+			Value:    value,
+			Path:     path,
+			MaxAge:   maxAge,
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+
+	// older releases set it on /, drop that one or it keeps going everywhere
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshCookie,
+		Path:     "/",
+		MaxAge:   -1,
 		HttpOnly: true,
 		Secure:   true,
 		SameSite: http.SameSiteLaxMode,
-	}
-
-	http.SetCookie(w, &cookie)
-	helper.RespondSuccess(w, http.StatusOK, res, &token)
+	})
 }
 
 func (h *AuthController) RefreshToken(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie("refresh_token")
+	cookie, err := r.Cookie(refreshCookie)
 	if err != nil {
 		switch {
 		case errors.Is(err, http.ErrNoCookie):
@@ -72,9 +138,9 @@ func (h *AuthController) RefreshToken(w http.ResponseWriter, r *http.Request) {
 			return
 		default:
 			helper.RespondError(w, http.StatusInternalServerError, err)
+			return
 		}
 	}
-	fmt.Println(cookie.Value)
 
 	s := h.service.Auth()
 	newRefreshToken, newAccessToken, tokenErr := s.RefreshToken(r.Context(), cookie.Value)
@@ -82,22 +148,13 @@ func (h *AuthController) RefreshToken(w http.ResponseWriter, r *http.Request) {
 		helper.RespondError(w, http.StatusUnauthorized, tokenErr)
 		return
 	}
-	newCookie := http.Cookie{
-		Name:     "refresh_token",
-		Value:    newRefreshToken,
-		MaxAge:   3600 * 24 * 7,
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteLaxMode,
-	}
-
-	http.SetCookie(w, &newCookie)
+	setRefreshCookie(w, newRefreshToken)
 
 	helper.RespondSuccess(w, http.StatusAccepted, nil, &newAccessToken)
 }
 
 func (h *AuthController) Logout(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie("refresh_token")
+	cookie, err := r.Cookie(refreshCookie)
 	if err != nil {
 		switch {
 		case errors.Is(err, http.ErrNoCookie):
@@ -109,10 +166,168 @@ func (h *AuthController) Logout(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	accessToken := ""
+	if parts := strings.Split(r.Header.Get("Authorization"), " "); len(parts) == 2 && (parts[0] == "Bearer" || parts[0] == "DPoP") {
+		accessToken = parts[1]
+	}
+
 	s := h.service.Auth()
-	if err := s.Logout(r.Context(), cookie.Value); err != nil {
+	if err := s.Logout(r.Context(), cookie.Value, accessToken); err != nil {
 		helper.RespondError(w, http.StatusBadRequest, err)
 		return
 	}
+	clearRefreshCookie(w)
 	helper.RespondSuccess(w, http.StatusOK, nil, nil)
 }
+
+func (h *AuthController) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	s := h.service.Auth()
+	if err := s.VerifyEmail(r.Context(), r.URL.Query().Get("token")); err != nil {
+		helper.RespondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	helper.RespondSuccess(w, http.StatusOK, nil, nil)
+}
+
+func (h *AuthController) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	body := model.VerifyEmail{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		helper.RespondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s := h.service.Auth()
+	if err := s.ResendVerification(r.Context(), body.Email); err != nil {
+		if errors.Is(err, service.ErrRateLimited) {
+			helper.RespondError(w, http.StatusTooManyRequests, err)
+			return
+		}
+		helper.RespondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	helper.RespondSuccess(w, http.StatusAccepted, nil, nil)
+}
+
+func (h *AuthController) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	body := model.ForgotPassword{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		helper.RespondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s := h.service.Auth()
+	if err := s.ForgotPassword(r.Context(), body.Email); err != nil {
+		helper.RespondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	helper.RespondSuccess(w, http.StatusAccepted, nil, nil)
+}
+
+func (h *AuthController) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	body := model.ResetPassword{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		helper.RespondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s := h.service.Auth()
+	if err := s.ResetPassword(r.Context(), body.Token, body.Password); err != nil {
+		helper.RespondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	helper.RespondSuccess(w, http.StatusOK, nil, nil)
+}
+
+const magicLinkCookie = "magic_link_binding"
+
+// RequestMagicLink always answers 202 and sets the binding cookie,
+// the emailed link only works in a browser holding that cookie
+func (h *AuthController) RequestMagicLink(w http.ResponseWriter, r *http.Request) {
+	body := model.MagicLinkRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		helper.RespondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s := h.service.Auth()
+	binding, err := s.RequestMagicLink(r.Context(), body.Email)
+	if err != nil {
+		helper.RespondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	expiry, err := helper.MagicLinkExpiry()
+	if err != nil {
+		helper.RespondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     magicLinkCookie,
+		Value:    binding,
+		Path:     "/auth/magic-link",
+		MaxAge:   int(expiry.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	helper.RespondSuccess(w, http.StatusAccepted, nil, nil)
+}
+
+func (h *AuthController) ConsumeMagicLink(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(magicLinkCookie)
+	if err != nil {
+		helper.RespondError(w, http.StatusBadRequest, fmt.Errorf("magic link must be opened in the browser that requested it"))
+		return
+	}
+
+	s := h.service.Auth()
+	res, refreshToken, token, err := s.ConsumeMagicLink(r.Context(), r.URL.Query().Get("token"), cookie.Value)
+	if err != nil {
+		if respondMFARequired(w, err) {
+			clearMagicLinkCookie(w)
+			return
+		}
+		helper.RespondError(w, loginErrorStatus(err), err)
+		return
+	}
+
+	clearMagicLinkCookie(w)
+	setRefreshCookie(w, refreshToken)
+	helper.RespondSuccess(w, http.StatusOK, res, &token)
+}
+
+func clearMagicLinkCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     magicLinkCookie,
+		Value:    "",
+		Path:     "/auth/magic-link",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (h *AuthController) VerifyMFA(w http.ResponseWriter, r *http.Request) {
+	body := model.MFAVerify{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		helper.RespondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s := h.service.MFA()
+	res, refreshToken, token, err := s.Verify(r.Context(), body)
+	if err != nil {
+		helper.RespondError(w, loginErrorStatus(err), err)
+		return
+	}
+
+	setRefreshCookie(w, refreshToken)
+	helper.RespondSuccess(w, http.StatusOK, res, &token)
+}
@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestRefreshCookieOnlyGoesWhereItIsRead(t *testing.T) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, _ := url.Parse("https://auth.example.com/auth/login")
+
+	w := httptest.NewRecorder()
+	setRefreshCookie(w, "refresh-token")
+	jar.SetCookies(base, w.Result().Cookies())
+
+	sent := func(path string) bool {
+		u, _ := url.Parse("https://auth.example.com" + path)
+		for _, cookie := range jar.Cookies(u) {
+			if cookie.Name == refreshCookie && cookie.Value == "refresh-token" {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, path := range []string{"/auth/refresh", "/auth/logout", "/oauth/authorize", "/oauth/device", "/user/me/sessions", "/user/me/password"} {
+		if !sent(path) {
+			t.Errorf("refresh cookie is not sent to %s", path)
+		}
+	}
+	for _, path := range []string{"/", "/user/7", "/admin/signing-keys", "/.well-known/jwks.json"} {
+		if sent(path) {
+			t.Errorf("refresh cookie is sent to %s", path)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	clearRefreshCookie(w)
+	jar.SetCookies(base, w.Result().Cookies())
+	for _, path := range []string{"/auth/refresh", "/oauth/authorize", "/user/me/sessions"} {
+		if sent(path) {
+			t.Errorf("refresh cookie survived logout at %s", path)
+		}
+	}
+}
+
+func TestRefreshCookieDropsTheOldRootCookie(t *testing.T) {
+	w := httptest.NewRecorder()
+	setRefreshCookie(w, "refresh-token")
+
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Path == "/" {
+			if cookie.MaxAge >= 0 || cookie.Value != "" {
+				t.Fatalf("root cookie is set again: %+v", cookie)
+			}
+			return
+		}
+	}
+	t.Fatal("the refresh cookie of older releases is not removed")
+}
+
+func TestRefreshTokenWithoutCookie(t *testing.T) {
+	w := httptest.NewRecorder()
+	(&AuthController{}).RefreshToken(w, httptest.NewRequest(http.MethodPost, "/auth/refresh", nil))
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
@@ -7,6 +7,15 @@ import (
 type Controller interface {
 	User() UserController
 	Auth() AuthController
+	OAuth() OAuthController
+	Identity() IdentityController
+	MFA() MFAController
+	WebAuthn() WebAuthnController
+	WellKnown() WellKnownController
+	SigningKey() SigningKeyController
+	OIDC() OIDCController
+	OAuthClient() OAuthClientController
+	Session() SessionController
 }
 type controller struct {
 	srv service.Service
@@ -22,6 +31,42 @@ func (c *controller) Auth() AuthController {
 	return AuthController{service: c.srv}
 }
 
+func (c *controller) OAuth() OAuthController {
+	return OAuthController{service: c.srv}
+}
+
+func (c *controller) Identity() IdentityController {
+	return IdentityController{service: c.srv}
+}
+
+func (c *controller) MFA() MFAController {
+	return MFAController{service: c.srv}
+}
+
+func (c *controller) WebAuthn() WebAuthnController {
+	return WebAuthnController{service: c.srv}
+}
+
+func (c *controller) WellKnown() WellKnownController {
+	return WellKnownController{service: c.srv}
+}
+
+func (c *controller) SigningKey() SigningKeyController {
+	return SigningKeyController{service: c.srv}
+}
+
+func (c *controller) OIDC() OIDCController {
+	return OIDCController{service: c.srv}
+}
+
+func (c *controller) OAuthClient() OAuthClientController {
+	return OAuthClientController{service: c.srv}
+}
+
+func (c *controller) Session() SessionController {
+	return SessionController{service: c.srv}
+}
+
 func (c *controller) User() UserController {
 	return UserController{service: c.srv}
 }
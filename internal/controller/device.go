@@ -0,0 +1,197 @@
+package controller
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+
+	"auth/internal/helper"
+	"auth/internal/model"
+	"auth/internal/service"
+)
+
+const deviceCSRFCookie = "device_csrf"
+
+var devicePage = template.Must(template.New("device").Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Connect a device</title>
+</head>
+<body>
+<main>
+<h1>Connect a device</h1>
+{{if .Message}}<p>{{.Message}}</p>{{end}}
+{{if .Prompt}}
+<p><strong>{{.Prompt.ClientName}}</strong> is asking to sign in as you with the code <strong>{{.Prompt.UserCode}}</strong>.</p>
+{{if .Prompt.Scopes}}<p>It will get access to:</p>
+<ul>{{range .Prompt.Scopes}}<li>{{.}}</li>{{end}}</ul>{{end}}
+<p>Only continue if the same code is shown on your device.</p>
+<form method="post" action="/oauth/device">
+<input type="hidden" name="user_code" value="{{.Prompt.UserCode}}">
+<input type="hidden" name="csrf" value="{{.CSRF}}">
+<button type="submit" name="action" value="approve">Approve</button>
+<button type="submit" name="action" value="deny">Deny</button>
+</form>
+{{else if .AskCode}}
+<form method="get" action="/oauth/device">
+<label for="user_code">Enter the code shown on your device</label>
+<input id="user_code" name="user_code" autocomplete="off" autofocus>
+<button type="submit">Continue</button>
+</form>
+{{end}}
+</main>
+</body>
+</html>
+`))
+
+type devicePageData struct {
+	Message string
+	Prompt  *model.DevicePrompt
+	CSRF    string
+	AskCode bool
+}
+
+func renderDevicePage(w http.ResponseWriter, status int, data devicePageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; form-action 'self'; frame-ancestors 'none'")
+	w.WriteHeader(status)
+
+	if err := devicePage.Execute(w, data); err != nil {
+		log.Printf("device page: %v", err)
+	}
+}
+
+func refreshSession(r *http.Request) string {
+	if cookie, err := r.Cookie(refreshCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+func isLoginRequired(err error) bool {
+	var oauthErr *service.OAuthError
+	return errors.As(err, &oauthErr) && oauthErr.Code == "login_required"
+}
+
+// deviceError picks the page for a failed lookup or decision,
+// a missing session goes to the login page when one is configured
+func (h *OIDCController) deviceError(w http.ResponseWriter, r *http.Request, userCode string, err error) {
+	s := h.service.OIDC()
+
+	switch {
+	case isLoginRequired(err):
+		if loginURL := s.DeviceLoginURL(userCode); loginURL != "" {
+			http.Redirect(w, r, loginURL, http.StatusFound)
+			return
+		}
+		renderDevicePage(w, http.StatusUnauthorized, devicePageData{Message: "Log in first, then open this page again."})
+	case errors.Is(err, service.ErrDeviceCodeNotFound):
+		renderDevicePage(w, http.StatusNotFound, devicePageData{Message: "That code is invalid or has expired.", AskCode: true})
+	case errors.Is(err, service.ErrDeviceCodeUsed):
+		renderDevicePage(w, http.StatusConflict, devicePageData{Message: "That code was already used."})
+	default:
+		log.Printf("device page: %v", err)
+		renderDevicePage(w, http.StatusInternalServerError, devicePageData{Message: "Something went wrong, try again."})
+	}
+}
+
+func (h *OIDCController) DeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondOAuthError(w, http.StatusBadRequest, &service.OAuthError{Code: "invalid_request", Description: err.Error()})
+		return
+	}
+
+	req := model.DeviceAuthorizationRequest{
+		Scope: r.PostForm.Get("scope"),
+	}
+
+	var err error
+	if req.ClientID, req.ClientSecret, err = clientAuth(r); err != nil {
+		respondOAuthError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s := h.service.OIDC()
+	res, err := s.DeviceAuthorization(r.Context(), req)
+	if err != nil {
+		respondOAuthError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	helper.RespondJSON(w, http.StatusOK, res)
+}
+
+// DevicePage asks for the user code, or shows what the device asks for
+func (h *OIDCController) DevicePage(w http.ResponseWriter, r *http.Request) {
+	userCode := r.URL.Query().Get("user_code")
+	if userCode == "" {
+		renderDevicePage(w, http.StatusOK, devicePageData{AskCode: true})
+		return
+	}
+
+	s := h.service.OIDC()
+	prompt, err := s.DevicePrompt(r.Context(), userCode, refreshSession(r))
+	if err != nil {
+		h.deviceError(w, r, userCode, err)
+		return
+	}
+
+	// double submit, the form must come back with the value of the cookie
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		h.deviceError(w, r, userCode, err)
+		return
+	}
+	csrf := base64.RawURLEncoding.EncodeToString(b)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     deviceCSRFCookie,
+		Value:    csrf,
+		Path:     "/oauth/device",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	renderDevicePage(w, http.StatusOK, devicePageData{Prompt: prompt, CSRF: csrf})
+}
+
+func (h *OIDCController) DeviceDecide(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		renderDevicePage(w, http.StatusBadRequest, devicePageData{Message: "Invalid request.", AskCode: true})
+		return
+	}
+
+	cookie, err := r.Cookie(deviceCSRFCookie)
+	if err != nil || cookie.Value == "" ||
+		subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.PostForm.Get("csrf"))) != 1 {
+		renderDevicePage(w, http.StatusForbidden, devicePageData{Message: "The form expired, enter the code again.", AskCode: true})
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: deviceCSRFCookie, Path: "/oauth/device", MaxAge: -1})
+
+	userCode := r.PostForm.Get("user_code")
+	approve := r.PostForm.Get("action") == "approve"
+
+	s := h.service.OIDC()
+	if err := s.DecideDevice(r.Context(), userCode, approve, refreshSession(r)); err != nil {
+		h.deviceError(w, r, userCode, err)
+		return
+	}
+
+	message := "Request denied, the device was not signed in."
+	if approve {
+		message = "Done, you can go back to your device."
+	}
+	renderDevicePage(w, http.StatusOK, devicePageData{Message: message})
+}
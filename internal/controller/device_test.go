@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// the decision form must come back with the value of the csrf cookie,
+// anything else is refused before the code is looked at
+func TestDeviceDecideChecksCSRF(t *testing.T) {
+	form := url.Values{"user_code": {"BCDF-GHJK"}, "action": {"approve"}, "csrf": {"form-value"}}
+
+	for name, cookie := range map[string]*http.Cookie{
+		"no cookie":    nil,
+		"empty cookie": {Name: deviceCSRFCookie, Value: ""},
+		"other value":  {Name: deviceCSRFCookie, Value: "cookie-value"},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/oauth/device", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+
+		(&OIDCController{}).DeviceDecide(w, r)
+
+		if w.Code != http.StatusForbidden {
+			t.Fatalf("%s: status %d, want %d", name, w.Code, http.StatusForbidden)
+		}
+	}
+}
+
+func TestDevicePageAsksForCode(t *testing.T) {
+	w := httptest.NewRecorder()
+	(&OIDCController{}).DevicePage(w, httptest.NewRequest(http.MethodGet, "/oauth/device", nil))
+
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `name="user_code"`) {
+		t.Fatalf("status %d, body %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("X-Frame-Options") != "DENY" {
+		t.Fatal("the verification page may be framed")
+	}
+}
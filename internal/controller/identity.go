@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"auth/internal/helper"
+	middlewares "auth/internal/middleware"
+	"auth/internal/repository"
+	"auth/internal/service"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type IdentityController struct {
+	service service.Service
+}
+
+func NewIdentityController(s service.Service) *IdentityController {
+	return &IdentityController{service: s}
+}
+
+func (h *IdentityController) GetMany(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middlewares.UserIDFromContext(r.Context())
+	if !ok {
+		helper.RespondError(w, http.StatusUnauthorized, fmt.Errorf("user not found in context"))
+		return
+	}
+
+	s := h.service.Identity()
+	res, err := s.GetByUserId(r.Context(), userID)
+	if err != nil {
+		helper.RespondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	helper.RespondSuccess(w, http.StatusOK, res, nil)
+}
+
+// Link returns the provider url, the client sends the browser there
+// and the provider callback finishes the link. The binding cookie set here
+// must come back with the callback, so it has to be the same browser.
+func (h *IdentityController) Link(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middlewares.UserIDFromContext(r.Context())
+	if !ok {
+		helper.RespondError(w, http.StatusUnauthorized, fmt.Errorf("user not found in context"))
+		return
+	}
+
+	s := h.service.OAuth()
+	url, binding, err := s.LinkURL(r.Context(), chi.URLParam(r, "provider"), userID)
+	if err != nil {
+		if errors.Is(err, service.ErrUnknownProvider) {
+			helper.RespondError(w, http.StatusNotFound, err)
+			return
+		}
+		helper.RespondError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	setOAuthBindingCookie(w, binding)
+	helper.RespondSuccess(w, http.StatusOK, map[string]string{"url": url}, nil)
+}
+
+func (h *IdentityController) Unlink(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middlewares.UserIDFromContext(r.Context())
+	if !ok {
+		helper.RespondError(w, http.StatusUnauthorized, fmt.Errorf("user not found in context"))
+		return
+	}
+
+	s := h.service.Identity()
+	if err := s.Unlink(r.Context(), userID, chi.URLParam(r, "provider")); err != nil {
+		switch {
+		case errors.Is(err, service.ErrIdentityNotFound):
+			helper.RespondError(w, http.StatusNotFound, err)
+		case errors.Is(err, repository.ErrLastLoginMethod):
+			helper.RespondError(w, http.StatusConflict, err)
+		default:
+			helper.RespondError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	helper.RespondSuccess(w, http.StatusOK, nil, nil)
+}
@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"auth/internal/helper"
+	middlewares "auth/internal/middleware"
+	"auth/internal/model"
+	"auth/internal/repository"
+	"auth/internal/service"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type MFAController struct {
+	service service.Service
+}
+
+func NewMFAController(s service.Service) *MFAController {
+	return &MFAController{service: s}
+}
+
+func (h *MFAController) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middlewares.UserIDFromContext(r.Context())
+	if !ok {
+		helper.RespondError(w, http.StatusUnauthorized, fmt.Errorf("user not found in context"))
+		return
+	}
+
+	s := h.service.MFA()
+	res, err := s.EnrollTOTP(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrMFAEnabled) {
+			helper.RespondError(w, http.StatusConflict, err)
+			return
+		}
+		helper.RespondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	helper.RespondSuccess(w, http.StatusOK, res, nil)
+}
+
+func (h *MFAController) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middlewares.UserIDFromContext(r.Context())
+	if !ok {
+		helper.RespondError(w, http.StatusUnauthorized, fmt.Errorf("user not found in context"))
+		return
+	}
+
+	body := model.TOTPConfirm{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		helper.RespondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s := h.service.MFA()
+	codes, err := s.ConfirmTOTP(r.Context(), userID, body.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrMFAEnabled):
+			helper.RespondError(w, http.StatusConflict, err)
+		case errors.Is(err, service.ErrInvalidMFACode), errors.Is(err, service.ErrMFANotEnrolled):
+			helper.RespondError(w, http.StatusBadRequest, err)
+		default:
+			helper.RespondError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	helper.RespondSuccess(w, http.StatusOK, map[string][]string{"recovery_codes": codes}, nil)
+}
+
+func (h *MFAController) Reset(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, strErr := strconv.Atoi(idStr)
+	if strErr != nil {
+		helper.RespondError(w, http.StatusBadRequest, strErr)
+		return
+	}
+
+	s := h.service.MFA()
+	if err := s.Reset(r.Context(), id); err != nil {
+		helper.RespondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	helper.RespondSuccess(w, http.StatusOK, nil, nil)
+}
@@ -1,12 +1,124 @@
 package controller
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 
-	"auth/config"
+	"auth/internal/helper"
+	"auth/internal/service"
+
+	"github.com/go-chi/chi/v5"
 )
 
-func GoogleLogin(w http.ResponseWriter, r *http.Request) {
-	url := config.AppConfig.GoogleLoginConfig.AuthCodeURL("randomstate")
+type OAuthController struct {
+	service service.Service
+}
+
+func NewOAuthController(s service.Service) *OAuthController {
+	return &OAuthController{service: s}
+}
+
+func (h *OAuthController) Login(w http.ResponseWriter, r *http.Request) {
+	h.login(w, r, chi.URLParam(r, "provider"))
+}
+
+func (h *OAuthController) Callback(w http.ResponseWriter, r *http.Request) {
+	h.callback(w, r, chi.URLParam(r, "provider"))
+}
+
+// GoogleLogin and GoogleCallback keep the old top level google routes working
+func (h *OAuthController) GoogleLogin(w http.ResponseWriter, r *http.Request) {
+	h.login(w, r, "google")
+}
+
+func (h *OAuthController) GoogleCallback(w http.ResponseWriter, r *http.Request) {
+	h.callback(w, r, "google")
+}
+
+// the cookie ties a provider callback to the browser that started the flow,
+// path is / because the old google callback lives at the top level
+const oauthBindingCookie = "oauth_binding"
+
+func setOAuthBindingCookie(w http.ResponseWriter, binding string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthBindingCookie,
+		Value:    binding,
+		Path:     "/",
+		MaxAge:   int(service.OAuthStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearOAuthBindingCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthBindingCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (h *OAuthController) login(w http.ResponseWriter, r *http.Request, provider string) {
+	s := h.service.OAuth()
+	url, binding, err := s.AuthURL(r.Context(), provider)
+	if err != nil {
+		if errors.Is(err, service.ErrUnknownProvider) {
+			helper.RespondError(w, http.StatusNotFound, err)
+			return
+		}
+		helper.RespondError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	setOAuthBindingCookie(w, binding)
 	http.Redirect(w, r, url, http.StatusSeeOther)
 }
+
+func (h *OAuthController) callback(w http.ResponseWriter, r *http.Request, provider string) {
+	query := r.URL.Query()
+	if oauthErr := query.Get("error"); oauthErr != "" {
+		helper.RespondError(w, http.StatusUnauthorized, fmt.Errorf("%s login failed: %s", provider, oauthErr))
+		return
+	}
+
+	binding := ""
+	if cookie, err := r.Cookie(oauthBindingCookie); err == nil {
+		binding = cookie.Value
+	}
+
+	s := h.service.OAuth()
+	res, refreshToken, token, err := s.Callback(r.Context(), provider, query.Get("state"), query.Get("code"), binding)
+	clearOAuthBindingCookie(w)
+	if err != nil {
+		if respondMFARequired(w, err) {
+			return
+		}
+
+		switch {
+		case errors.Is(err, service.ErrUnknownProvider):
+			helper.RespondError(w, http.StatusNotFound, err)
+		case errors.Is(err, service.ErrIdentityLinked):
+			helper.RespondError(w, http.StatusConflict, err)
+		case errors.Is(err, service.ErrOAuthStateBinding):
+			helper.RespondError(w, http.StatusBadRequest, err)
+		default:
+			helper.RespondError(w, loginErrorStatus(err), err)
+		}
+		return
+	}
+
+	// account link, the user keeps the session it already has
+	if refreshToken == "" {
+		helper.RespondSuccess(w, http.StatusOK, res, nil)
+		return
+	}
+
+	setRefreshCookie(w, refreshToken)
+	helper.RespondSuccess(w, http.StatusOK, res, &token)
+}
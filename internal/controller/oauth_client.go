@@ -0,0 +1,113 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"auth/internal/helper"
+	"auth/internal/model"
+	"auth/internal/service"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type OAuthClientController struct {
+	service service.Service
+}
+
+func NewOAuthClientController(s service.Service) *OAuthClientController {
+	return &OAuthClientController{service: s}
+}
+
+func respondOAuthClientError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrOAuthClientNotFound):
+		helper.RespondError(w, http.StatusNotFound, err)
+	case errors.Is(err, service.ErrOAuthClientExists):
+		helper.RespondError(w, http.StatusConflict, err)
+	case errors.Is(err, service.ErrInvalidOAuthClient):
+		helper.RespondError(w, http.StatusBadRequest, err)
+	default:
+		helper.RespondError(w, http.StatusInternalServerError, err)
+	}
+}
+
+func (h *OAuthClientController) Create(w http.ResponseWriter, r *http.Request) {
+	var body model.OAuthClientInput
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		helper.RespondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s := h.service.OAuthClient()
+	res, err := s.Create(r.Context(), body)
+	if err != nil {
+		respondOAuthClientError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	helper.RespondSuccess(w, http.StatusCreated, res, nil)
+}
+
+func (h *OAuthClientController) GetMany(w http.ResponseWriter, r *http.Request) {
+	s := h.service.OAuthClient()
+	res, err := s.GetMany(r.Context())
+	if err != nil {
+		helper.RespondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	helper.RespondSuccess(w, http.StatusOK, res, nil)
+}
+
+func (h *OAuthClientController) GetByClientId(w http.ResponseWriter, r *http.Request) {
+	s := h.service.OAuthClient()
+	res, err := s.GetByClientId(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		respondOAuthClientError(w, err)
+		return
+	}
+
+	helper.RespondSuccess(w, http.StatusOK, res, nil)
+}
+
+func (h *OAuthClientController) Update(w http.ResponseWriter, r *http.Request) {
+	var body model.OAuthClientInput
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		helper.RespondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s := h.service.OAuthClient()
+	res, err := s.Update(r.Context(), chi.URLParam(r, "id"), body)
+	if err != nil {
+		respondOAuthClientError(w, err)
+		return
+	}
+
+	helper.RespondSuccess(w, http.StatusOK, res, nil)
+}
+
+func (h *OAuthClientController) RotateSecret(w http.ResponseWriter, r *http.Request) {
+	s := h.service.OAuthClient()
+	res, err := s.RotateSecret(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		respondOAuthClientError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	helper.RespondSuccess(w, http.StatusOK, res, nil)
+}
+
+func (h *OAuthClientController) Delete(w http.ResponseWriter, r *http.Request) {
+	s := h.service.OAuthClient()
+	if err := s.Delete(r.Context(), chi.URLParam(r, "id")); err != nil {
+		respondOAuthClientError(w, err)
+		return
+	}
+
+	helper.RespondSuccess(w, http.StatusOK, nil, nil)
+}
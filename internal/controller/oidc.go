@@ -0,0 +1,199 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"auth/internal/helper"
+	middlewares "auth/internal/middleware"
+	"auth/internal/model"
+	"auth/internal/service"
+)
+
+type OIDCController struct {
+	service service.Service
+}
+
+func NewOIDCController(s service.Service) *OIDCController {
+	return &OIDCController{service: s}
+}
+
+// respondOAuthError writes the RFC 6749 error body
+func respondOAuthError(w http.ResponseWriter, status int, err error) {
+	var oauthErr *service.OAuthError
+	if !errors.As(err, &oauthErr) {
+		helper.RespondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if oauthErr.Code == "invalid_client" {
+		status = http.StatusUnauthorized
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	helper.RespondJSON(w, status, map[string]string{
+		"error":             oauthErr.Code,
+		"error_description": oauthErr.Description,
+	})
+}
+
+func (h *OIDCController) Authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	req := model.AuthorizeRequest{
+		ResponseType:        query.Get("response_type"),
+		ClientID:            query.Get("client_id"),
+		RedirectURI:         query.Get("redirect_uri"),
+		Scope:               query.Get("scope"),
+		State:               query.Get("state"),
+		Nonce:               query.Get("nonce"),
+		CodeChallenge:       query.Get("code_challenge"),
+		CodeChallengeMethod: query.Get("code_challenge_method"),
+		Prompt:              query.Get("prompt"),
+	}
+
+	s := h.service.OIDC()
+	redirect, err := s.Authorize(r.Context(), req, refreshSession(r))
+	if err != nil {
+		respondOAuthError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+// clientAuth reads client_secret_basic, falling back to the form
+// (client_secret_post, or just client_id for public clients)
+func clientAuth(r *http.Request) (string, string, error) {
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		return r.PostForm.Get("client_id"), r.PostForm.Get("client_secret"), nil
+	}
+
+	// both parts are form encoded before base64
+	clientID, err := url.QueryUnescape(id)
+	if err == nil {
+		secret, err = url.QueryUnescape(secret)
+	}
+	if err != nil {
+		return "", "", &service.OAuthError{Code: "invalid_request", Description: "malformed basic credentials"}
+	}
+	return clientID, secret, nil
+}
+
+func (h *OIDCController) Token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondOAuthError(w, http.StatusBadRequest, &service.OAuthError{Code: "invalid_request", Description: err.Error()})
+		return
+	}
+
+	req := model.TokenRequest{
+		GrantType:    r.PostForm.Get("grant_type"),
+		Code:         r.PostForm.Get("code"),
+		RedirectURI:  r.PostForm.Get("redirect_uri"),
+		CodeVerifier: r.PostForm.Get("code_verifier"),
+		RefreshToken: r.PostForm.Get("refresh_token"),
+		DeviceCode:   r.PostForm.Get("device_code"),
+		Scope:        r.PostForm.Get("scope"),
+
+		SubjectToken:       r.PostForm.Get("subject_token"),
+		SubjectTokenType:   r.PostForm.Get("subject_token_type"),
+		ActorToken:         r.PostForm.Get("actor_token"),
+		ActorTokenType:     r.PostForm.Get("actor_token_type"),
+		RequestedTokenType: r.PostForm.Get("requested_token_type"),
+		// resource is an absolute uri audience, both are handled the same
+		Audience: append(r.PostForm["audience"], r.PostForm["resource"]...),
+	}
+
+	var err error
+	if req.ClientID, req.ClientSecret, err = clientAuth(r); err != nil {
+		respondOAuthError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s := h.service.OIDC()
+	res, err := s.Token(r.Context(), req)
+	if err != nil {
+		respondOAuthError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	helper.RespondJSON(w, http.StatusOK, res)
+}
+
+// Introspect is RFC 7662, only confidential clients may ask
+func (h *OIDCController) Introspect(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondOAuthError(w, http.StatusBadRequest, &service.OAuthError{Code: "invalid_request", Description: err.Error()})
+		return
+	}
+
+	req := model.IntrospectionRequest{
+		Token:         r.PostForm.Get("token"),
+		TokenTypeHint: r.PostForm.Get("token_type_hint"),
+	}
+
+	var err error
+	if req.ClientID, req.ClientSecret, err = clientAuth(r); err != nil {
+		respondOAuthError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s := h.service.OIDC()
+	res, err := s.Introspect(r.Context(), req)
+	if err != nil {
+		respondOAuthError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	helper.RespondJSON(w, http.StatusOK, res)
+}
+
+// Revoke is RFC 7009, it answers 200 for tokens that were already invalid
+func (h *OIDCController) Revoke(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondOAuthError(w, http.StatusBadRequest, &service.OAuthError{Code: "invalid_request", Description: err.Error()})
+		return
+	}
+
+	req := model.RevocationRequest{
+		Token:         r.PostForm.Get("token"),
+		TokenTypeHint: r.PostForm.Get("token_type_hint"),
+	}
+
+	var err error
+	if req.ClientID, req.ClientSecret, err = clientAuth(r); err != nil {
+		respondOAuthError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s := h.service.OIDC()
+	if err := s.Revoke(r.Context(), req); err != nil {
+		respondOAuthError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *OIDCController) UserInfo(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middlewares.ClaimsFromContext(r.Context())
+	if !ok {
+		helper.RespondError(w, http.StatusUnauthorized, fmt.Errorf("user not found in context"))
+		return
+	}
+
+	s := h.service.OIDC()
+	res, err := s.UserInfo(r.Context(), claims)
+	if err != nil {
+		respondOAuthError(w, http.StatusForbidden, err)
+		return
+	}
+
+	helper.RespondJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"auth/internal/helper"
+	middlewares "auth/internal/middleware"
+	"auth/internal/model"
+	"auth/internal/service"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type SessionController struct {
+	service service.Service
+}
+
+func NewSessionController(s service.Service) *SessionController {
+	return &SessionController{service: s}
+}
+
+func respondSessionError(w http.ResponseWriter, err error) {
+	if errors.Is(err, service.ErrSessionNotFound) {
+		helper.RespondError(w, http.StatusNotFound, err)
+		return
+	}
+	helper.RespondError(w, http.StatusInternalServerError, err)
+}
+
+func (h *SessionController) GetMine(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middlewares.UserIDFromContext(r.Context())
+	if !ok {
+		helper.RespondError(w, http.StatusUnauthorized, fmt.Errorf("user not found in context"))
+		return
+	}
+
+	s := h.service.Session()
+	res, err := s.List(r.Context(), userID, refreshSession(r))
+	if err != nil {
+		respondSessionError(w, err)
+		return
+	}
+
+	helper.RespondSuccess(w, http.StatusOK, res, nil)
+}
+
+func (h *SessionController) RevokeMine(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middlewares.UserIDFromContext(r.Context())
+	if !ok {
+		helper.RespondError(w, http.StatusUnauthorized, fmt.Errorf("user not found in context"))
+		return
+	}
+
+	s := h.service.Session()
+	if err := s.Revoke(r.Context(), userID, chi.URLParam(r, "sessionId")); err != nil {
+		respondSessionError(w, err)
+		return
+	}
+
+	helper.RespondSuccess(w, http.StatusOK, nil, nil)
+}
+
+func (h *SessionController) GetByUser(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, strErr := strconv.Atoi(idStr)
+	if strErr != nil {
+		helper.RespondError(w, http.StatusBadRequest, strErr)
+		return
+	}
+
+	s := h.service.Session()
+	res, err := s.List(r.Context(), id, "")
+	if err != nil {
+		respondSessionError(w, err)
+		return
+	}
+
+	helper.RespondSuccess(w, http.StatusOK, res, nil)
+}
+
+func (h *SessionController) RevokeByUser(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, strErr := strconv.Atoi(idStr)
+	if strErr != nil {
+		helper.RespondError(w, http.StatusBadRequest, strErr)
+		return
+	}
+
+	s := h.service.Session()
+	if err := s.Revoke(r.Context(), id, chi.URLParam(r, "sessionId")); err != nil {
+		respondSessionError(w, err)
+		return
+	}
+
+	helper.RespondSuccess(w, http.StatusOK, nil, nil)
+}
+
+func (h *SessionController) SetLimitByUser(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, strErr := strconv.Atoi(idStr)
+	if strErr != nil {
+		helper.RespondError(w, http.StatusBadRequest, strErr)
+		return
+	}
+
+	body := model.SessionLimit{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		helper.RespondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s := h.service.Session()
+	if err := s.SetLimit(r.Context(), id, body.MaxSessions); err != nil {
+		helper.RespondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	helper.RespondSuccess(w, http.StatusOK, body, nil)
+}
@@ -0,0 +1,43 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"auth/internal/helper"
+	"auth/internal/service"
+)
+
+type SigningKeyController struct {
+	service service.Service
+}
+
+func NewSigningKeyController(s service.Service) *SigningKeyController {
+	return &SigningKeyController{service: s}
+}
+
+func (h *SigningKeyController) GetMany(w http.ResponseWriter, r *http.Request) {
+	s := h.service.SigningKey()
+	res, err := s.GetMany(r.Context())
+	if err != nil {
+		helper.RespondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	helper.RespondSuccess(w, http.StatusOK, res, nil)
+}
+
+func (h *SigningKeyController) Rotate(w http.ResponseWriter, r *http.Request) {
+	s := h.service.SigningKey()
+	res, err := s.Rotate(r.Context())
+	if err != nil {
+		if errors.Is(err, service.ErrKeyStoreDisabled) || errors.Is(err, service.ErrKeyRotationPending) {
+			helper.RespondError(w, http.StatusConflict, err)
+			return
+		}
+		helper.RespondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	helper.RespondSuccess(w, http.StatusCreated, res, nil)
+}
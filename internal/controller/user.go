@@ -1,10 +1,15 @@
 package controller
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 
 	"auth/internal/helper"
+	middlewares "auth/internal/middleware"
+	"auth/internal/model"
 	"auth/internal/service"
 
 	"github.com/go-chi/chi/v5"
@@ -65,3 +70,70 @@ func (h *UserController) GetMany(w http.ResponseWriter, r *http.Request) {
 
 	helper.RespondSuccess(w, http.StatusOK, res, nil)
 }
+
+func (h *UserController) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middlewares.UserIDFromContext(r.Context())
+	if !ok {
+		helper.RespondError(w, http.StatusUnauthorized, fmt.Errorf("user not found in context"))
+		return
+	}
+
+	body := model.ChangePassword{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		helper.RespondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	refreshToken := ""
+	if cookie, err := r.Cookie(refreshCookie); err == nil {
+		refreshToken = cookie.Value
+	}
+
+	s := h.service.User()
+	if err := s.ChangePassword(r.Context(), userID, body, refreshToken); err != nil {
+		if errors.Is(err, service.ErrWrongPassword) {
+			helper.RespondError(w, http.StatusUnauthorized, err)
+			return
+		}
+		helper.RespondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	helper.RespondSuccess(w, http.StatusOK, nil, nil)
+}
+
+// LogoutAll signs the caller out on every device, this one included
+func (h *UserController) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middlewares.UserIDFromContext(r.Context())
+	if !ok {
+		helper.RespondError(w, http.StatusUnauthorized, fmt.Errorf("user not found in context"))
+		return
+	}
+
+	s := h.service.Session()
+	if err := s.RevokeAll(r.Context(), userID); err != nil {
+		helper.RespondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	clearRefreshCookie(w)
+	helper.RespondSuccess(w, http.StatusOK, nil, nil)
+}
+
+// ForceLogout is the admin side, for an account that was taken over
+func (h *UserController) ForceLogout(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, strErr := strconv.Atoi(idStr)
+	if strErr != nil {
+		helper.RespondError(w, http.StatusBadRequest, strErr)
+		return
+	}
+
+	s := h.service.Session()
+	if err := s.RevokeAll(r.Context(), id); err != nil {
+		helper.RespondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	helper.RespondSuccess(w, http.StatusOK, nil, nil)
+}
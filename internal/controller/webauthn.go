@@ -0,0 +1,189 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"auth/internal/helper"
+	middlewares "auth/internal/middleware"
+	"auth/internal/model"
+	"auth/internal/repository"
+	"auth/internal/service"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// attestation and assertion bodies are small, this keeps a client from streaming junk
+const webauthnBodyLimit = 64 << 10
+
+type WebAuthnController struct {
+	service service.Service
+}
+
+func NewWebAuthnController(s service.Service) *WebAuthnController {
+	return &WebAuthnController{service: s}
+}
+
+func (h *WebAuthnController) GetMany(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middlewares.UserIDFromContext(r.Context())
+	if !ok {
+		helper.RespondError(w, http.StatusUnauthorized, fmt.Errorf("user not found in context"))
+		return
+	}
+
+	s := h.service.WebAuthn()
+	res, err := s.GetByUserId(r.Context(), userID)
+	if err != nil {
+		helper.RespondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	helper.RespondSuccess(w, http.StatusOK, res, nil)
+}
+
+func (h *WebAuthnController) BeginRegistration(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middlewares.UserIDFromContext(r.Context())
+	if !ok {
+		helper.RespondError(w, http.StatusUnauthorized, fmt.Errorf("user not found in context"))
+		return
+	}
+
+	s := h.service.WebAuthn()
+	res, err := s.BeginRegistration(r.Context(), userID)
+	if err != nil {
+		helper.RespondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	helper.RespondSuccess(w, http.StatusOK, res, nil)
+}
+
+// FinishRegistration takes the raw navigator.credentials.create() result as body,
+// the session id and an optional display name come in the query
+func (h *WebAuthnController) FinishRegistration(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middlewares.UserIDFromContext(r.Context())
+	if !ok {
+		helper.RespondError(w, http.StatusUnauthorized, fmt.Errorf("user not found in context"))
+		return
+	}
+
+	query := r.URL.Query()
+
+	s := h.service.WebAuthn()
+	res, err := s.FinishRegistration(
+		r.Context(),
+		userID,
+		query.Get("session_id"),
+		query.Get("name"),
+		io.LimitReader(r.Body, webauthnBodyLimit),
+	)
+	if err != nil {
+		helper.RespondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	helper.RespondSuccess(w, http.StatusCreated, res, nil)
+}
+
+func (h *WebAuthnController) Delete(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middlewares.UserIDFromContext(r.Context())
+	if !ok {
+		helper.RespondError(w, http.StatusUnauthorized, fmt.Errorf("user not found in context"))
+		return
+	}
+
+	id, strErr := strconv.Atoi(chi.URLParam(r, "id"))
+	if strErr != nil {
+		helper.RespondError(w, http.StatusBadRequest, strErr)
+		return
+	}
+
+	s := h.service.WebAuthn()
+	if err := s.Delete(r.Context(), userID, id); err != nil {
+		switch {
+		case errors.Is(err, service.ErrCredentialNotFound):
+			helper.RespondError(w, http.StatusNotFound, err)
+		case errors.Is(err, repository.ErrLastLoginMethod):
+			helper.RespondError(w, http.StatusConflict, err)
+		default:
+			helper.RespondError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	helper.RespondSuccess(w, http.StatusOK, nil, nil)
+}
+
+func (h *WebAuthnController) BeginLogin(w http.ResponseWriter, r *http.Request) {
+	body := model.WebAuthnBegin{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		helper.RespondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s := h.service.WebAuthn()
+	res, err := s.BeginLogin(r.Context(), body.Username)
+	if err != nil {
+		helper.RespondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	helper.RespondSuccess(w, http.StatusOK, res, nil)
+}
+
+func (h *WebAuthnController) FinishLogin(w http.ResponseWriter, r *http.Request) {
+	s := h.service.WebAuthn()
+	res, refreshToken, token, err := s.FinishLogin(
+		r.Context(),
+		r.URL.Query().Get("session_id"),
+		io.LimitReader(r.Body, webauthnBodyLimit),
+	)
+	if err != nil {
+		helper.RespondError(w, loginErrorStatus(err), err)
+		return
+	}
+
+	setRefreshCookie(w, refreshToken)
+	helper.RespondSuccess(w, http.StatusOK, res, &token)
+}
+
+func (h *WebAuthnController) BeginMFA(w http.ResponseWriter, r *http.Request) {
+	body := model.WebAuthnBegin{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		helper.RespondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s := h.service.WebAuthn()
+	res, err := s.BeginMFA(r.Context(), body.MFAToken)
+	if err != nil {
+		if errors.Is(err, service.ErrMFANotEnrolled) {
+			helper.RespondError(w, http.StatusBadRequest, err)
+			return
+		}
+		helper.RespondError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	helper.RespondSuccess(w, http.StatusOK, res, nil)
+}
+
+func (h *WebAuthnController) FinishMFA(w http.ResponseWriter, r *http.Request) {
+	s := h.service.WebAuthn()
+	res, refreshToken, token, err := s.FinishMFA(
+		r.Context(),
+		r.URL.Query().Get("session_id"),
+		io.LimitReader(r.Body, webauthnBodyLimit),
+	)
+	if err != nil {
+		helper.RespondError(w, loginErrorStatus(err), err)
+		return
+	}
+
+	setRefreshCookie(w, refreshToken)
+	helper.RespondSuccess(w, http.StatusOK, res, &token)
+}
@@ -0,0 +1,36 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+
+	"auth/internal/helper"
+	"auth/internal/service"
+)
+
+type WellKnownController struct {
+	service service.Service
+}
+
+func NewWellKnownController(s service.Service) *WellKnownController {
+	return &WellKnownController{service: s}
+}
+
+// JWKS publishes the access token verification keys for other services
+func (h *WellKnownController) JWKS(w http.ResponseWriter, r *http.Request) {
+	set, err := helper.JWKS()
+	if err != nil {
+		helper.RespondError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(helper.JWKSMaxAge.Seconds())))
+	helper.RespondJSON(w, http.StatusOK, set)
+}
+
+func (h *WellKnownController) OpenIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	s := h.service.OIDC()
+
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	helper.RespondJSON(w, http.StatusOK, s.Configuration())
+}
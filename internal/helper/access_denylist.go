@@ -0,0 +1,99 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"auth/internal/model"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// access tokens are stateless, a revoked one is remembered by jti
+// until it would have expired anyway
+var (
+	accessDenylistMu sync.RWMutex
+	accessDenylist   *redis.Client
+)
+
+var ErrAccessTokenRevoked = errors.New("access token has been revoked")
+
+// UseAccessTokenDenylist makes ValidateAccessToken check revoked jtis,
+// called once at startup with the shared redis client
+func UseAccessTokenDenylist(rdb *redis.Client) {
+	accessDenylistMu.Lock()
+	defer accessDenylistMu.Unlock()
+	accessDenylist = rdb
+}
+
+func accessDenylistClient() *redis.Client {
+	accessDenylistMu.RLock()
+	defer accessDenylistMu.RUnlock()
+	return accessDenylist
+}
+
+func accessRevokedKey(jti string) string {
+	return "access:revoked:" + jti
+}
+
+// every access token of a session signed out or evicted over the cap is revoked at once
+func accessRevokedSessionKey(sid string) string {
+	return "access:revoked:session:" + sid
+}
+
+// denySessionAccess revokes every access token of a signed out session,
+// none of them lives longer than ttl, the access token lifetime
+func denySessionAccess(ctx context.Context, pipe redis.Pipeliner, sid string, ttl time.Duration) {
+	if sid == "" {
+		return
+	}
+	pipe.Set(ctx, accessRevokedSessionKey(sid), "1", ttl)
+}
+
+// RevokeAccessToken denylists the jti for what is left of the token lifetime,
+// tokens issued before access tokens had a jti just run out
+func RevokeAccessToken(ctx context.Context, claims *model.ClaimsModel, rdb *redis.Client) error {
+	if rdb == nil {
+		return errors.New("redis client required")
+	}
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return nil
+	}
+
+	remaining := time.Until(claims.ExpiresAt.Time)
+	if remaining <= 0 {
+		return nil
+	}
+
+	return rdb.Set(ctx, accessRevokedKey(claims.ID), "1", remaining).Err()
+}
+
+// accessTokenRevoked fails closed, a token is not trusted while redis is down
+func accessTokenRevoked(ctx context.Context, claims *model.ClaimsModel) error {
+	rdb := accessDenylistClient()
+	if rdb == nil {
+		return nil
+	}
+
+	keys := make([]string, 0, 2)
+	if claims.ID != "" {
+		keys = append(keys, accessRevokedKey(claims.ID))
+	}
+	if claims.SessionID != "" {
+		keys = append(keys, accessRevokedSessionKey(claims.SessionID))
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	n, err := rdb.Exists(ctx, keys...).Result()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrAccessTokenRevoked
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+package helper
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	DeviceCodePending  = "pending"
+	DeviceCodeApproved = "approved"
+	DeviceCodeDenied   = "denied"
+)
+
+// DevicePollInterval is the minimum wait between two polls, slow_down adds to it
+const DevicePollInterval = 5 * time.Second
+
+// no vowels so a user code never spells a word, no look alike characters
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+var (
+	ErrDeviceCodeNotFound = errors.New("device code invalid or expired")
+	ErrDeviceCodeUsed     = errors.New("device code was already decided")
+)
+
+// DeviceCode is what a device code stands for while it waits in redis
+type DeviceCode struct {
+	ClientID string
+	Scope    string
+	UserCode string
+	Status   string
+	UserID   int
+	Interval time.Duration
+}
+
+func deviceCodeKey(codeHash string) string {
+	return "oauth:device:" + codeHash
+}
+
+func deviceUserCodeKey(userCode string) string {
+	return "oauth:device:user_code:" + userCode
+}
+
+func devicePollKey(codeHash string) string {
+	return "oauth:device:poll:" + codeHash
+}
+
+func DeviceCodeExpiry() (time.Duration, error) {
+	expiryStr := os.Getenv("DEVICE_CODE_EXPIRED")
+	if expiryStr == "" {
+		expiryStr = "10m"
+	}
+	return ParseExpiry(expiryStr)
+}
+
+// NormalizeUserCode accepts what people type, lower case, spaces and dashes
+func NormalizeUserCode(userCode string) string {
+	userCode = strings.ToUpper(userCode)
+	userCode = strings.NewReplacer("-", "", " ", "").Replace(userCode)
+	return userCode
+}
+
+// FormatUserCode shows the code as XXXX-XXXX
+func FormatUserCode(userCode string) string {
+	if len(userCode) != 8 {
+		return userCode
+	}
+	return userCode[:4] + "-" + userCode[4:]
+}
+
+func newUserCode() (string, error) {
+	alphabetLen := big.NewInt(int64(len(userCodeAlphabet)))
+
+	var b strings.Builder
+	for i := 0; i < 8; i++ {
+		n, err := rand.Int(rand.Reader, alphabetLen)
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(userCodeAlphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+// CreateDeviceCode returns the device code for the device and the user code
+// for the person, redis only keeps the hash of the device code
+func CreateDeviceCode(ctx context.Context, clientID string, scope string, rdb *redis.Client) (string, string, error) {
+	if rdb == nil {
+		return "", "", errors.New("redis client required for device code")
+	}
+
+	duration, err := DeviceCodeExpiry()
+	if err != nil {
+		return "", "", err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	deviceCode := base64.RawURLEncoding.EncodeToString(b)
+	codeHash := hashToken(deviceCode)
+
+	// user codes are short, retry the rare collision with a live one
+	userCode := ""
+	for i := 0; i < 5 && userCode == ""; i++ {
+		candidate, err := newUserCode()
+		if err != nil {
+			return "", "", err
+		}
+		ok, err := rdb.SetNX(ctx, deviceUserCodeKey(candidate), codeHash, duration).Result()
+		if err != nil {
+			return "", "", err
+		}
+		if ok {
+			userCode = candidate
+		}
+	}
+	if userCode == "" {
+		return "", "", errors.New("failed allocating a user code")
+	}
+
+	pipe := rdb.TxPipeline()
+	pipe.HSet(ctx, deviceCodeKey(codeHash),
+		"client_id", clientID,
+		"scope", scope,
+		"user_code", userCode,
+		"status", DeviceCodePending,
+		"user_id", 0,
+		"interval", int(DevicePollInterval.Seconds()),
+	)
+	pipe.Expire(ctx, deviceCodeKey(codeHash), duration)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", "", err
+	}
+
+	return deviceCode, userCode, nil
+}
+
+func getDeviceCode(ctx context.Context, codeHash string, rdb *redis.Client) (*DeviceCode, error) {
+	data, err := rdb.HGetAll(ctx, deviceCodeKey(codeHash)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, ErrDeviceCodeNotFound
+	}
+
+	userID, _ := strconv.Atoi(data["user_id"])
+	interval, _ := strconv.Atoi(data["interval"])
+
+	return &DeviceCode{
+		ClientID: data["client_id"],
+		Scope:    data["scope"],
+		UserCode: data["user_code"],
+		Status:   data["status"],
+		UserID:   userID,
+		Interval: time.Duration(interval) * time.Second,
+	}, nil
+}
+
+// GetDeviceCodeByUserCode is for the verification page, to show which client asks
+func GetDeviceCodeByUserCode(ctx context.Context, userCode string, rdb *redis.Client) (*DeviceCode, error) {
+	codeHash, err := rdb.Get(ctx, deviceUserCodeKey(NormalizeUserCode(userCode))).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, ErrDeviceCodeNotFound
+		}
+		return nil, err
+	}
+
+	return getDeviceCode(ctx, codeHash, rdb)
+}
+
+// a code is decided once, approving a denied one or the other way round is refused
+var decideDeviceCodeScript = redis.NewScript(`
+local status = redis.call("HGET", KEYS[1], "status")
+if not status then
+  return -1
+end
+if status ~= "pending" then
+  return -2
+end
+redis.call("HSET", KEYS[1], "status", ARGV[1], "user_id", ARGV[2])
+return 1
+`)
+
+// DecideDeviceCode records the user's answer, the user code is burned either way
+func DecideDeviceCode(ctx context.Context, userCode string, approve bool, userID int, rdb *redis.Client) error {
+	userCode = NormalizeUserCode(userCode)
+
+	codeHash, err := rdb.Get(ctx, deviceUserCodeKey(userCode)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return ErrDeviceCodeNotFound
+		}
+		return err
+	}
+
+	status := DeviceCodeDenied
+	if approve {
+		status = DeviceCodeApproved
+	}
+
+	res, err := decideDeviceCodeScript.Run(ctx, rdb, []string{deviceCodeKey(codeHash)}, status, userID).Int()
+	if err != nil {
+		return err
+	}
+	switch res {
+	case -1:
+		return ErrDeviceCodeNotFound
+	case -2:
+		return ErrDeviceCodeUsed
+	}
+
+	_ = rdb.Del(ctx, deviceUserCodeKey(userCode)).Err()
+
+	return nil
+}
+
+// the code may have expired since it was read, never recreate it without a ttl
+var slowDownScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+  redis.call("HINCRBY", KEYS[1], "interval", ARGV[1])
+end
+return 1
+`)
+
+// PollDeviceCode is one poll of the device. slow is set when it polled before
+// the interval passed, the interval then grows by five seconds as RFC 8628 asks.
+// A decided code is deleted here, so its tokens are handed out only once.
+func PollDeviceCode(ctx context.Context, deviceCode string, rdb *redis.Client) (*DeviceCode, bool, error) {
+	codeHash := hashToken(deviceCode)
+
+	code, err := getDeviceCode(ctx, codeHash, rdb)
+	if err != nil {
+		return nil, false, err
+	}
+
+	ok, err := rdb.SetNX(ctx, devicePollKey(codeHash), 1, code.Interval).Result()
+	if err != nil {
+		return nil, false, err
+	}
+	if !ok {
+		if err := slowDownScript.Run(ctx, rdb, []string{deviceCodeKey(codeHash)}, 5).Err(); err != nil && err != redis.Nil {
+			return nil, false, err
+		}
+		return code, true, nil
+	}
+
+	if code.Status == DeviceCodePending {
+		return code, false, nil
+	}
+
+	// whoever deletes the key owns the result
+	n, err := rdb.Del(ctx, deviceCodeKey(codeHash)).Result()
+	if err != nil {
+		return nil, false, err
+	}
+	if n == 0 {
+		return nil, false, ErrDeviceCodeNotFound
+	}
+	_ = rdb.Del(ctx, devicePollKey(codeHash)).Err()
+
+	return code, false, nil
+}
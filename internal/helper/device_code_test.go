@@ -0,0 +1,109 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeviceCodePolling(t *testing.T) {
+	t.Setenv("DEVICE_CODE_EXPIRED", "")
+	ctx := context.Background()
+	mr, rdb := newTestRedis(t)
+
+	deviceCode, userCode, err := CreateDeviceCode(ctx, "tv", "openid", rdb)
+	if err != nil {
+		t.Fatalf("CreateDeviceCode: %v", err)
+	}
+
+	code, slow, err := PollDeviceCode(ctx, deviceCode, rdb)
+	if err != nil || slow || code.Status != DeviceCodePending || code.ClientID != "tv" {
+		t.Fatalf("first poll: %+v slow=%v err=%v", code, slow, err)
+	}
+
+	// too soon, and every early poll adds five seconds
+	for _, want := range []time.Duration{DevicePollInterval, DevicePollInterval + 5*time.Second} {
+		code, slow, err = PollDeviceCode(ctx, deviceCode, rdb)
+		if err != nil || !slow || code.Interval != want {
+			t.Fatalf("early poll: %+v slow=%v err=%v, want slow_down at %s", code, slow, err, want)
+		}
+	}
+
+	mr.FastForward(DevicePollInterval)
+	code, slow, err = PollDeviceCode(ctx, deviceCode, rdb)
+	if err != nil || slow || code.Status != DeviceCodePending || code.Interval != DevicePollInterval+10*time.Second {
+		t.Fatalf("poll after the interval: %+v slow=%v err=%v", code, slow, err)
+	}
+
+	// people type it in lower case and with a space for the dash
+	if err := DecideDeviceCode(ctx, strings.ToLower(userCode[:4]+" "+userCode[4:]), true, 42, rdb); err != nil {
+		t.Fatalf("DecideDeviceCode: %v", err)
+	}
+
+	mr.FastForward(time.Minute)
+	code, slow, err = PollDeviceCode(ctx, deviceCode, rdb)
+	if err != nil || slow || code.Status != DeviceCodeApproved || code.UserID != 42 {
+		t.Fatalf("poll after approval: %+v slow=%v err=%v", code, slow, err)
+	}
+
+	// the tokens of an approved code are handed out once
+	if _, _, err := PollDeviceCode(ctx, deviceCode, rdb); !errors.Is(err, ErrDeviceCodeNotFound) {
+		t.Fatalf("second poll after approval: got %v, want ErrDeviceCodeNotFound", err)
+	}
+}
+
+func TestDeviceCodeDenied(t *testing.T) {
+	t.Setenv("DEVICE_CODE_EXPIRED", "")
+	ctx := context.Background()
+	_, rdb := newTestRedis(t)
+
+	deviceCode, userCode, err := CreateDeviceCode(ctx, "tv", "openid", rdb)
+	if err != nil {
+		t.Fatalf("CreateDeviceCode: %v", err)
+	}
+
+	if err := DecideDeviceCode(ctx, userCode, false, 42, rdb); err != nil {
+		t.Fatalf("DecideDeviceCode: %v", err)
+	}
+
+	// the user code is burned, a second answer cannot turn it around
+	if err := DecideDeviceCode(ctx, userCode, true, 42, rdb); !errors.Is(err, ErrDeviceCodeNotFound) {
+		t.Fatalf("second decision: got %v, want ErrDeviceCodeNotFound", err)
+	}
+	if _, err := GetDeviceCodeByUserCode(ctx, userCode, rdb); !errors.Is(err, ErrDeviceCodeNotFound) {
+		t.Fatalf("lookup after the decision: got %v, want ErrDeviceCodeNotFound", err)
+	}
+
+	code, _, err := PollDeviceCode(ctx, deviceCode, rdb)
+	if err != nil || code.Status != DeviceCodeDenied || code.UserID != 42 {
+		t.Fatalf("poll after denial: %+v err=%v", code, err)
+	}
+}
+
+func TestDeviceCodeExpires(t *testing.T) {
+	t.Setenv("DEVICE_CODE_EXPIRED", "2m")
+	ctx := context.Background()
+	mr, rdb := newTestRedis(t)
+
+	deviceCode, userCode, err := CreateDeviceCode(ctx, "tv", "openid", rdb)
+	if err != nil {
+		t.Fatalf("CreateDeviceCode: %v", err)
+	}
+
+	mr.FastForward(2 * time.Minute)
+
+	if _, err := GetDeviceCodeByUserCode(ctx, userCode, rdb); !errors.Is(err, ErrDeviceCodeNotFound) {
+		t.Fatalf("lookup: got %v, want ErrDeviceCodeNotFound", err)
+	}
+	if err := DecideDeviceCode(ctx, userCode, true, 42, rdb); !errors.Is(err, ErrDeviceCodeNotFound) {
+		t.Fatalf("decision: got %v, want ErrDeviceCodeNotFound", err)
+	}
+	if _, _, err := PollDeviceCode(ctx, deviceCode, rdb); !errors.Is(err, ErrDeviceCodeNotFound) {
+		t.Fatalf("poll: got %v, want ErrDeviceCodeNotFound", err)
+	}
+	if mr.Exists(deviceCodeKey(hashToken(deviceCode))) {
+		t.Fatal("slow_down brought an expired code back")
+	}
+}
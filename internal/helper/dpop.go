@@ -0,0 +1,287 @@
+package helper
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"auth/internal/model"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+// a proof is only good around the time it was made, its jti is
+// remembered for twice as long so a replay is caught on either side
+const dpopProofWindow = time.Minute
+
+var ErrDPoPRequired = errors.New("a DPoP proof is required")
+
+var (
+	dpopReplayMu sync.RWMutex
+	dpopReplay   *redis.Client
+)
+
+// UseDPoPReplayCache keeps used proof jtis in redis, called once at startup
+func UseDPoPReplayCache(rdb *redis.Client) {
+	dpopReplayMu.Lock()
+	defer dpopReplayMu.Unlock()
+	dpopReplay = rdb
+}
+
+func dpopReplayClient() *redis.Client {
+	dpopReplayMu.RLock()
+	defer dpopReplayMu.RUnlock()
+	return dpopReplay
+}
+
+func dpopJTIKey(jkt string, jti string) string {
+	return "dpop:jti:" + hashToken(jkt+":"+jti)
+}
+
+// DPoPRequired makes first party logins and refreshes need a proof,
+// oauth clients are configured one by one with require_dpop
+func DPoPRequired() bool {
+	required, _ := strconv.ParseBool(os.Getenv("DPOP_REQUIRED"))
+	return required
+}
+
+type DPoPClaims struct {
+	HTM string `json:"htm"`
+	HTU string `json:"htu"`
+	ATH string `json:"ath,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// DPoPProof is a verified proof, JKT is the thumbprint of the key that signed it
+type DPoPProof struct {
+	JKT string
+	ATH string
+}
+
+type dpopKey struct{}
+
+// WithDPoPProof stores the verified proof, tokens issued with the context are bound to its key
+func WithDPoPProof(ctx context.Context, proof *DPoPProof) context.Context {
+	return context.WithValue(ctx, dpopKey{}, proof)
+}
+
+func DPoPProofFromContext(ctx context.Context) (*DPoPProof, bool) {
+	proof, ok := ctx.Value(dpopKey{}).(*DPoPProof)
+	return proof, ok && proof != nil
+}
+
+// dpopJKT is the key new tokens are bound to, empty without a proof
+func dpopJKT(ctx context.Context) string {
+	if proof, ok := DPoPProofFromContext(ctx); ok {
+		return proof.JKT
+	}
+	return ""
+}
+
+// dpopConfirmation is the cnf claim of a token issued with the context
+func dpopConfirmation(ctx context.Context) *model.Confirmation {
+	if jkt := dpopJKT(ctx); jkt != "" {
+		return &model.Confirmation{JKT: jkt}
+	}
+	return nil
+}
+
+// CheckDPoPBinding lets a bound token through only with a proof of its key,
+// for tokens presented in a request body, like the ones of a token exchange
+func CheckDPoPBinding(ctx context.Context, claims *model.ClaimsModel) error {
+	if claims.Confirmation == nil {
+		return nil
+	}
+	if dpopJKT(ctx) != claims.Confirmation.JKT {
+		return errors.New("token is bound to a DPoP key, a proof of it is required")
+	}
+	return nil
+}
+
+// TokenType is what the token endpoint reports, DPoP for bound tokens
+func TokenType(ctx context.Context) string {
+	if dpopJKT(ctx) != "" {
+		return "DPoP"
+	}
+	return "Bearer"
+}
+
+// AccessTokenHash is the ath a proof carries next to a bound access token
+func AccessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return b64(sum[:])
+}
+
+// VerifyDPoPProof checks an RFC 9449 proof for the request method and uri,
+// the uri is compared without query and fragment. Each proof is accepted once.
+func VerifyDPoPProof(ctx context.Context, proof string, method string, uri string) (*DPoPProof, error) {
+	var public crypto.PublicKey
+
+	token, err := jwt.ParseWithClaims(
+		proof,
+		&DPoPClaims{},
+		func(token *jwt.Token) (interface{}, error) {
+			if typ, _ := token.Header["typ"].(string); typ != "dpop+jwt" {
+				return nil, errors.New("DPoP proof typ must be dpop+jwt")
+			}
+
+			raw, err := json.Marshal(token.Header["jwk"])
+			if err != nil {
+				return nil, err
+			}
+			key, err := parsePublicJWK(raw)
+			if err != nil {
+				return nil, err
+			}
+			public = key
+			return key, nil
+		},
+		jwt.WithValidMethods([]string{AlgRS256, AlgES256, AlgEdDSA}),
+		jwt.WithoutClaimsValidation(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DPoP proof: %w", err)
+	}
+
+	claims, ok := token.Claims.(*DPoPClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid DPoP proof")
+	}
+
+	if claims.ID == "" || claims.IssuedAt == nil {
+		return nil, errors.New("DPoP proof needs jti and iat")
+	}
+	if age := time.Since(claims.IssuedAt.Time); age > dpopProofWindow || age < -dpopProofWindow {
+		return nil, errors.New("DPoP proof is too old or from the future")
+	}
+	if claims.HTM != method {
+		return nil, errors.New("DPoP proof htm does not match the request")
+	}
+	if !sameHTU(claims.HTU, uri) {
+		return nil, errors.New("DPoP proof htu does not match the request")
+	}
+
+	jkt, err := jwkThumbprint(public)
+	if err != nil {
+		return nil, err
+	}
+
+	rdb := dpopReplayClient()
+	if rdb == nil {
+		return nil, errors.New("DPoP replay cache is not configured")
+	}
+	fresh, err := rdb.SetNX(ctx, dpopJTIKey(jkt, claims.ID), 1, 2*dpopProofWindow).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !fresh {
+		return nil, errors.New("DPoP proof was already used")
+	}
+
+	return &DPoPProof{JKT: jkt, ATH: claims.ATH}, nil
+}
+
+func sameHTU(htu string, uri string) bool {
+	a, err := url.Parse(htu)
+	if err != nil {
+		return false
+	}
+	b, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	return a.Scheme == b.Scheme && a.Host == b.Host && a.Path == b.Path
+}
+
+// parsePublicJWK reads the jwk header of a proof, a key with private members is refused
+func parsePublicJWK(raw []byte) (crypto.PublicKey, error) {
+	var jwk struct {
+		JWK
+		D string `json:"d"`
+	}
+	if err := json.Unmarshal(raw, &jwk); err != nil {
+		return nil, errors.New("DPoP proof jwk is invalid")
+	}
+	if jwk.D != "" {
+		return nil, errors.New("DPoP proof jwk must not contain a private key")
+	}
+
+	decode := func(s string) ([]byte, error) {
+		return base64.RawURLEncoding.DecodeString(s)
+	}
+
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		if len(e) > 4 {
+			return nil, errors.New("DPoP proof RSA exponent is invalid")
+		}
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if key.N.BitLen() < 2048 || key.E < 3 {
+			return nil, errors.New("DPoP proof RSA key is too weak")
+		}
+		return key, nil
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, errors.New("DPoP proof EC key must use P-256")
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("DPoP proof EC key is invalid")
+		}
+		// ecdh refuses points that are not on the curve
+		point := append([]byte{4}, append(x, y...)...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, errors.New("DPoP proof EC key is invalid")
+		}
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, errors.New("DPoP proof OKP key must use Ed25519")
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("DPoP proof Ed25519 key is invalid")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, errors.New("DPoP proof jwk type is not supported")
+	}
+}
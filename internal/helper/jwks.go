@@ -0,0 +1,139 @@
+package helper
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sort"
+	"time"
+)
+
+// JWKSMaxAge is how long verifiers may cache the key set, a new key
+// is published at least this long before it signs
+const JWKSMaxAge = 5 * time.Minute
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// publicJWK only carries the members RFC 7638 needs for the thumbprint
+func publicJWK(public crypto.PublicKey) (JWK, error) {
+	switch pub := public.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			N:   b64(pub.N.Bytes()),
+			E:   b64(big.NewInt(int64(pub.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		ecdh, err := pub.ECDH()
+		if err != nil {
+			return JWK{}, err
+		}
+		// uncompressed point, 0x04 || X || Y
+		point := ecdh.Bytes()
+		size := (len(point) - 1) / 2
+		return JWK{
+			Kty: "EC",
+			Crv: pub.Curve.Params().Name,
+			X:   b64(point[1 : 1+size]),
+			Y:   b64(point[1+size:]),
+		}, nil
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   b64(pub),
+		}, nil
+	default:
+		return JWK{}, errors.New("public key type is not supported")
+	}
+}
+
+func jwkThumbprint(public crypto.PublicKey) (string, error) {
+	jwk, err := publicJWK(public)
+	if err != nil {
+		return "", err
+	}
+
+	// required members only, in lexicographic order
+	var members any
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	default:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	}
+
+	raw, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(raw)
+	return b64(sum[:]), nil
+}
+
+// JWKS lists the public half of every key an access token may be signed with,
+// it is empty while tokens are signed with a shared secret
+func JWKS() (JWKSet, error) {
+	set := JWKSet{Keys: []JWK{}}
+
+	keys, err := SigningKeys()
+	if err != nil {
+		return set, err
+	}
+
+	for _, key := range keys.Public() {
+		jwk, err := publicJWK(key.Public)
+		if err != nil {
+			return set, err
+		}
+		jwk.Use = "sig"
+		jwk.Alg = key.Alg
+		jwk.Kid = key.KID
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].Kid < set.Keys[j].Kid
+	})
+
+	return set, nil
+}
@@ -6,11 +6,13 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"log"
 	"os"
 	"strconv"
 	"strings"
 	"time"
 
+	"auth/config"
 	"auth/internal/model"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -40,46 +42,324 @@ func hashToken(token string) string {
 	return hex.EncodeToString(sum[:])
 }
 
+// HashToken is the sha256 hex digest used for every opaque token kept in redis
+func HashToken(token string) string {
+	return hashToken(token)
+}
+
+// access tokens carry the RFC 9068 typ, every other token signed with the
+// same secret has a typ of its own, so none of them passes for an access token
+const (
+	accessTokenTyp = "at+jwt"
+	idTokenTyp     = "JWT"
+)
+
 func refreshKey(jti string) string {
 	return "refresh:" + jti
 }
 
-func CreateAccessToken(user model.User) (string, error) {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		return "", errors.New("JWT_SECRET missing")
-	}
+func refreshUserKey(userID int) string {
+	return "refresh:user:" + strconv.Itoa(userID)
+}
+
+// a retired refresh token is remembered as used for as long as its family
+// lives, presenting it again is reuse and ends the family
+func refreshUsedKey(jti string) string {
+	return "refresh:used:" + jti
+}
 
+func AccessTokenExpiry() (time.Duration, error) {
 	expiryStr := os.Getenv("JWT_EXPIRED")
 	if expiryStr == "" {
 		expiryStr = "10m"
 	}
+	return ParseExpiry(expiryStr)
+}
+
+type authTimeKey struct{}
+
+// WithAuthTime records when the user last signed in with a credential,
+// tokens created with the context carry it as auth_time
+func WithAuthTime(ctx context.Context, at time.Time) context.Context {
+	return context.WithValue(ctx, authTimeKey{}, at)
+}
+
+func authTime(ctx context.Context) *jwt.NumericDate {
+	at, ok := ctx.Value(authTimeKey{}).(time.Time)
+	if !ok || at.IsZero() {
+		return nil
+	}
+	return jwt.NewNumericDate(at)
+}
+
+type refreshSessionKey struct{}
+
+// WithRefreshSession ties the access tokens created with the context to the
+// session of a refresh token just issued, so they end when it is evicted
+func WithRefreshSession(ctx context.Context, refreshToken string) context.Context {
+	claims := &model.ClaimsModel{}
+	if _, _, err := jwt.NewParser().ParseUnverified(refreshToken, claims); err != nil || claims.SessionID == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, refreshSessionKey{}, claims.SessionID)
+}
+
+func refreshSession(ctx context.Context) string {
+	sid, _ := ctx.Value(refreshSessionKey{}).(string)
+	return sid
+}
+
+func CreateAccessToken(ctx context.Context, user model.User) (string, error) {
+	duration, err := AccessTokenExpiry()
+	if err != nil {
+		return "", err
+	}
 
-	duration, err := ParseExpiry(expiryStr)
+	gen, err := tokenGeneration(ctx, user.ID, accessDenylistClient())
 	if err != nil {
 		return "", err
 	}
 
 	claims := model.ClaimsModel{
-		UserID:   user.ID,
-		Role:     user.Role,
-		Username: user.Username,
+		UserID:       user.ID,
+		Role:         user.Role,
+		Username:     user.Username,
+		Confirmation: dpopConfirmation(ctx),
+		SessionID:    refreshSession(ctx),
+		Generation:   gen,
+		AuthTime:     authTime(ctx),
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
 			Subject:   strconv.Itoa(user.ID),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(duration)),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(secret))
+	return signToken(claims, accessTokenTyp)
+}
+
+// CreateClientAccessToken is the access token handed to an oauth client,
+// it names the client and carries the granted scope. A zero ttl uses JWT_EXPIRED,
+// nothing lives longer, the signing keys are retired on it.
+func CreateClientAccessToken(
+	ctx context.Context,
+	user model.User,
+	clientID string,
+	scope string,
+	ttl time.Duration,
+) (string, error) {
+	duration, err := AccessTokenExpiry()
+	if err != nil {
+		return "", err
+	}
+	if ttl > 0 && ttl < duration {
+		duration = ttl
+	}
+
+	gen, err := tokenGeneration(ctx, user.ID, accessDenylistClient())
+	if err != nil {
+		return "", err
+	}
+
+	claims := model.ClaimsModel{
+		UserID:       user.ID,
+		Role:         user.Role,
+		Username:     user.Username,
+		ClientID:     clientID,
+		Scope:        scope,
+		Confirmation: dpopConfirmation(ctx),
+		SessionID:    refreshSession(ctx),
+		Generation:   gen,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Issuer:    config.BaseURL(),
+			Subject:   strconv.Itoa(user.ID),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(duration)),
+		},
+	}
+
+	return signToken(claims, accessTokenTyp)
+}
+
+// CreateMachineAccessToken is issued through the client_credentials grant,
+// the client is the subject and the scope is all it may do. The ttl is capped
+// like the one of CreateClientAccessToken.
+func CreateMachineAccessToken(ctx context.Context, clientID string, scope string, ttl time.Duration) (string, error) {
+	duration, err := AccessTokenExpiry()
+	if err != nil {
+		return "", err
+	}
+	if ttl > 0 && ttl < duration {
+		duration = ttl
+	}
+
+	claims := model.ClaimsModel{
+		ClientID:     clientID,
+		Scope:        scope,
+		Kind:         model.PrincipalClient,
+		Confirmation: dpopConfirmation(ctx),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Issuer:    config.BaseURL(),
+			Subject:   clientID,
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(duration)),
+		},
+	}
+
+	return signToken(claims, accessTokenTyp)
 }
 
+// CreateExchangedAccessToken is the token exchange result, it keeps the
+// subject of the original token and names the client that asked for it.
+// It never outlives the subject token and carries no role, what it may do
+// is its scope at the audience it is meant for. It stays in the session of
+// the subject token and ends with it.
+func CreateExchangedAccessToken(
+	ctx context.Context,
+	subject *model.ClaimsModel,
+	clientID string,
+	scope string,
+	audience []string,
+	actor *model.Actor,
+	ttl time.Duration,
+) (string, time.Duration, error) {
+	duration, err := AccessTokenExpiry()
+	if err != nil {
+		return "", 0, err
+	}
+	if ttl > 0 && ttl < duration {
+		duration = ttl
+	}
+	if subject.ExpiresAt != nil {
+		if remaining := time.Until(subject.ExpiresAt.Time); remaining < duration {
+			duration = remaining
+		}
+	}
+	if duration <= 0 {
+		return "", 0, errors.New("subject token has expired")
+	}
+	if len(audience) == 0 {
+		return "", 0, errors.New("exchanged token needs an audience")
+	}
+
+	claims := model.ClaimsModel{
+		UserID:       subject.UserID,
+		Username:     subject.Username,
+		ClientID:     clientID,
+		Scope:        scope,
+		Kind:         subject.Kind,
+		Actor:        actor,
+		Confirmation: dpopConfirmation(ctx),
+		SessionID:    subject.SessionID,
+		Generation:   subject.Generation,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Issuer:    config.BaseURL(),
+			Subject:   subject.Subject,
+			Audience:  audience,
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(duration)),
+		},
+	}
+
+	token, err := signToken(claims, accessTokenTyp)
+	if err != nil {
+		return "", 0, err
+	}
+	return token, duration, nil
+}
+
+// SignIDToken signs an openid connect id token with the access token keys
+func SignIDToken(claims model.IDTokenClaims) (string, error) {
+	return signToken(claims, idTokenTyp)
+}
+
+// signToken uses the active key of the key set,
+// or JWT_SECRET while HS256 is configured without a key store
+func signToken(claims jwt.Claims, typ string) (string, error) {
+	keys, err := SigningKeys()
+	if err != nil {
+		return "", err
+	}
+
+	key := keys.Active()
+	if key == nil {
+		if SigningAlg() != AlgHS256 || KeyStoreEnabled() {
+			return "", errors.New("no active signing key")
+		}
+		secret := os.Getenv("JWT_SECRET")
+		if secret == "" {
+			return "", errors.New("JWT_SECRET missing")
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		token.Header["typ"] = typ
+		return token.SignedString([]byte(secret))
+	}
+
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["typ"] = typ
+	token.Header["kid"] = key.KID
+	return token.SignedString(key.signKey())
+}
+
+// CreateRefreshToken starts a first party session, its lifetime
+// follows the session policy of the user's role
 func CreateRefreshToken(
 	ctx context.Context,
 	user model.User,
 	rdb *redis.Client,
-	issued_at *time.Time,
+) (string, error) {
+	return createRefreshToken(ctx, user, "", "", refreshFamily{}, 0, rdb)
+}
+
+// CreateClientRefreshToken keeps the client and scope, so a refresh
+// through the token endpoint hands out the same grant again.
+// A non zero ttl replaces the idle lifetime of the session policy.
+func CreateClientRefreshToken(
+	ctx context.Context,
+	user model.User,
+	clientID string,
+	scope string,
+	ttl time.Duration,
+	rdb *redis.Client,
+) (string, error) {
+	return createRefreshToken(ctx, user, clientID, scope, refreshFamily{}, ttl, rdb)
+}
+
+// refreshFamily is what a rotation carries over from the token it replaces,
+// the zero value starts a new session
+type refreshFamily struct {
+	SessionID string
+	Remember  bool
+	ExpiresAt time.Time
+	AuthTime  *jwt.NumericDate
+}
+
+// familyOf reads the family of a refresh token, tokens from before the
+// session deadline claim kept the deadline in iat
+func familyOf(claims *model.ClaimsModel) refreshFamily {
+	family := refreshFamily{SessionID: claims.SessionID, Remember: claims.Remember, AuthTime: claims.AuthTime}
+	if claims.SessionExpiresAt != nil {
+		family.ExpiresAt = claims.SessionExpiresAt.Time
+	} else if claims.IssuedAt != nil {
+		family.ExpiresAt = claims.IssuedAt.Time
+	}
+	return family
+}
+
+// createRefreshToken lives for the idle lifetime of the session policy,
+// never past the absolute end of the session
+func createRefreshToken(
+	ctx context.Context,
+	user model.User,
+	clientID string,
+	scope string,
+	family refreshFamily,
+	ttl time.Duration,
+	rdb *redis.Client,
 ) (string, error) {
 	if rdb == nil {
 		return "", errors.New("redis client required for refresh token")
@@ -90,29 +370,67 @@ func CreateRefreshToken(
 		return "", errors.New("JWT_REFRESH_SECRET missing")
 	}
 
-	expiryStr := os.Getenv("JWT_REFRESH_EXPIRED")
-	if expiryStr == "" {
-		expiryStr = "7d"
+	fresh := family.SessionID == ""
+	if fresh {
+		family.SessionID = uuid.NewString()
+	}
+	start := family.ExpiresAt.IsZero()
+	if start {
+		family.Remember = rememberMe(ctx)
+		family.AuthTime = authTime(ctx)
+	}
+
+	idle, absolute, err := sessionLifetime(user.Role, clientID, family.Remember, ttl)
+	if err != nil {
+		return "", err
 	}
 
-	duration, err := ParseExpiry(expiryStr)
+	limit := sessionCap{}
+	if fresh {
+		if limit, err = sessionLimit(user, clientID); err != nil {
+			return "", err
+		}
+	}
+
+	now := time.Now()
+	if start {
+		family.ExpiresAt = now.Add(absolute)
+	}
+	expiresAt := now.Add(idle)
+	if family.ExpiresAt.Before(expiresAt) {
+		expiresAt = family.ExpiresAt
+	}
+	duration := expiresAt.Sub(now)
+	if duration < time.Second {
+		return "", ErrSessionExpired
+	}
+
+	confirmation := dpopConfirmation(ctx)
+
+	gen, err := tokenGeneration(ctx, user.ID, rdb)
 	if err != nil {
 		return "", err
 	}
 
 	jti := uuid.NewString()
-	expiresAt := time.Now().Add(duration)
-	issuedAt := expiresAt.Add(30 * 24 * time.Hour)
 
 	claims := model.ClaimsModel{
-		UserID:   user.ID,
-		Role:     user.Role,
-		Username: user.Username,
+		UserID:           user.ID,
+		Role:             user.Role,
+		Username:         user.Username,
+		ClientID:         clientID,
+		Scope:            scope,
+		Confirmation:     confirmation,
+		SessionID:        family.SessionID,
+		SessionExpiresAt: jwt.NewNumericDate(family.ExpiresAt),
+		Remember:         family.Remember,
+		Generation:       gen,
+		AuthTime:         family.AuthTime,
 		RegisteredClaims: jwt.RegisteredClaims{
 			Issuer:    "auth-service",
 			ID:        jti,
 			Subject:   strconv.Itoa(user.ID),
-			IssuedAt:  jwt.NewNumericDate(issuedAt),
+			IssuedAt:  jwt.NewNumericDate(now),
 			ExpiresAt: jwt.NewNumericDate(expiresAt),
 		},
 	}
@@ -123,31 +441,28 @@ func CreateRefreshToken(
 		return "", err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	storeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 200*time.Millisecond)
 	defer cancel()
 
-	key := refreshKey(jti)
-	if err := rdb.Set(ctx, key, hashToken(tokenString), duration).Err(); err != nil {
+	// the per user sets let every token and session of a user be found at once
+	if err := storeRefreshToken(storeCtx, rdb, user, clientID, jti, hashToken(tokenString), family, fresh, limit, duration); err != nil {
 		return "", err
 	}
 
 	return tokenString, nil
 }
 
-func ValidateAccessToken(tokenString string) (*model.ClaimsModel, error) {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		return nil, errors.New("JWT_SECRET missing")
-	}
-
+// ValidateAccessToken only takes tokens typed at+jwt, verification links,
+// mfa tokens and id tokens are refused even when signed with the same key
+func ValidateAccessToken(ctx context.Context, tokenString string) (*model.ClaimsModel, error) {
 	token, err := jwt.ParseWithClaims(
 		tokenString,
 		&model.ClaimsModel{},
 		func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			if typ, _ := token.Header["typ"].(string); typ != accessTokenTyp {
+				return nil, errors.New("token is not an access token")
 			}
-			return []byte(secret), nil
+			return accessKeyFunc(token)
 		},
 	)
 	if err != nil {
@@ -159,14 +474,17 @@ func ValidateAccessToken(tokenString string) (*model.ClaimsModel, error) {
 		return nil, errors.New("invalid access token")
 	}
 
+	if err := accessTokenRevoked(ctx, claims); err != nil {
+		return nil, err
+	}
+	if err := checkTokenGeneration(ctx, claims, accessDenylistClient()); err != nil {
+		return nil, err
+	}
+
 	return claims, nil
 }
 
-func ValidateRefreshToken(
-	ctx context.Context,
-	refreshToken string,
-	rdb *redis.Client,
-) (*model.ClaimsModel, error) {
+func parseRefreshToken(refreshToken string) (*model.ClaimsModel, error) {
 	secret := os.Getenv("JWT_REFRESH_SECRET")
 	if secret == "" {
 		return nil, errors.New("JWT_REFRESH_SECRET missing")
@@ -191,11 +509,61 @@ func ValidateRefreshToken(
 		return nil, errors.New("invalid refresh token")
 	}
 
-	jti := claims.ID
-	if jti == "" {
+	if claims.ID == "" {
 		return nil, errors.New("refresh token missing jti")
 	}
 
+	return claims, nil
+}
+
+// InspectRefreshToken checks a refresh token against its redis state without
+// touching it, unlike ValidateRefreshToken a reused token does not revoke anything
+func InspectRefreshToken(
+	ctx context.Context,
+	refreshToken string,
+	rdb *redis.Client,
+) (*model.ClaimsModel, error) {
+	claims, err := parseRefreshToken(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkTokenGeneration(ctx, claims, rdb); err != nil {
+		return nil, err
+	}
+
+	stored, err := rdb.Get(ctx, refreshKey(claims.ID)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, errors.New("refresh token expired or revoked")
+		}
+		return nil, err
+	}
+	if stored != hashToken(refreshToken) {
+		return nil, errors.New("refresh token expired or revoked")
+	}
+
+	return claims, nil
+}
+
+func ValidateRefreshToken(
+	ctx context.Context,
+	refreshToken string,
+	rdb *redis.Client,
+) (*model.ClaimsModel, error) {
+	claims, err := parseRefreshToken(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := CheckDPoPBinding(ctx, claims); err != nil {
+		return nil, err
+	}
+	if err := checkTokenGeneration(ctx, claims, rdb); err != nil {
+		return nil, err
+	}
+
+	jti := claims.ID
 	key := refreshKey(jti)
 	_, err = rdb.Get(ctx, key).Result()
 	if err == nil {
@@ -203,10 +571,9 @@ func ValidateRefreshToken(
 	}
 
 	if err == redis.Nil {
-		usedKey := "refresh:used:" + jti
-		if _, err := rdb.Get(ctx, usedKey).Result(); err == nil {
-			// paksa logout
-			RevokeRefreshToken(refreshToken, rdb)
+		if _, err := rdb.Get(ctx, refreshUsedKey(jti)).Result(); err == nil {
+			// paksa logout, whoever holds the newer token of the family is out too
+			revokeRefreshFamily(ctx, refreshToken, claims, rdb)
 			return nil, errors.New("refresh token reuse detected")
 		}
 		return nil, errors.New("refresh token expired or revoked")
@@ -215,6 +582,36 @@ func ValidateRefreshToken(
 	return nil, err
 }
 
+// revokeRefreshFamily ends the session a reused token belongs to, the session
+// id is the family every rotation of the first token inherits. Tokens from
+// before sessions existed have no family and only lose themselves.
+func revokeRefreshFamily(ctx context.Context, refreshToken string, claims *model.ClaimsModel, rdb *redis.Client) {
+	if claims.SessionID == "" {
+		RevokeRefreshToken(refreshToken, rdb)
+	} else if err := RevokeSession(ctx, claims.UserID, claims.SessionID, rdb); err != nil && !errors.Is(err, ErrSessionNotFound) {
+		log.Printf("failed revoking refresh family %s: %v", claims.SessionID, err)
+	}
+
+	EmitSecurityEvent(ctx, model.SecurityEvent{
+		Type:      model.SecurityEventRefreshReuse,
+		UserID:    claims.UserID,
+		SessionID: claims.SessionID,
+		ClientID:  claims.ClientID,
+	}, rdb)
+}
+
+// usedMarkerTTL is how long a retired token must stay known as used, the
+// rest of its family's life, or its own for tokens from before families
+func usedMarkerTTL(claims *model.ClaimsModel) time.Duration {
+	if ttl := time.Until(familyOf(claims).ExpiresAt); ttl > 0 {
+		return ttl
+	}
+	if claims.ExpiresAt != nil {
+		return time.Until(claims.ExpiresAt.Time)
+	}
+	return 0
+}
+
 func RevokeRefreshToken(refreshToken string, rdb *redis.Client) error {
 	if rdb == nil {
 		return errors.New("redis client required")
@@ -252,47 +649,208 @@ func RevokeRefreshToken(refreshToken string, rdb *redis.Client) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
 	defer cancel()
 
-	_ = rdb.Del(ctx, refreshKey(jti)).Err()
-	_ = rdb.Set(ctx, "refresh:used:"+jti, "1", 2*time.Minute).Err() // 2 mnt
+	pipe := rdb.TxPipeline()
+	pipe.Del(ctx, refreshKey(jti))
+	pipe.SRem(ctx, refreshUserKey(claims.UserID), jti)
+	if ttl := usedMarkerTTL(claims); ttl > 0 {
+		pipe.Set(ctx, refreshUsedKey(jti), claims.SessionID, ttl)
+	}
+	dropSession(ctx, pipe, claims.UserID, claims.SessionID)
+	_, _ = pipe.Exec(ctx)
 
 	return nil
 }
 
+// RevokeAllRefreshTokens signs the user out of every session
+func RevokeAllRefreshTokens(ctx context.Context, userID int, rdb *redis.Client) error {
+	return RevokeOtherRefreshTokens(ctx, userID, "", rdb)
+}
+
+// RevokeOtherRefreshTokens signs the user out of every session except keepJTI,
+// the access tokens of those sessions stop working with them
+func RevokeOtherRefreshTokens(ctx context.Context, userID int, keepJTI string, rdb *redis.Client) error {
+	if rdb == nil {
+		return errors.New("redis client required")
+	}
+
+	accessTTL, err := AccessTokenExpiry()
+	if err != nil {
+		return err
+	}
+
+	jtis, err := rdb.SMembers(ctx, refreshUserKey(userID)).Result()
+	if err != nil {
+		return err
+	}
+
+	// a session is kept when it points at keepJTI
+	sids, err := rdb.SMembers(ctx, sessionUserKey(userID)).Result()
+	if err != nil {
+		return err
+	}
+	current := make([]*redis.SliceCmd, len(sids))
+	remaining := make([]*redis.DurationCmd, len(jtis))
+	read := rdb.Pipeline()
+	for i, sid := range sids {
+		current[i] = read.HMGet(ctx, sessionKey(sid), "jti", "expires_at")
+	}
+	for i, jti := range jtis {
+		remaining[i] = read.TTL(ctx, refreshKey(jti))
+	}
+	if _, err := read.Exec(ctx); err != nil && err != redis.Nil {
+		return err
+	}
+
+	// the used markers last until the family would have ended,
+	// tokens without a session only until they expire themselves
+	families := make(map[string]time.Time, len(sids))
+	kept := make([]bool, len(sids))
+	for i, cmd := range current {
+		values := cmd.Val()
+		if len(values) != 2 {
+			continue
+		}
+		jti, _ := values[0].(string)
+		kept[i] = keepJTI != "" && jti == keepJTI
+		if end, _ := values[1].(string); end != "" {
+			if unix, err := strconv.ParseInt(end, 10, 64); err == nil {
+				families[jti] = time.Unix(unix, 0)
+			}
+		}
+	}
+
+	pipe := rdb.TxPipeline()
+	for i, jti := range jtis {
+		if jti == keepJTI {
+			continue
+		}
+		pipe.Del(ctx, refreshKey(jti))
+		pipe.SRem(ctx, refreshUserKey(userID), jti)
+
+		ttl := remaining[i].Val()
+		if end, ok := families[jti]; ok {
+			ttl = time.Until(end)
+		}
+		if ttl > 0 {
+			pipe.Set(ctx, refreshUsedKey(jti), "1", ttl)
+		}
+	}
+	for i, sid := range sids {
+		if kept[i] {
+			continue
+		}
+		dropSession(ctx, pipe, userID, sid)
+		denySessionAccess(ctx, pipe, sid, accessTTL)
+	}
+	_, err = pipe.Exec(ctx)
+
+	return err
+}
+
 func RefreshRotation(
 	ctx context.Context,
 	refreshToken string,
 	user model.User,
 	rdb *redis.Client,
 ) (string, error) {
-	claims, err := ValidateRefreshToken(ctx, refreshToken, rdb)
+	return refreshRotation(ctx, refreshToken, user, 0, rdb)
+}
+
+// ClientRefreshRotation rotates a client refresh token with the client's own lifetime
+func ClientRefreshRotation(
+	ctx context.Context,
+	refreshToken string,
+	user model.User,
+	ttl time.Duration,
+	rdb *redis.Client,
+) (string, error) {
+	return refreshRotation(ctx, refreshToken, user, ttl, rdb)
+}
+
+// consumes a refresh token and marks it used in one step, of two requests
+// racing with the same token only one gets through, the other is reuse
+var consumeRefreshScript = redis.NewScript(`
+local stored = redis.call("GET", KEYS[1])
+if not stored then
+  if redis.call("EXISTS", KEYS[2]) == 1 then
+    return -1
+  end
+  return 0
+end
+if stored ~= ARGV[1] then
+  return 0
+end
+
+redis.call("DEL", KEYS[1])
+redis.call("SREM", KEYS[3], ARGV[2])
+local ttl = tonumber(ARGV[4])
+if ttl > 0 then
+  redis.call("SET", KEYS[2], ARGV[3], "EX", ttl)
+end
+return 1
+`)
+
+func refreshRotation(
+	ctx context.Context,
+	refreshToken string,
+	user model.User,
+	ttl time.Duration,
+	rdb *redis.Client,
+) (string, error) {
+	claims, err := parseRefreshToken(refreshToken)
 	if err != nil {
 		return "", err
 	}
 
-	oldJTI := claims.ID
-	if oldJTI == "" {
-		return "", errors.New("refresh token missing jti")
-	}
-
-	issuedAt := claims.IssuedAt.Time
-	if time.Now().After(issuedAt) {
-		_ = rdb.Del(ctx, refreshKey(oldJTI)).Err()
-		return "", errors.New("session expired, please login again")
+	if err := CheckDPoPBinding(ctx, claims); err != nil {
+		return "", err
 	}
-
-	if err := rdb.Del(ctx, refreshKey(oldJTI)).Err(); err != nil {
+	if err := checkTokenGeneration(ctx, claims, rdb); err != nil {
 		return "", err
 	}
 
-	reuseKey := "refresh:used:" + oldJTI
-	if err := rdb.Set(ctx, reuseKey, "1", 2*time.Minute).Err(); err != nil {
+	oldJTI := claims.ID
+	family := familyOf(claims)
+	if !time.Now().Before(family.ExpiresAt) {
+		_ = rdb.Del(ctx, refreshKey(oldJTI)).Err()
+		return "", ErrSessionExpired
+	}
+
+	// the retired token is watched for as long as the family lives
+	consumed, err := consumeRefreshScript.Run(
+		ctx,
+		rdb,
+		[]string{refreshKey(oldJTI), refreshUsedKey(oldJTI), refreshUserKey(claims.UserID)},
+		hashToken(refreshToken),
+		oldJTI,
+		claims.SessionID,
+		int(usedMarkerTTL(claims).Seconds()),
+	).Int()
+	if err != nil {
 		return "", err
 	}
+	switch consumed {
+	case -1:
+		revokeRefreshFamily(ctx, refreshToken, claims, rdb)
+		return "", errors.New("refresh token reuse detected")
+	case 0:
+		return "", errors.New("refresh token expired or revoked")
+	}
 
-	newToken, err := CreateRefreshToken(ctx, user, rdb, &issuedAt)
+	newToken, err := createRefreshToken(ctx, user, claims.ClientID, claims.Scope, family, ttl, rdb)
 	if err != nil {
 		return "", err
 	}
 
 	return newToken, nil
 }
+
+// RefreshCookieMaxAge is how long the cookie of a refresh token just issued
+// should live, the cookie goes when the token does
+func RefreshCookieMaxAge(refreshToken string) int {
+	claims := &model.ClaimsModel{}
+	if _, _, err := jwt.NewParser().ParseUnverified(refreshToken, claims); err != nil || claims.ExpiresAt == nil {
+		return 0
+	}
+	return max(int(time.Until(claims.ExpiresAt.Time).Seconds()), 0)
+}
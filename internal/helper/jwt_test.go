@@ -0,0 +1,221 @@
+package helper
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"auth/internal/model"
+)
+
+func useTestSecrets(t *testing.T) {
+	t.Helper()
+
+	t.Setenv("JWT_SECRET", "test-access-secret-0123456789abcdef")
+	t.Setenv("JWT_REFRESH_SECRET", "test-refresh-secret-0123456789abcdef")
+	t.Setenv("JWT_SIGNING_ALG", "")
+	t.Setenv("JWT_KEY_STORE", "")
+}
+
+func testUser() model.User {
+	email := "user@example.com"
+	return model.User{ID: 42, Username: "user", Role: model.RoleUser, Email: &email}
+}
+
+func TestValidateAccessToken(t *testing.T) {
+	useTestSecrets(t)
+	ctx := context.Background()
+
+	token, err := CreateAccessToken(ctx, testUser())
+	if err != nil {
+		t.Fatalf("CreateAccessToken: %v", err)
+	}
+
+	claims, err := ValidateAccessToken(ctx, token)
+	if err != nil {
+		t.Fatalf("ValidateAccessToken: %v", err)
+	}
+	if claims.UserID != 42 || claims.Role != model.RoleUser {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+}
+
+// a verification link signed with the fallback JWT_SECRET has the same
+// shape as an access token, only its typ tells them apart
+func TestValidateAccessTokenRejectsVerificationToken(t *testing.T) {
+	useTestSecrets(t)
+	t.Setenv("EMAIL_VERIFICATION_SECRET", "")
+	ctx := context.Background()
+	_, rdb := newTestRedis(t)
+
+	token, err := CreateEmailVerificationToken(ctx, testUser(), rdb)
+	if err != nil {
+		t.Fatalf("CreateEmailVerificationToken: %v", err)
+	}
+
+	if _, err := ValidateAccessToken(ctx, token); err == nil {
+		t.Fatal("verification token was accepted as an access token")
+	}
+
+	// and the other way around
+	access, err := CreateAccessToken(ctx, testUser())
+	if err != nil {
+		t.Fatalf("CreateAccessToken: %v", err)
+	}
+	if _, err := ConsumeEmailVerificationToken(ctx, access, rdb); err == nil {
+		t.Fatal("access token was accepted as a verification token")
+	}
+
+	claims, err := ConsumeEmailVerificationToken(ctx, token, rdb)
+	if err != nil {
+		t.Fatalf("ConsumeEmailVerificationToken: %v", err)
+	}
+	if claims.UserID != 42 || claims.Email != "user@example.com" {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+	if _, err := ConsumeEmailVerificationToken(ctx, token, rdb); err == nil {
+		t.Fatal("verification token was accepted twice")
+	}
+}
+
+func TestValidateAccessTokenRejectsIDToken(t *testing.T) {
+	useTestSecrets(t)
+	ctx := context.Background()
+
+	token, err := SignIDToken(model.IDTokenClaims{})
+	if err != nil {
+		t.Fatalf("SignIDToken: %v", err)
+	}
+	if _, err := ValidateAccessToken(ctx, token); err == nil {
+		t.Fatal("id token was accepted as an access token")
+	}
+}
+
+// a refresh is no new sign in, the rotated token keeps the time of the login
+func TestRefreshRotationKeepsAuthTime(t *testing.T) {
+	useTestSecrets(t)
+	_, rdb := newTestRedis(t)
+	signedIn := time.Now().Add(-time.Hour).Truncate(time.Second)
+	ctx := WithAuthTime(context.Background(), signedIn)
+
+	refresh, err := CreateRefreshToken(ctx, testUser(), rdb)
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+
+	rotated, err := RefreshRotation(context.Background(), refresh, testUser(), rdb)
+	if err != nil {
+		t.Fatalf("RefreshRotation: %v", err)
+	}
+	claims, err := InspectRefreshToken(context.Background(), rotated, rdb)
+	if err != nil {
+		t.Fatalf("InspectRefreshToken: %v", err)
+	}
+	if claims.AuthTime == nil || !claims.AuthTime.Time.Equal(signedIn) {
+		t.Fatalf("auth_time = %v, want %v", claims.AuthTime, signedIn)
+	}
+
+	access, err := CreateAccessToken(WithAuthTime(context.Background(), claims.AuthTime.Time), testUser())
+	if err != nil {
+		t.Fatalf("CreateAccessToken: %v", err)
+	}
+	accessClaims, err := ValidateAccessToken(context.Background(), access)
+	if err != nil {
+		t.Fatalf("ValidateAccessToken: %v", err)
+	}
+	if accessClaims.AuthTime == nil || !accessClaims.AuthTime.Time.Equal(signedIn) {
+		t.Fatalf("access auth_time = %v, want %v", accessClaims.AuthTime, signedIn)
+	}
+}
+
+// two requests racing with one refresh token, at most one may rotate it,
+// the other is reuse and ends the family
+func TestRefreshRotationRace(t *testing.T) {
+	useTestSecrets(t)
+	ctx := context.Background()
+	_, rdb := newTestRedis(t)
+
+	refresh, err := CreateRefreshToken(ctx, testUser(), rdb)
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+
+	const racers = 8
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		rotated []string
+	)
+	for i := 0; i < racers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := RefreshRotation(ctx, refresh, testUser(), rdb)
+			if err == nil {
+				mu.Lock()
+				rotated = append(rotated, token)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// the winner may still lose its new token to the family revocation
+	if len(rotated) > 1 {
+		t.Fatalf("%d requests rotated the same token, want at most 1", len(rotated))
+	}
+	for _, token := range rotated {
+		if _, err := InspectRefreshToken(ctx, token, rdb); err == nil {
+			t.Fatal("the family survived a reused refresh token")
+		}
+	}
+}
+
+func TestRefreshReuseRevokesFamily(t *testing.T) {
+	useTestSecrets(t)
+	ctx := context.Background()
+	mr, rdb := newTestRedis(t)
+
+	first, err := CreateRefreshToken(ctx, testUser(), rdb)
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	second, err := RefreshRotation(ctx, first, testUser(), rdb)
+	if err != nil {
+		t.Fatalf("RefreshRotation: %v", err)
+	}
+
+	// long after the old two minute marker would have gone
+	mr.FastForward(time.Hour)
+
+	if _, err := RefreshRotation(ctx, first, testUser(), rdb); err == nil {
+		t.Fatal("a rotated refresh token was accepted again")
+	}
+	if _, err := InspectRefreshToken(ctx, second, rdb); err == nil {
+		t.Fatal("the newer token of a reused family still works")
+	}
+}
+
+func TestRevokeRefreshTokenMarksUsedForFamilyLifetime(t *testing.T) {
+	useTestSecrets(t)
+	ctx := context.Background()
+	mr, rdb := newTestRedis(t)
+
+	refresh, err := CreateRefreshToken(ctx, testUser(), rdb)
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	claims, err := parseRefreshToken(refresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RevokeRefreshToken(refresh, rdb); err != nil {
+		t.Fatalf("RevokeRefreshToken: %v", err)
+	}
+	want := time.Until(claims.SessionExpiresAt.Time)
+	if ttl := mr.TTL(refreshUsedKey(claims.ID)); ttl < want-time.Minute {
+		t.Fatalf("used marker lives %s, want about %s", ttl, want)
+	}
+}
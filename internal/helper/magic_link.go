@@ -0,0 +1,127 @@
+package helper
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func magicLinkKey(tokenHash string) string {
+	return "magic_link:" + tokenHash
+}
+
+func magicLinkUserKey(userID int) string {
+	return "magic_link:user:" + strconv.Itoa(userID)
+}
+
+func MagicLinkExpiry() (time.Duration, error) {
+	expiryStr := os.Getenv("MAGIC_LINK_EXPIRED")
+	if expiryStr == "" {
+		expiryStr = "15m"
+	}
+	return ParseExpiry(expiryStr)
+}
+
+// CreateMagicLinkBinding returns the random value kept in the requesting
+// browser's cookie, a link only works together with it
+func CreateMagicLinkBinding() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CreateMagicLinkToken returns an opaque token, redis only keeps its hash
+// next to the hash of the browser binding. A new link voids the previous one.
+func CreateMagicLinkToken(ctx context.Context, userID int, binding string, rdb *redis.Client) (string, error) {
+	if rdb == nil {
+		return "", errors.New("redis client required for magic link")
+	}
+
+	duration, err := MagicLinkExpiry()
+	if err != nil {
+		return "", err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	tokenHash := hashToken(token)
+
+	oldHash, err := rdb.SetArgs(ctx, magicLinkUserKey(userID), tokenHash, redis.SetArgs{
+		TTL: duration,
+		Get: true,
+	}).Result()
+	if err != nil && err != redis.Nil {
+		return "", err
+	}
+	if oldHash != "" {
+		_ = rdb.Del(ctx, magicLinkKey(oldHash)).Err()
+	}
+
+	if err := rdb.HSet(ctx, magicLinkKey(tokenHash),
+		"user_id", userID,
+		"binding", hashToken(binding),
+	).Err(); err != nil {
+		return "", err
+	}
+	if err := rdb.Expire(ctx, magicLinkKey(tokenHash), duration).Err(); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// the link is only burned when the binding matches, so a mail scanner or a
+// forwarded link opened in another browser cannot use up the real one
+var consumeMagicLinkScript = redis.NewScript(`
+local data = redis.call("HMGET", KEYS[1], "user_id", "binding")
+if not data[1] then
+  return -1
+end
+if data[2] ~= ARGV[1] then
+  return -2
+end
+redis.call("DEL", KEYS[1])
+return tonumber(data[1])
+`)
+
+// ConsumeMagicLinkToken burns the token and returns its user id
+func ConsumeMagicLinkToken(ctx context.Context, token string, binding string, rdb *redis.Client) (int, error) {
+	if rdb == nil {
+		return 0, errors.New("redis client required for magic link")
+	}
+	if token == "" || binding == "" {
+		return 0, errors.New("magic link invalid or expired")
+	}
+
+	userID, err := consumeMagicLinkScript.Run(
+		ctx,
+		rdb,
+		[]string{magicLinkKey(hashToken(token))},
+		hashToken(binding),
+	).Int()
+	if err != nil {
+		return 0, err
+	}
+
+	switch userID {
+	case -1:
+		return 0, errors.New("magic link invalid or expired")
+	case -2:
+		return 0, errors.New("magic link was requested from another browser")
+	}
+
+	_ = rdb.Del(ctx, magicLinkUserKey(userID)).Err()
+
+	return userID, nil
+}
@@ -0,0 +1,135 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"auth/internal/model"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	mfaPendingPurpose  = "mfa_pending"
+	mfaPendingTyp      = "mfa+jwt"
+	mfaPendingTTL      = 5 * time.Minute
+	mfaPendingAttempts = 5
+)
+
+func mfaPendingKey(jti string) string {
+	return "mfa:pending:" + jti
+}
+
+// CreateMFAPendingToken is handed out after the password check of an mfa user,
+// it only proves the first factor and is worth nothing on the api
+func CreateMFAPendingToken(ctx context.Context, userID int, rdb *redis.Client) (string, error) {
+	if rdb == nil {
+		return "", errors.New("redis client required for mfa token")
+	}
+
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		return "", errors.New("JWT_SECRET missing")
+	}
+
+	jti := uuid.NewString()
+	claims := model.MFAClaims{
+		UserID:     userID,
+		Purpose:    mfaPendingPurpose,
+		RememberMe: rememberMe(ctx),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "auth-service",
+			ID:        jti,
+			Subject:   strconv.Itoa(userID),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(mfaPendingTTL)),
+		},
+	}
+
+	// typed so it is never taken for an access token signed with the same secret
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["typ"] = mfaPendingTyp
+	tokenString, err := token.SignedString([]byte(secret))
+	if err != nil {
+		return "", err
+	}
+
+	if err := rdb.Set(ctx, mfaPendingKey(jti), 0, mfaPendingTTL).Err(); err != nil {
+		return "", err
+	}
+
+	return tokenString, nil
+}
+
+// ValidateMFAPendingToken checks the token is still open, call FailMFAPendingToken
+// on a wrong code and ConsumeMFAPendingToken once the second factor passed
+func ValidateMFAPendingToken(ctx context.Context, tokenString string, rdb *redis.Client) (*model.MFAClaims, error) {
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		return nil, errors.New("JWT_SECRET missing")
+	}
+
+	token, err := jwt.ParseWithClaims(
+		tokenString,
+		&model.MFAClaims{},
+		func(token *jwt.Token) (interface{}, error) {
+			if typ, _ := token.Header["typ"].(string); typ != mfaPendingTyp {
+				return nil, errors.New("token is not an mfa token")
+			}
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return []byte(secret), nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*model.MFAClaims)
+	if !ok || !token.Valid || claims.Purpose != mfaPendingPurpose || claims.ID == "" {
+		return nil, errors.New("invalid mfa token")
+	}
+
+	if err := rdb.Get(ctx, mfaPendingKey(claims.ID)).Err(); err != nil {
+		if err == redis.Nil {
+			return nil, errors.New("mfa token expired or already used")
+		}
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// only counts while the token is alive, a plain INCR would recreate
+// a deleted key without expiry
+var failMFAScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+  return 0
+end
+local attempts = redis.call("INCR", KEYS[1])
+if attempts >= tonumber(ARGV[1]) then
+  redis.call("DEL", KEYS[1])
+end
+return attempts
+`)
+
+// FailMFAPendingToken counts a wrong code, the token dies after a few of them
+func FailMFAPendingToken(ctx context.Context, claims *model.MFAClaims, rdb *redis.Client) error {
+	return failMFAScript.Run(ctx, rdb, []string{mfaPendingKey(claims.ID)}, mfaPendingAttempts).Err()
+}
+
+// ConsumeMFAPendingToken burns the token, false means a concurrent request won
+func ConsumeMFAPendingToken(ctx context.Context, claims *model.MFAClaims, rdb *redis.Client) (bool, error) {
+	deleted, err := rdb.Del(ctx, mfaPendingKey(claims.ID)).Result()
+	if err != nil {
+		return false, err
+	}
+	return deleted == 1, nil
+}
@@ -0,0 +1,64 @@
+package helper
+
+import (
+	"context"
+	"testing"
+)
+
+// the pending token only proves the password, it must not open the api
+func TestValidateAccessTokenRejectsMFAPendingToken(t *testing.T) {
+	useTestSecrets(t)
+	ctx := context.Background()
+	_, rdb := newTestRedis(t)
+
+	pending, err := CreateMFAPendingToken(ctx, 42, rdb)
+	if err != nil {
+		t.Fatalf("CreateMFAPendingToken: %v", err)
+	}
+	if _, err := ValidateAccessToken(ctx, pending); err == nil {
+		t.Fatal("mfa pending token was accepted as an access token")
+	}
+
+	access, err := CreateAccessToken(ctx, testUser())
+	if err != nil {
+		t.Fatalf("CreateAccessToken: %v", err)
+	}
+	if _, err := ValidateMFAPendingToken(ctx, access, rdb); err == nil {
+		t.Fatal("access token was accepted as an mfa pending token")
+	}
+
+	claims, err := ValidateMFAPendingToken(ctx, pending, rdb)
+	if err != nil {
+		t.Fatalf("ValidateMFAPendingToken: %v", err)
+	}
+	if claims.UserID != 42 {
+		t.Fatalf("user id = %d, want 42", claims.UserID)
+	}
+}
+
+func TestMFAPendingTokenDiesAfterFailedAttempts(t *testing.T) {
+	useTestSecrets(t)
+	ctx := context.Background()
+	_, rdb := newTestRedis(t)
+
+	pending, err := CreateMFAPendingToken(ctx, 42, rdb)
+	if err != nil {
+		t.Fatalf("CreateMFAPendingToken: %v", err)
+	}
+	claims, err := ValidateMFAPendingToken(ctx, pending, rdb)
+	if err != nil {
+		t.Fatalf("ValidateMFAPendingToken: %v", err)
+	}
+
+	for i := 0; i < mfaPendingAttempts; i++ {
+		if err := FailMFAPendingToken(ctx, claims, rdb); err != nil {
+			t.Fatalf("FailMFAPendingToken: %v", err)
+		}
+	}
+	if _, err := ValidateMFAPendingToken(ctx, pending, rdb); err == nil {
+		t.Fatal("token survived too many wrong codes")
+	}
+	if consumed, err := ConsumeMFAPendingToken(ctx, claims, rdb); err != nil || consumed {
+		t.Fatalf("dead token was consumed: %v %v", consumed, err)
+	}
+}
@@ -0,0 +1,105 @@
+package helper
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	minPasswordLength = 8
+	// bcrypt ignores everything after 72 bytes
+	maxPasswordLength = 72
+)
+
+func ValidatePassword(password string) error {
+	if strings.TrimSpace(password) == "" {
+		return errors.New("password cannot be empty")
+	}
+	if len(password) < minPasswordLength {
+		return errors.New("password must be at least 8 characters")
+	}
+	if len(password) > maxPasswordLength {
+		return errors.New("password must be at most 72 bytes")
+	}
+	return nil
+}
+
+func passwordResetKey(tokenHash string) string {
+	return "password_reset:" + tokenHash
+}
+
+func passwordResetUserKey(userID int) string {
+	return "password_reset:user:" + strconv.Itoa(userID)
+}
+
+func PasswordResetExpiry() (time.Duration, error) {
+	expiryStr := os.Getenv("PASSWORD_RESET_EXPIRED")
+	if expiryStr == "" {
+		expiryStr = "30m"
+	}
+	return ParseExpiry(expiryStr)
+}
+
+// CreatePasswordResetToken returns an opaque token, only its hash is kept in redis.
+// Requesting a new token voids the previous one of the same user.
+func CreatePasswordResetToken(ctx context.Context, userID int, rdb *redis.Client) (string, error) {
+	if rdb == nil {
+		return "", errors.New("redis client required for reset token")
+	}
+
+	duration, err := PasswordResetExpiry()
+	if err != nil {
+		return "", err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	tokenHash := hashToken(token)
+
+	oldHash, err := rdb.SetArgs(ctx, passwordResetUserKey(userID), tokenHash, redis.SetArgs{
+		TTL: duration,
+		Get: true,
+	}).Result()
+	if err != nil && err != redis.Nil {
+		return "", err
+	}
+	if oldHash != "" {
+		_ = rdb.Del(ctx, passwordResetKey(oldHash)).Err()
+	}
+
+	if err := rdb.Set(ctx, passwordResetKey(tokenHash), userID, duration).Err(); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// ConsumePasswordResetToken burns the token and returns its user id
+func ConsumePasswordResetToken(ctx context.Context, token string, rdb *redis.Client) (int, error) {
+	if rdb == nil {
+		return 0, errors.New("redis client required for reset token")
+	}
+
+	userID, err := rdb.GetDel(ctx, passwordResetKey(hashToken(token))).Int()
+	if err != nil {
+		if err == redis.Nil {
+			return 0, errors.New("reset token invalid or expired")
+		}
+		return 0, err
+	}
+
+	_ = rdb.Del(ctx, passwordResetUserKey(userID)).Err()
+
+	return userID, nil
+}
@@ -0,0 +1,34 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// AllowRate is a fixed window counter in redis, shared by every instance.
+// It returns false once key was hit more than limit times inside window.
+func AllowRate(
+	ctx context.Context,
+	rdb *redis.Client,
+	key string,
+	limit int64,
+	window time.Duration,
+) (bool, error) {
+	if rdb == nil {
+		return false, errors.New("redis client required for rate limit")
+	}
+
+	key = "ratelimit:" + key
+
+	pipe := rdb.TxPipeline()
+	count := pipe.Incr(ctx, key)
+	pipe.ExpireNX(ctx, key, window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+
+	return count.Val() <= limit, nil
+}
@@ -0,0 +1,57 @@
+package helper
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"auth/internal/model"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// security events go to the log and to a capped redis stream,
+// alerting or a siem can read the stream with XREAD
+const (
+	securityEventStream    = "security:events"
+	securityEventStreamLen = 10000
+)
+
+// EmitSecurityEvent never fails the request, a lost event is only logged
+func EmitSecurityEvent(ctx context.Context, event model.SecurityEvent, rdb *redis.Client) {
+	if event.At.IsZero() {
+		event.At = time.Now().UTC()
+	}
+	if event.IP == "" && event.UserAgent == "" {
+		info := sessionInfoFromContext(ctx)
+		event.IP, event.UserAgent = info.IP, info.UserAgent
+	}
+
+	log.Printf(
+		"security event %s: user=%d session=%s client=%s ip=%s",
+		event.Type, event.UserID, event.SessionID, event.ClientID, event.IP,
+	)
+
+	if rdb == nil {
+		return
+	}
+
+	err := rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: securityEventStream,
+		MaxLen: securityEventStreamLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			"type":       event.Type,
+			"user_id":    strconv.Itoa(event.UserID),
+			"session_id": event.SessionID,
+			"client_id":  event.ClientID,
+			"ip":         event.IP,
+			"user_agent": event.UserAgent,
+			"at":         event.At.Format(time.RFC3339),
+		},
+	}).Err()
+	if err != nil {
+		log.Printf("failed storing security event %s: %v", event.Type, err)
+	}
+}
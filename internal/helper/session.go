@@ -0,0 +1,367 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"auth/internal/model"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	// the session was signed out while its refresh token was being rotated
+	ErrSessionRevoked = errors.New("session has been signed out")
+	ErrSessionExpired = errors.New("session expired, please login again")
+	ErrSessionLimit   = errors.New("too many active sessions, sign out on another device first")
+)
+
+// a session is one sign in on one device, it keeps its id across refresh
+// token rotations and points at the refresh token currently alive
+func sessionKey(sid string) string {
+	return "session:" + sid
+}
+
+func sessionUserKey(userID int) string {
+	return "session:user:" + strconv.Itoa(userID)
+}
+
+// SessionInfo describes the device a sign in comes from
+type SessionInfo struct {
+	DeviceName string
+	UserAgent  string
+	IP         string
+}
+
+type sessionInfoKey struct{}
+
+func WithSessionInfo(ctx context.Context, info SessionInfo) context.Context {
+	return context.WithValue(ctx, sessionInfoKey{}, info)
+}
+
+func sessionInfoFromContext(ctx context.Context) SessionInfo {
+	info, _ := ctx.Value(sessionInfoKey{}).(SessionInfo)
+	return info
+}
+
+// SessionInfoFromRequest reads the device of a request, the name is what
+// the app sends in X-Device-Name, long values are cut
+func SessionInfoFromRequest(r *http.Request) SessionInfo {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	return SessionInfo{
+		DeviceName: truncate(r.Header.Get("X-Device-Name"), 64),
+		UserAgent:  truncate(r.UserAgent(), 256),
+		IP:         ip,
+	}
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "")
+}
+
+// stores a refresh token together with its session, a new session gets the
+// device info, a rotated one only moves to the new token. A rotation of a
+// session that was signed out in the meantime stores nothing. Returns the
+// status followed by the sessions evicted to make room.
+//
+// The keys of evicted sessions are built here from their ids, the script
+// needs every key on one node and does not run on redis cluster.
+var storeRefreshScript = redis.NewScript(`
+local fresh = ARGV[6] == "1"
+if not fresh and redis.call("EXISTS", KEYS[3]) == 0 then
+  return {0}
+end
+
+local result = {1}
+
+-- a new session over the cap is refused or makes room by signing out
+-- the oldest ones, counted and done here so parallel logins cannot slip past.
+-- The cap of a client only counts and evicts the sessions of that client.
+local max = tonumber(ARGV[14])
+if fresh and max > 0 then
+  local live = {}
+  for _, sid in ipairs(redis.call("SMEMBERS", KEYS[4])) do
+    local s = redis.call("HMGET", "session:" .. sid, "created_at", "jti", "client_id")
+    if not s[1] then
+      redis.call("SREM", KEYS[4], sid)
+    elseif ARGV[17] == "" or s[3] == ARGV[17] then
+      table.insert(live, {sid = sid, created = tonumber(s[1]), jti = s[2]})
+    end
+  end
+
+  if #live >= max then
+    if ARGV[15] ~= "1" then
+      return {-1}
+    end
+    table.sort(live, function(a, b) return a.created < b.created end)
+    for i = 1, #live - max + 1 do
+      local old = live[i]
+      if old.jti then
+        redis.call("DEL", "refresh:" .. old.jti)
+        redis.call("SREM", KEYS[2], old.jti)
+      end
+      redis.call("DEL", "session:" .. old.sid)
+      redis.call("SREM", KEYS[4], old.sid)
+      -- the access tokens of the session go with it
+      redis.call("SET", "access:revoked:session:" .. old.sid, "1", "EX", ARGV[16])
+      table.insert(result, old.sid)
+    end
+  end
+end
+
+local ttl = tonumber(ARGV[2])
+redis.call("SET", KEYS[1], ARGV[1], "EX", ttl)
+
+if fresh then
+  redis.call("HSET", KEYS[3],
+    "user_id", ARGV[7], "client_id", ARGV[8], "device_name", ARGV[9],
+    "user_agent", ARGV[10], "created_at", ARGV[5],
+    "expires_at", ARGV[12], "remember", ARGV[13])
+end
+redis.call("HSET", KEYS[3], "jti", ARGV[3], "last_used_at", ARGV[5])
+if ARGV[11] ~= "" then
+  redis.call("HSET", KEYS[3], "ip", ARGV[11])
+end
+redis.call("EXPIRE", KEYS[3], ttl)
+
+-- the indexes outlive their longest entry, a short client ttl never shortens them
+redis.call("SADD", KEYS[2], ARGV[3])
+redis.call("SADD", KEYS[4], ARGV[4])
+for _, key in ipairs({KEYS[2], KEYS[4]}) do
+  if redis.call("TTL", key) < ttl then
+    redis.call("EXPIRE", key, ttl)
+  end
+end
+return result
+`)
+
+func storeRefreshToken(
+	ctx context.Context,
+	rdb *redis.Client,
+	user model.User,
+	clientID string,
+	jti string,
+	tokenHash string,
+	family refreshFamily,
+	fresh bool,
+	limit sessionCap,
+	duration time.Duration,
+) error {
+	info := sessionInfoFromContext(ctx)
+	sid := family.SessionID
+
+	freshArg := "0"
+	if fresh {
+		freshArg = "1"
+	}
+	rememberArg := "0"
+	if family.Remember {
+		rememberArg = "1"
+	}
+	evictArg := "0"
+	if limit.Evict {
+		evictArg = "1"
+	}
+	// no access token of an evicted session lives longer than JWT_EXPIRED
+	accessTTL, err := AccessTokenExpiry()
+	if err != nil {
+		return err
+	}
+
+	res, err := storeRefreshScript.Run(
+		ctx,
+		rdb,
+		[]string{refreshKey(jti), refreshUserKey(user.ID), sessionKey(sid), sessionUserKey(user.ID)},
+		tokenHash,
+		int(duration.Seconds()),
+		jti,
+		sid,
+		time.Now().Unix(),
+		freshArg,
+		user.ID,
+		clientID,
+		info.DeviceName,
+		info.UserAgent,
+		info.IP,
+		family.ExpiresAt.Unix(),
+		rememberArg,
+		limit.Max,
+		evictArg,
+		int(accessTTL.Seconds()),
+		limit.ClientID,
+	).Slice()
+	if err != nil {
+		return err
+	}
+	if len(res) == 0 {
+		return errors.New("unexpected reply storing refresh token")
+	}
+	switch status, _ := res[0].(int64); status {
+	case 0:
+		return ErrSessionRevoked
+	case -1:
+		return ErrSessionLimit
+	}
+
+	for _, evicted := range res[1:] {
+		sid, _ := evicted.(string)
+		EmitSecurityEvent(ctx, model.SecurityEvent{
+			Type:      model.SecurityEventSessionEvicted,
+			UserID:    user.ID,
+			SessionID: sid,
+		}, rdb)
+	}
+	return nil
+}
+
+// signs one session out, its refresh token is marked used for the rest of
+// the session's life so a late refresh from the device is caught like any
+// other reuse. Like storeRefreshScript it builds keys and needs a single node.
+var revokeSessionScript = redis.NewScript(`
+local data = redis.call("HMGET", KEYS[1], "user_id", "jti", "expires_at")
+if not data[1] then
+  redis.call("SREM", KEYS[2], ARGV[2])
+  return 0
+end
+if data[1] ~= ARGV[1] then
+  return 0
+end
+
+local jti = data[2]
+if jti then
+  local ttl = redis.call("TTL", "refresh:" .. jti)
+  if data[3] then
+    ttl = tonumber(data[3]) - tonumber(ARGV[3])
+  end
+  redis.call("DEL", "refresh:" .. jti)
+  redis.call("SREM", KEYS[3], jti)
+  if ttl > 0 then
+    redis.call("SET", "refresh:used:" .. jti, ARGV[2], "EX", ttl)
+  end
+end
+redis.call("DEL", KEYS[1])
+redis.call("SREM", KEYS[2], ARGV[2])
+return 1
+`)
+
+// RevokeSession signs the user out of one session, the access tokens
+// of the session stop working with it
+func RevokeSession(ctx context.Context, userID int, sid string, rdb *redis.Client) error {
+	if rdb == nil {
+		return errors.New("redis client required")
+	}
+
+	accessTTL, err := AccessTokenExpiry()
+	if err != nil {
+		return err
+	}
+
+	res, err := revokeSessionScript.Run(
+		ctx,
+		rdb,
+		[]string{sessionKey(sid), sessionUserKey(userID), refreshUserKey(userID)},
+		userID,
+		sid,
+		time.Now().Unix(),
+	).Int()
+	if err != nil {
+		return err
+	}
+	if res == 0 {
+		return ErrSessionNotFound
+	}
+	return rdb.Set(ctx, accessRevokedSessionKey(sid), "1", accessTTL).Err()
+}
+
+// ListSessions returns the live sessions of a user, most recently used first.
+// Sessions that expired are dropped from the index on the way.
+func ListSessions(ctx context.Context, userID int, rdb *redis.Client) ([]model.Session, error) {
+	if rdb == nil {
+		return nil, errors.New("redis client required")
+	}
+
+	sids, err := rdb.SMembers(ctx, sessionUserKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	pipe := rdb.Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, len(sids))
+	for i, sid := range sids {
+		cmds[i] = pipe.HGetAll(ctx, sessionKey(sid))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	sessions := []model.Session{}
+	var expired []interface{}
+	for i, cmd := range cmds {
+		data, err := cmd.Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(data) == 0 {
+			expired = append(expired, sids[i])
+			continue
+		}
+		sessions = append(sessions, sessionFromHash(sids[i], data))
+	}
+
+	if len(expired) > 0 {
+		_ = rdb.SRem(ctx, sessionUserKey(userID), expired...).Err()
+	}
+
+	slices.SortFunc(sessions, func(a, b model.Session) int {
+		return b.LastUsedAt.Compare(a.LastUsedAt)
+	})
+
+	return sessions, nil
+}
+
+func sessionFromHash(sid string, data map[string]string) model.Session {
+	userID, _ := strconv.Atoi(data["user_id"])
+	createdAt, _ := strconv.ParseInt(data["created_at"], 10, 64)
+	lastUsedAt, _ := strconv.ParseInt(data["last_used_at"], 10, 64)
+	expiresAt, _ := strconv.ParseInt(data["expires_at"], 10, 64)
+
+	session := model.Session{
+		ID:         sid,
+		UserID:     userID,
+		ClientID:   data["client_id"],
+		DeviceName: data["device_name"],
+		UserAgent:  data["user_agent"],
+		IP:         data["ip"],
+		CreatedAt:  time.Unix(createdAt, 0).UTC(),
+		LastUsedAt: time.Unix(lastUsedAt, 0).UTC(),
+		RememberMe: data["remember"] == "1",
+	}
+	// sessions started before policies were tracked have no end stored
+	if expiresAt > 0 {
+		session.ExpiresAt = time.Unix(expiresAt, 0).UTC()
+	}
+	return session
+}
+
+// dropSession forgets the session of a refresh token that was revoked
+func dropSession(ctx context.Context, pipe redis.Pipeliner, userID int, sid string) {
+	if sid == "" {
+		return
+	}
+	pipe.Del(ctx, sessionKey(sid))
+	pipe.SRem(ctx, sessionUserKey(userID), sid)
+}
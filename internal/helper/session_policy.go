@@ -0,0 +1,108 @@
+package helper
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"auth/config"
+	"auth/internal/model"
+)
+
+var (
+	sessionPoliciesMu sync.RWMutex
+	sessionPolicies   *config.SessionPolicies
+)
+
+// UseSessionPolicies sets the session lifetimes, called once at startup
+func UseSessionPolicies(policies *config.SessionPolicies) {
+	sessionPoliciesMu.Lock()
+	defer sessionPoliciesMu.Unlock()
+	sessionPolicies = policies
+}
+
+func loadSessionPolicies() (*config.SessionPolicies, error) {
+	sessionPoliciesMu.RLock()
+	policies := sessionPolicies
+	sessionPoliciesMu.RUnlock()
+
+	if policies == nil {
+		return config.SessionPolicyConfig()
+	}
+	return policies, nil
+}
+
+func sessionPolicy(role model.Role, clientID string) (config.SessionPolicy, error) {
+	policies, err := loadSessionPolicies()
+	if err != nil {
+		return config.SessionPolicy{}, err
+	}
+	return policies.For(string(role), clientID), nil
+}
+
+// sessionLifetime is the idle and the absolute lifetime of a session,
+// the refresh_token_ttl of an oauth client replaces the idle one
+func sessionLifetime(
+	role model.Role,
+	clientID string,
+	remember bool,
+	ttl time.Duration,
+) (time.Duration, time.Duration, error) {
+	policy, err := sessionPolicy(role, clientID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	idle, absolute := policy.Lifetime(remember)
+	if ttl > 0 {
+		idle = ttl
+	}
+	return idle, absolute, nil
+}
+
+// sessionCap is the concurrent session limit of a user, Max 0 is no limit.
+// With ClientID set only the sessions through that client count.
+type sessionCap struct {
+	Max      int
+	Evict    bool
+	ClientID string
+}
+
+// sessionLimit takes the cap of the account over the one of the policy,
+// what happens over the cap always comes from the policy. A cap set on the
+// client entry is a cap of that client, the user's other sessions do not count.
+func sessionLimit(user model.User, clientID string) (sessionCap, error) {
+	policies, err := loadSessionPolicies()
+	if err != nil {
+		return sessionCap{}, err
+	}
+	policy := policies.For(string(user.Role), clientID)
+
+	limit := sessionCap{
+		Evict: policy.OnSessionLimit == config.SessionLimitEvictOldest,
+	}
+	if policy.MaxSessions != nil {
+		limit.Max = *policy.MaxSessions
+	}
+	if clientPolicy, ok := policies.Clients[clientID]; ok && clientID != "" && clientPolicy.MaxSessions != nil {
+		limit.ClientID = clientID
+	}
+	if user.MaxSessions != nil {
+		limit.Max = *user.MaxSessions
+		limit.ClientID = ""
+	}
+	return limit, nil
+}
+
+type rememberMeKey struct{}
+
+// WithRememberMe asks for the longer "remember me" lifetime of the policy
+// for a session started with the context
+func WithRememberMe(ctx context.Context, remember bool) context.Context {
+	return context.WithValue(ctx, rememberMeKey{}, remember)
+}
+
+func rememberMe(ctx context.Context) bool {
+	remember, _ := ctx.Value(rememberMeKey{}).(bool)
+	return remember
+}
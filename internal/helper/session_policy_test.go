@@ -0,0 +1,176 @@
+package helper
+
+import (
+	"context"
+	"testing"
+
+	"auth/config"
+	"auth/internal/model"
+)
+
+// useTestSessionPolicies evicts the oldest session over a cap of max
+func useTestSessionPolicies(t *testing.T, max int) {
+	t.Helper()
+
+	t.Setenv("JWT_REFRESH_EXPIRED", "")
+	// a missing policy file leaves the built in defaults
+	t.Setenv("SESSION_POLICY_FILE", "testdata/none.json")
+	policies, err := config.SessionPolicyConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	policies.Default.MaxSessions = &max
+	policies.Default.OnSessionLimit = config.SessionLimitEvictOldest
+
+	UseSessionPolicies(policies)
+	t.Cleanup(func() { UseSessionPolicies(nil) })
+}
+
+func TestSessionCapEvictsOldest(t *testing.T) {
+	useTestSecrets(t)
+	useTestSessionPolicies(t, 1)
+	ctx := context.Background()
+	_, rdb := newTestRedis(t)
+	UseAccessTokenDenylist(rdb)
+	t.Cleanup(func() { UseAccessTokenDenylist(nil) })
+
+	login := func() (string, string) {
+		refresh, err := CreateRefreshToken(ctx, testUser(), rdb)
+		if err != nil {
+			t.Fatalf("CreateRefreshToken: %v", err)
+		}
+		access, err := CreateAccessToken(WithRefreshSession(ctx, refresh), testUser())
+		if err != nil {
+			t.Fatalf("CreateAccessToken: %v", err)
+		}
+		return refresh, access
+	}
+
+	oldRefresh, oldAccess := login()
+	oldClaims, err := ValidateAccessToken(ctx, oldAccess)
+	if err != nil {
+		t.Fatalf("ValidateAccessToken: %v", err)
+	}
+	if oldClaims.SessionID == "" {
+		t.Fatal("access token does not name its session")
+	}
+
+	newRefresh, newAccess := login()
+
+	if _, err := InspectRefreshToken(ctx, oldRefresh, rdb); err == nil {
+		t.Fatal("the oldest session survived the cap")
+	}
+	if _, err := ValidateAccessToken(ctx, oldAccess); err == nil {
+		t.Fatal("the access token of an evicted session still works")
+	}
+	if _, err := InspectRefreshToken(ctx, newRefresh, rdb); err != nil {
+		t.Fatalf("new session: %v", err)
+	}
+	if _, err := ValidateAccessToken(ctx, newAccess); err != nil {
+		t.Fatalf("new access token: %v", err)
+	}
+
+	events, err := rdb.XRange(ctx, securityEventStream, "-", "+").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Values["type"] != model.SecurityEventSessionEvicted || events[0].Values["session_id"] != oldClaims.SessionID {
+		t.Fatalf("unexpected security events %+v", events)
+	}
+}
+
+func TestSessionCapRejects(t *testing.T) {
+	useTestSecrets(t)
+	useTestSessionPolicies(t, 1)
+	ctx := context.Background()
+	_, rdb := newTestRedis(t)
+
+	user := testUser()
+	reject := 2
+	user.MaxSessions = &reject
+	policies, _ := sessionPolicy(user.Role, "")
+	policies.OnSessionLimit = config.SessionLimitReject
+	UseSessionPolicies(&config.SessionPolicies{Default: policies})
+
+	for i := 0; i < 2; i++ {
+		if _, err := CreateRefreshToken(ctx, user, rdb); err != nil {
+			t.Fatalf("login %d: %v", i, err)
+		}
+	}
+	if _, err := CreateRefreshToken(ctx, user, rdb); err != ErrSessionLimit {
+		t.Fatalf("got %v, want ErrSessionLimit", err)
+	}
+
+	// an account without a cap of its own can be lifted to unlimited
+	unlimited := 0
+	user.MaxSessions = &unlimited
+	if _, err := CreateRefreshToken(ctx, user, rdb); err != nil {
+		t.Fatalf("uncapped login: %v", err)
+	}
+}
+
+// a client cap only counts the sessions of that client, a login through
+// one client never rejects or evicts the user's sessions on another
+func TestSessionCapPerClient(t *testing.T) {
+	useTestSecrets(t)
+	useTestSessionPolicies(t, 0)
+	ctx := context.Background()
+	_, rdb := newTestRedis(t)
+
+	one, two := 1, 2
+	policies, err := loadSessionPolicies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	policies.Clients = map[string]config.SessionPolicy{
+		"tv":  {MaxSessions: &one, OnSessionLimit: config.SessionLimitEvictOldest},
+		"cli": {MaxSessions: &two, OnSessionLimit: config.SessionLimitReject},
+	}
+
+	login := func(clientID string) (string, error) {
+		return CreateClientRefreshToken(ctx, testUser(), clientID, "openid", 0, rdb)
+	}
+
+	firstParty, err := CreateRefreshToken(ctx, testUser(), rdb)
+	if err != nil {
+		t.Fatalf("first party login: %v", err)
+	}
+	cli := []string{}
+	for i := 0; i < 2; i++ {
+		token, err := login("cli")
+		if err != nil {
+			t.Fatalf("cli login %d: %v", i, err)
+		}
+		cli = append(cli, token)
+	}
+	firstTV, err := login("tv")
+	if err != nil {
+		t.Fatalf("tv login: %v", err)
+	}
+
+	// over the tv cap, only the older tv session makes room
+	secondTV, err := login("tv")
+	if err != nil {
+		t.Fatalf("second tv login: %v", err)
+	}
+	if _, err := InspectRefreshToken(ctx, firstTV, rdb); err == nil {
+		t.Fatal("the older tv session survived the tv cap")
+	}
+	for _, token := range append([]string{firstParty, secondTV}, cli...) {
+		if _, err := InspectRefreshToken(ctx, token, rdb); err != nil {
+			t.Fatalf("a session outside the tv cap was signed out: %v", err)
+		}
+	}
+
+	// the cli cap is full on its own, the tv and first party sessions do not count
+	if _, err := login("cli"); err != ErrSessionLimit {
+		t.Fatalf("third cli login: got %v, want ErrSessionLimit", err)
+	}
+
+	// a client without a cap of its own falls back to the default, no cap here
+	for i := 0; i < 3; i++ {
+		if _, err := login("web"); err != nil {
+			t.Fatalf("web login %d: %v", i, err)
+		}
+	}
+}
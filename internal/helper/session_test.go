@@ -0,0 +1,141 @@
+package helper
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRevokeSessionMarksUsedForFamilyLifetime(t *testing.T) {
+	useTestSecrets(t)
+	ctx := context.Background()
+	mr, rdb := newTestRedis(t)
+
+	refresh, err := CreateRefreshToken(ctx, testUser(), rdb)
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	claims, err := parseRefreshToken(refresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RevokeSession(ctx, 42, claims.SessionID, rdb); err != nil {
+		t.Fatalf("RevokeSession: %v", err)
+	}
+	if err := RevokeSession(ctx, 42, claims.SessionID, rdb); err != ErrSessionNotFound {
+		t.Fatalf("got %v, want ErrSessionNotFound", err)
+	}
+
+	want := time.Until(claims.SessionExpiresAt.Time)
+	if ttl := mr.TTL(refreshUsedKey(claims.ID)); ttl < want-time.Minute {
+		t.Fatalf("used marker lives %s, want about %s", ttl, want)
+	}
+
+	mr.FastForward(time.Hour)
+	if _, err := ValidateRefreshToken(ctx, refresh, rdb); err == nil {
+		t.Fatal("refresh token of a signed out session was accepted")
+	}
+}
+
+func TestRevokeOtherRefreshTokensKeepsCurrent(t *testing.T) {
+	useTestSecrets(t)
+	ctx := context.Background()
+	mr, rdb := newTestRedis(t)
+	UseAccessTokenDenylist(rdb)
+	t.Cleanup(func() { UseAccessTokenDenylist(nil) })
+
+	// the current session is DPoP bound, the password change request
+	// carries no proof for its refresh cookie
+	bound := WithDPoPProof(ctx, &DPoPProof{JKT: "current-key"})
+	current, err := CreateRefreshToken(bound, testUser(), rdb)
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	currentAccess, err := CreateAccessToken(WithRefreshSession(bound, current), testUser())
+	if err != nil {
+		t.Fatalf("CreateAccessToken: %v", err)
+	}
+	other, err := CreateRefreshToken(ctx, testUser(), rdb)
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	otherAccess, err := CreateAccessToken(WithRefreshSession(ctx, other), testUser())
+	if err != nil {
+		t.Fatalf("CreateAccessToken: %v", err)
+	}
+	otherClaims, _ := parseRefreshToken(other)
+
+	if _, err := ValidateRefreshToken(ctx, current, rdb); err == nil {
+		t.Fatal("DPoP bound refresh token was accepted without a proof")
+	}
+	currentClaims, err := InspectRefreshToken(ctx, current, rdb)
+	if err != nil {
+		t.Fatalf("InspectRefreshToken: %v", err)
+	}
+
+	if err := RevokeOtherRefreshTokens(ctx, 42, currentClaims.ID, rdb); err != nil {
+		t.Fatalf("RevokeOtherRefreshTokens: %v", err)
+	}
+
+	if _, err := InspectRefreshToken(ctx, current, rdb); err != nil {
+		t.Fatalf("current session was signed out: %v", err)
+	}
+	if _, err := ValidateAccessToken(bound, currentAccess); err != nil {
+		t.Fatalf("current access token: %v", err)
+	}
+	if _, err := InspectRefreshToken(ctx, other, rdb); err == nil {
+		t.Fatal("other session survived")
+	}
+	if _, err := ValidateAccessToken(ctx, otherAccess); err == nil {
+		t.Fatal("the access token of a signed out session still works")
+	}
+	want := time.Until(otherClaims.SessionExpiresAt.Time)
+	if ttl := mr.TTL(refreshUsedKey(otherClaims.ID)); ttl < want-time.Minute {
+		t.Fatalf("used marker lives %s, want about %s", ttl, want)
+	}
+
+	sessions, err := ListSessions(ctx, 42, rdb)
+	if err != nil {
+		t.Fatalf("ListSessions: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != currentClaims.SessionID {
+		t.Fatalf("sessions left %+v, want only the current one", sessions)
+	}
+}
+
+func TestRevokeSessionEndsItsTokens(t *testing.T) {
+	useTestSecrets(t)
+	ctx := context.Background()
+	_, rdb := newTestRedis(t)
+	UseAccessTokenDenylist(rdb)
+	t.Cleanup(func() { UseAccessTokenDenylist(nil) })
+
+	refresh, err := CreateRefreshToken(ctx, testUser(), rdb)
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	access, err := CreateAccessToken(WithRefreshSession(ctx, refresh), testUser())
+	if err != nil {
+		t.Fatalf("CreateAccessToken: %v", err)
+	}
+	other, err := CreateRefreshToken(ctx, testUser(), rdb)
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	claims, _ := parseRefreshToken(refresh)
+
+	if err := RevokeSession(ctx, 42, claims.SessionID, rdb); err != nil {
+		t.Fatalf("RevokeSession: %v", err)
+	}
+
+	if _, err := ValidateRefreshToken(ctx, refresh, rdb); err == nil {
+		t.Fatal("refresh token of a signed out session was accepted")
+	}
+	if _, err := ValidateAccessToken(ctx, access); err == nil {
+		t.Fatal("access token of a signed out session was accepted")
+	}
+	if _, err := ValidateRefreshToken(ctx, other, rdb); err != nil {
+		t.Fatalf("other session: %v", err)
+	}
+}
@@ -0,0 +1,375 @@
+package helper
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgEdDSA = "EdDSA"
+)
+
+// SigningKey is one access token key. Asymmetric keys use Private and Public,
+// HS256 keys from the key store use Secret and are never published.
+type SigningKey struct {
+	KID     string
+	Alg     string
+	Private crypto.Signer
+	Public  crypto.PublicKey
+	Secret  []byte
+}
+
+func (k *SigningKey) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Alg)
+}
+
+func (k *SigningKey) signKey() any {
+	if k.Alg == AlgHS256 {
+		return k.Secret
+	}
+	return k.Private
+}
+
+func (k *SigningKey) verifyKey() any {
+	if k.Alg == AlgHS256 {
+		return k.Secret
+	}
+	return k.Public
+}
+
+// KeySet holds the key that signs new tokens and every key tokens may still be verified with
+type KeySet struct {
+	mu     sync.RWMutex
+	active *SigningKey
+	keys   map[string]*SigningKey
+
+	// loader re-reads the key store, another instance may have rotated
+	loader   func() error
+	loadedAt time.Time
+}
+
+func (s *KeySet) Active() *SigningKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.active
+}
+
+func (s *KeySet) Get(kid string) (*SigningKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+// Public lists the asymmetric keys, shared secrets stay private
+func (s *KeySet) Public() []SigningKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]SigningKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		if key.Alg == AlgHS256 {
+			continue
+		}
+		keys = append(keys, SigningKey{KID: key.KID, Alg: key.Alg, Public: key.Public})
+	}
+	return keys
+}
+
+func (s *KeySet) SetLoader(loader func() error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loader = loader
+}
+
+// getOrReload retries an unknown kid after reloading, at most every few seconds
+// so a flood of garbage kids cannot hammer the database
+func (s *KeySet) getOrReload(kid string) (*SigningKey, bool) {
+	if key, ok := s.Get(kid); ok {
+		return key, true
+	}
+
+	s.mu.Lock()
+	loader := s.loader
+	due := loader != nil && time.Since(s.loadedAt) > keyReloadBackoff
+	if due {
+		s.loadedAt = time.Now()
+	}
+	s.mu.Unlock()
+
+	if !due {
+		return nil, false
+	}
+	if err := loader(); err != nil {
+		return nil, false
+	}
+	return s.Get(kid)
+}
+
+// Replace swaps the whole set at once, the active key is always part of it
+func (s *KeySet) Replace(active *SigningKey, verifyOnly ...*SigningKey) {
+	keys := make(map[string]*SigningKey, len(verifyOnly)+1)
+	for _, key := range verifyOnly {
+		keys[key.KID] = key
+	}
+	if active != nil {
+		keys[active.KID] = active
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active = active
+	s.keys = keys
+	s.loadedAt = time.Now()
+}
+
+const keyReloadBackoff = 10 * time.Second
+
+var (
+	signingKeys     = &KeySet{}
+	signingKeysOnce sync.Once
+	signingKeysErr  error
+)
+
+// SigningAlg is the access token algorithm, HS256 keeps the shared JWT_SECRET
+func SigningAlg() string {
+	alg := os.Getenv("JWT_SIGNING_ALG")
+	if alg == "" {
+		return AlgHS256
+	}
+	return alg
+}
+
+// KeyStoreEnabled moves the keys from env into the signing_keys table,
+// the key set is then filled and rotated by the signing key service
+func KeyStoreEnabled() bool {
+	return os.Getenv("JWT_KEY_STORE") == "database"
+}
+
+// SigningKeys loads the configured key once, call it at startup to fail fast
+func SigningKeys() (*KeySet, error) {
+	signingKeysOnce.Do(func() {
+		alg := SigningAlg()
+		if alg == AlgHS256 || KeyStoreEnabled() {
+			return
+		}
+
+		key, err := loadSigningKey(alg)
+		if err != nil {
+			signingKeysErr = err
+			return
+		}
+		signingKeys.Replace(key)
+	})
+
+	return signingKeys, signingKeysErr
+}
+
+func loadSigningKey(alg string) (*SigningKey, error) {
+	raw := []byte(os.Getenv("JWT_SIGNING_KEY"))
+	if path := os.Getenv("JWT_SIGNING_KEY_FILE"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading signing key: %w", err)
+		}
+		raw = b
+	}
+	if len(raw) == 0 {
+		return nil, errors.New("JWT_SIGNING_KEY or JWT_SIGNING_KEY_FILE missing")
+	}
+
+	signer, err := ParsePrivateKey(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSigningKey(alg, os.Getenv("JWT_SIGNING_KID"), signer)
+}
+
+// ParsePrivateKey reads a PEM encoded PKCS8, PKCS1 or SEC1 private key
+func ParsePrivateKey(raw []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("signing key is not PEM encoded")
+	}
+
+	var (
+		key any
+		err error
+	)
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing signing key: %w", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("signing key type is not supported")
+	}
+	return signer, nil
+}
+
+// GenerateSigningKey creates a fresh key for the key store
+func GenerateSigningKey(alg string) (*SigningKey, error) {
+	var (
+		signer crypto.Signer
+		err    error
+	)
+	switch alg {
+	case AlgHS256:
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		kid := make([]byte, 16)
+		if _, err := rand.Read(kid); err != nil {
+			return nil, err
+		}
+		return &SigningKey{KID: b64(kid), Alg: alg, Secret: secret}, nil
+	case AlgRS256:
+		signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgES256:
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgEdDSA:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSigningKey(alg, "", signer)
+}
+
+// MarshalSigningKey returns the raw secret or the PKCS8 DER private key
+func MarshalSigningKey(key *SigningKey) ([]byte, error) {
+	if key.Alg == AlgHS256 {
+		return key.Secret, nil
+	}
+	return x509.MarshalPKCS8PrivateKey(key.Private)
+}
+
+func UnmarshalSigningKey(alg string, kid string, raw []byte) (*SigningKey, error) {
+	if alg == AlgHS256 {
+		if len(raw) < 32 {
+			return nil, errors.New("HS256 secret is too short")
+		}
+		return &SigningKey{KID: kid, Alg: alg, Secret: raw}, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing signing key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("signing key type is not supported")
+	}
+
+	return NewSigningKey(alg, kid, signer)
+}
+
+// NewSigningKey checks the key fits the algorithm, an empty kid
+// becomes the RFC 7638 thumbprint of the public key
+func NewSigningKey(alg string, kid string, signer crypto.Signer) (*SigningKey, error) {
+	public := signer.Public()
+
+	switch alg {
+	case AlgRS256:
+		pub, ok := public.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%s needs an RSA key", alg)
+		}
+		if pub.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("%s needs an RSA key of at least 2048 bits", alg)
+		}
+	case AlgES256:
+		pub, ok := public.(*ecdsa.PublicKey)
+		if !ok || pub.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("%s needs a P-256 key", alg)
+		}
+	case AlgEdDSA:
+		if _, ok := public.(ed25519.PublicKey); !ok {
+			return nil, fmt.Errorf("%s needs an Ed25519 key", alg)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+
+	key := &SigningKey{
+		KID:     kid,
+		Alg:     alg,
+		Private: signer,
+		Public:  public,
+	}
+
+	if key.KID == "" {
+		thumbprint, err := jwkThumbprint(public)
+		if err != nil {
+			return nil, err
+		}
+		key.KID = thumbprint
+	}
+
+	return key, nil
+}
+
+// accessKeyFunc picks the verification key by kid. Tokens without a kid are
+// the ones signed with JWT_SECRET, they only pass while HS256 is configured
+// without a key store, otherwise anyone holding the old secret could mint them.
+func accessKeyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if strings.TrimSpace(kid) == "" {
+		if SigningAlg() != AlgHS256 || KeyStoreEnabled() {
+			return nil, errors.New("token has no kid")
+		}
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		secret := os.Getenv("JWT_SECRET")
+		if secret == "" {
+			return nil, errors.New("JWT_SECRET missing")
+		}
+		return []byte(secret), nil
+	}
+
+	keys, err := SigningKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := keys.getOrReload(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.Alg {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	return key.verifyKey(), nil
+}
@@ -0,0 +1,91 @@
+package helper
+
+import (
+	"context"
+	"testing"
+)
+
+// useTestKeyStore switches to the database key store with the given keys
+// in memory, the set is emptied again when the test ends
+func useTestKeyStore(t *testing.T, active *SigningKey, verifyOnly ...*SigningKey) {
+	t.Helper()
+
+	useTestSecrets(t)
+	t.Setenv("JWT_KEY_STORE", "database")
+	signingKeys.Replace(active, verifyOnly...)
+	t.Cleanup(func() { signingKeys.Replace(nil) })
+}
+
+func generateTestKey(t *testing.T, alg string) *SigningKey {
+	t.Helper()
+
+	key, err := GenerateSigningKey(alg)
+	if err != nil {
+		t.Fatalf("GenerateSigningKey: %v", err)
+	}
+	return key
+}
+
+func TestKeyStoreValidatesVerifyOnlyKeys(t *testing.T) {
+	ctx := context.Background()
+	old := generateTestKey(t, AlgES256)
+	useTestKeyStore(t, old)
+
+	token, err := CreateAccessToken(ctx, testUser())
+	if err != nil {
+		t.Fatalf("CreateAccessToken: %v", err)
+	}
+
+	// rotated, the old key only verifies now
+	signingKeys.Replace(generateTestKey(t, AlgES256), old)
+	if _, err := ValidateAccessToken(ctx, token); err != nil {
+		t.Fatalf("token of a verify only key was refused: %v", err)
+	}
+
+	// retired
+	signingKeys.Replace(generateTestKey(t, AlgES256))
+	if _, err := ValidateAccessToken(ctx, token); err == nil {
+		t.Fatal("token of a retired key was accepted")
+	}
+}
+
+// with a key store the old JWT_SECRET must not mint tokens anymore
+func TestKeyStoreRefusesTokensWithoutKid(t *testing.T) {
+	ctx := context.Background()
+	useTestSecrets(t)
+
+	legacy, err := CreateAccessToken(ctx, testUser())
+	if err != nil {
+		t.Fatalf("CreateAccessToken: %v", err)
+	}
+	if _, err := ValidateAccessToken(ctx, legacy); err != nil {
+		t.Fatalf("JWT_SECRET token refused without a key store: %v", err)
+	}
+
+	useTestKeyStore(t, generateTestKey(t, AlgHS256))
+	if _, err := ValidateAccessToken(ctx, legacy); err == nil {
+		t.Fatal("token without kid was accepted with the key store enabled")
+	}
+
+	signingKeys.Replace(nil)
+	if _, err := CreateAccessToken(ctx, testUser()); err == nil {
+		t.Fatal("signed with JWT_SECRET while the key store has no active key")
+	}
+}
+
+func TestKeyStoreRefusesUnknownKid(t *testing.T) {
+	ctx := context.Background()
+	useTestKeyStore(t, generateTestKey(t, AlgHS256))
+
+	token, err := CreateAccessToken(ctx, testUser())
+	if err != nil {
+		t.Fatalf("CreateAccessToken: %v", err)
+	}
+
+	// the key that signed it is not in the store
+	other := generateTestKey(t, AlgHS256)
+	signingKeys.Replace(other)
+	if _, err := ValidateAccessToken(ctx, token); err == nil {
+		t.Fatal("token with an unknown kid was accepted")
+	}
+}
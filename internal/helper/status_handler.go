@@ -43,6 +43,14 @@ func RespondSuccess(
 	})
 }
 
+// RespondJSON writes v as is, for standard documents that must not be wrapped
+func RespondJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(v)
+}
+
 func (e *AppError) Error() string {
 	return e.Message
 }
@@ -61,7 +69,11 @@ func RespondError(w http.ResponseWriter, status int, err error) {
 		return
 	}
 
-	w.WriteHeader(http.StatusInternalServerError)
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+
+	w.WriteHeader(status)
 	_ = json.NewEncoder(w).Encode(ErrorResponse{
 		Status:  status,
 		Message: "error",
@@ -0,0 +1,67 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"auth/internal/model"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// every user token carries the generation of the user it was issued in,
+// logging out everywhere moves the generation on and older tokens stop working
+
+var ErrTokenGenerationRevoked = errors.New("token was revoked, all sessions of the user were signed out")
+
+func tokenGenerationKey(userID int) string {
+	return "user:gen:" + strconv.Itoa(userID)
+}
+
+// tokenGeneration is 0 until the user logs out everywhere for the first time
+func tokenGeneration(ctx context.Context, userID int, rdb *redis.Client) (int64, error) {
+	if rdb == nil || userID == 0 {
+		return 0, nil
+	}
+
+	gen, err := rdb.Get(ctx, tokenGenerationKey(userID)).Int64()
+	if err != nil {
+		if err == redis.Nil {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return gen, nil
+}
+
+// checkTokenGeneration fails closed like the access denylist,
+// client tokens have no user and no generation
+func checkTokenGeneration(ctx context.Context, claims *model.ClaimsModel, rdb *redis.Client) error {
+	if claims.IsMachine() || claims.UserID == 0 {
+		return nil
+	}
+
+	gen, err := tokenGeneration(ctx, claims.UserID, rdb)
+	if err != nil {
+		return err
+	}
+	if claims.Generation < gen {
+		return ErrTokenGenerationRevoked
+	}
+	return nil
+}
+
+// RevokeUserTokens signs the user out everywhere, access tokens included.
+// The refresh tokens and sessions are removed too so they drop off the lists.
+func RevokeUserTokens(ctx context.Context, userID int, rdb *redis.Client) error {
+	if rdb == nil {
+		return errors.New("redis client required")
+	}
+
+	if err := rdb.Incr(ctx, tokenGenerationKey(userID)).Err(); err != nil {
+		return err
+	}
+
+	return RevokeAllRefreshTokens(ctx, userID, rdb)
+}
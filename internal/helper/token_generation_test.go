@@ -0,0 +1,44 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRevokeUserTokensEndsIssuedTokens(t *testing.T) {
+	useTestSecrets(t)
+	ctx := context.Background()
+	_, rdb := newTestRedis(t)
+	UseAccessTokenDenylist(rdb)
+	t.Cleanup(func() { UseAccessTokenDenylist(nil) })
+
+	refresh, err := CreateRefreshToken(ctx, testUser(), rdb)
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	// an access token outside any session is only caught by the generation
+	access, err := CreateAccessToken(ctx, testUser())
+	if err != nil {
+		t.Fatalf("CreateAccessToken: %v", err)
+	}
+
+	if err := RevokeUserTokens(ctx, 42, rdb); err != nil {
+		t.Fatalf("RevokeUserTokens: %v", err)
+	}
+
+	if _, err := ValidateAccessToken(ctx, access); !errors.Is(err, ErrTokenGenerationRevoked) {
+		t.Fatalf("access token from before the bump: got %v, want ErrTokenGenerationRevoked", err)
+	}
+	if _, err := ValidateRefreshToken(ctx, refresh, rdb); err == nil {
+		t.Fatal("refresh token from before the bump was accepted")
+	}
+
+	fresh, err := CreateAccessToken(ctx, testUser())
+	if err != nil {
+		t.Fatalf("CreateAccessToken: %v", err)
+	}
+	if _, err := ValidateAccessToken(ctx, fresh); err != nil {
+		t.Fatalf("access token issued after the bump: %v", err)
+	}
+}
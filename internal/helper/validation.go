@@ -1,5 +1,7 @@
 package helper
 
+import "net/mail"
+
 func IsValidName(s string) bool {
 	if s == "" {
 		return false
@@ -12,3 +14,17 @@ func IsValidName(s string) bool {
 	}
 	return true
 }
+
+func IsValidEmail(s string) bool {
+	if s == "" || len(s) > 256 {
+		return false
+	}
+
+	addr, err := mail.ParseAddress(s)
+	if err != nil {
+		return false
+	}
+
+	// reject "Name <addr>" forms, only the bare address is accepted
+	return addr.Address == s
+}
@@ -0,0 +1,164 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"auth/internal/model"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+func emailVerifyKey(jti string) string {
+	return "email_verify:" + jti
+}
+
+func emailVerifyUserKey(userID int) string {
+	return "email_verify:user:" + strconv.Itoa(userID)
+}
+
+// verification tokens are typed so they are never taken for an access token,
+// the secret falls back to JWT_SECRET
+const emailVerificationTyp = "email-verify+jwt"
+
+func emailVerificationSecret() (string, error) {
+	secret := os.Getenv("EMAIL_VERIFICATION_SECRET")
+	if secret == "" {
+		secret = os.Getenv("JWT_SECRET")
+	}
+	if secret == "" {
+		return "", errors.New("EMAIL_VERIFICATION_SECRET missing")
+	}
+	return secret, nil
+}
+
+func EmailVerificationExpiry() (time.Duration, error) {
+	expiryStr := os.Getenv("EMAIL_VERIFICATION_EXPIRED")
+	if expiryStr == "" {
+		expiryStr = "24h"
+	}
+	return ParseExpiry(expiryStr)
+}
+
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// CreateEmailVerificationToken signs a token for the user's current email.
+// Only the latest token of a user is kept in redis, issuing a new one voids the old.
+func CreateEmailVerificationToken(
+	ctx context.Context,
+	user model.User,
+	rdb *redis.Client,
+) (string, error) {
+	if rdb == nil {
+		return "", errors.New("redis client required for verification token")
+	}
+	if user.Email == nil || *user.Email == "" {
+		return "", errors.New("user has no email")
+	}
+
+	secret, err := emailVerificationSecret()
+	if err != nil {
+		return "", err
+	}
+
+	duration, err := EmailVerificationExpiry()
+	if err != nil {
+		return "", err
+	}
+
+	jti := uuid.NewString()
+	claims := model.EmailVerificationClaims{
+		UserID: user.ID,
+		Email:  NormalizeEmail(*user.Email),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "auth-service",
+			ID:        jti,
+			Subject:   strconv.Itoa(user.ID),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(duration)),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["typ"] = emailVerificationTyp
+	tokenString, err := token.SignedString([]byte(secret))
+	if err != nil {
+		return "", err
+	}
+
+	oldJTI, err := rdb.SetArgs(ctx, emailVerifyUserKey(user.ID), jti, redis.SetArgs{
+		TTL: duration,
+		Get: true,
+	}).Result()
+	if err != nil && err != redis.Nil {
+		return "", err
+	}
+	if oldJTI != "" {
+		_ = rdb.Del(ctx, emailVerifyKey(oldJTI)).Err()
+	}
+
+	if err := rdb.Set(ctx, emailVerifyKey(jti), hashToken(tokenString), duration).Err(); err != nil {
+		return "", err
+	}
+
+	return tokenString, nil
+}
+
+// ConsumeEmailVerificationToken checks the signature and burns the token,
+// a second call with the same token always fails
+func ConsumeEmailVerificationToken(
+	ctx context.Context,
+	tokenString string,
+	rdb *redis.Client,
+) (*model.EmailVerificationClaims, error) {
+	secret, err := emailVerificationSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := jwt.ParseWithClaims(
+		tokenString,
+		&model.EmailVerificationClaims{},
+		func(token *jwt.Token) (interface{}, error) {
+			if typ, _ := token.Header["typ"].(string); typ != emailVerificationTyp {
+				return nil, errors.New("token is not a verification token")
+			}
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return []byte(secret), nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*model.EmailVerificationClaims)
+	if !ok || !token.Valid || claims.ID == "" {
+		return nil, errors.New("invalid verification token")
+	}
+
+	stored, err := rdb.GetDel(ctx, emailVerifyKey(claims.ID)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, errors.New("verification token already used or replaced")
+		}
+		return nil, err
+	}
+	if stored != hashToken(tokenString) {
+		return nil, errors.New("invalid verification token")
+	}
+
+	_ = rdb.Del(ctx, emailVerifyUserKey(claims.UserID)).Err()
+
+	return claims, nil
+}
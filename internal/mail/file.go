@@ -0,0 +1,35 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FileMailer is for development, every mail becomes an .eml file in dir
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+func NewFileMailer(dir string, from string) *FileMailer {
+	return &FileMailer{dir: dir, from: from}
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	raw, err := buildMessage(m.from, msg)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return fmt.Errorf("failed creating mail dir: %w", err)
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102T150405"), uuid.NewString())
+	return os.WriteFile(filepath.Join(m.dir, name), raw, 0o644)
+}
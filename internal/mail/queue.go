@@ -0,0 +1,193 @@
+package mail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	queueKey      = "mail:queue"
+	processingKey = "mail:processing"
+	leaseKey      = "mail:leases"
+	retryKey      = "mail:retry"
+	deadKey       = "mail:dead"
+
+	maxAttempts = 5
+	baseBackoff = 30 * time.Second
+	// a job taken by a worker that did not finish it within the lease
+	// goes back to the queue, the worker most likely crashed
+	leaseTimeout = 2 * time.Minute
+)
+
+// the id keeps two jobs with the same message apart in the processing list
+type job struct {
+	ID       string  `json:"id"`
+	Message  Message `json:"message"`
+	Attempts int     `json:"attempts"`
+}
+
+// Queue hands mails to a background worker through redis,
+// so a slow or failing mail server never fails the http request
+type Queue struct {
+	rdb    *redis.Client
+	mailer Mailer
+}
+
+func NewQueue(rdb *redis.Client, mailer Mailer) *Queue {
+	return &Queue{rdb: rdb, mailer: mailer}
+}
+
+// moves retries that are due back to the queue, atomic so that
+// several instances never pick up the same retry twice
+var promoteScript = redis.NewScript(`
+local due = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, 100)
+for _, raw in ipairs(due) do
+  redis.call("ZREM", KEYS[1], raw)
+  redis.call("LPUSH", KEYS[2], raw)
+end
+return #due
+`)
+
+// puts jobs whose lease ran out back at the head of the queue. A job in the
+// processing list without a lease was taken just now or by a worker that died
+// right after, it gets a lease and is looked at again the next time.
+var reclaimScript = redis.NewScript(`
+local moved = 0
+for _, raw in ipairs(redis.call("LRANGE", KEYS[1], 0, -1)) do
+  local deadline = redis.call("ZSCORE", KEYS[2], raw)
+  if not deadline then
+    redis.call("ZADD", KEYS[2], ARGV[2], raw)
+  elseif tonumber(deadline) <= tonumber(ARGV[1]) then
+    redis.call("LREM", KEYS[1], 1, raw)
+    redis.call("ZREM", KEYS[2], raw)
+    redis.call("RPUSH", KEYS[3], raw)
+    moved = moved + 1
+  end
+end
+return moved
+`)
+
+func (q *Queue) Enqueue(ctx context.Context, msg Message) error {
+	raw, err := json.Marshal(job{ID: uuid.NewString(), Message: msg})
+	if err != nil {
+		return err
+	}
+	return q.rdb.LPush(ctx, queueKey, raw).Err()
+}
+
+// SendTemplate renders the template in the request locale and enqueues it
+func (q *Queue) SendTemplate(ctx context.Context, name string, to string, data any) error {
+	msg, err := Render(ctx, name, to, data)
+	if err != nil {
+		return err
+	}
+	return q.Enqueue(ctx, msg)
+}
+
+// Run works the queue until ctx is done. A job is moved to the processing
+// list while it is sent and only removed once it is done, so a worker that
+// dies halfway loses nothing.
+func (q *Queue) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		q.maintain(ctx)
+		q.take(ctx, 5*time.Second)
+	}
+}
+
+// take waits up to timeout for the next job and works it
+func (q *Queue) take(ctx context.Context, timeout time.Duration) {
+	raw, err := q.rdb.BLMove(ctx, queueKey, processingKey, "RIGHT", "LEFT", timeout).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) || ctx.Err() != nil {
+			return
+		}
+		log.Printf("mail queue: failed reading queue: %v", err)
+		time.Sleep(time.Second)
+		return
+	}
+
+	q.work(ctx, raw)
+}
+
+// maintain moves due retries and abandoned jobs back to the queue
+func (q *Queue) maintain(ctx context.Context) {
+	now := time.Now()
+	nowArg := strconv.FormatInt(now.Unix(), 10)
+
+	if err := promoteScript.Run(ctx, q.rdb, []string{retryKey, queueKey}, nowArg).Err(); err != nil && ctx.Err() == nil {
+		log.Printf("mail queue: failed promoting retries: %v", err)
+	}
+
+	leaseArg := strconv.FormatInt(now.Add(leaseTimeout).Unix(), 10)
+	if err := reclaimScript.Run(ctx, q.rdb, []string{processingKey, leaseKey, queueKey}, nowArg, leaseArg).Err(); err != nil && ctx.Err() == nil {
+		log.Printf("mail queue: failed reclaiming jobs: %v", err)
+	}
+}
+
+// work leases the job, sends it and acknowledges it
+func (q *Queue) work(ctx context.Context, raw string) {
+	// a job that could not be settled stays in the processing list,
+	// the lease running out brings it back
+	lease := redis.Z{Score: float64(time.Now().Add(leaseTimeout).Unix()), Member: raw}
+	if err := q.rdb.ZAdd(ctx, leaseKey, lease).Err(); err != nil {
+		log.Printf("mail queue: failed leasing job: %v", err)
+	}
+
+	if !q.process(ctx, raw) {
+		return
+	}
+
+	ackCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+
+	pipe := q.rdb.TxPipeline()
+	pipe.LRem(ackCtx, processingKey, 1, raw)
+	pipe.ZRem(ackCtx, leaseKey, raw)
+	if _, err := pipe.Exec(ackCtx); err != nil {
+		log.Printf("mail queue: failed acknowledging job: %v", err)
+	}
+}
+
+// process sends the job or schedules its retry, false means neither
+// happened and the job has to stay in the processing list
+func (q *Queue) process(ctx context.Context, raw string) bool {
+	j := job{}
+	if err := json.Unmarshal([]byte(raw), &j); err != nil {
+		log.Printf("mail queue: dropping malformed job: %v", err)
+		return true
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	err := q.mailer.Send(sendCtx, j.Message)
+	cancel()
+	if err == nil {
+		return true
+	}
+
+	j.Attempts++
+	next, marshalErr := json.Marshal(j)
+	if marshalErr != nil {
+		log.Printf("mail queue: dropping job: %v", marshalErr)
+		return true
+	}
+
+	if j.Attempts >= maxAttempts {
+		log.Printf("mail queue: giving up on %q after %d attempts: %v", j.Message.Subject, j.Attempts, err)
+		return q.rdb.LPush(ctx, deadKey, next).Err() == nil
+	}
+
+	// 30s, 1m, 2m, 4m ...
+	retryAt := time.Now().Add(baseBackoff << (j.Attempts - 1))
+	log.Printf("mail queue: send failed, retry %d at %s: %v", j.Attempts, retryAt.Format(time.RFC3339), err)
+	return q.rdb.ZAdd(ctx, retryKey, redis.Z{
+		Score:  float64(retryAt.Unix()),
+		Member: next,
+	}).Err() == nil
+}
@@ -0,0 +1,131 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestQueue(t *testing.T, mailer Mailer) (*Queue, *redis.Client) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	return NewQueue(rdb, mailer), rdb
+}
+
+type failingMailer struct{}
+
+func (failingMailer) Send(ctx context.Context, msg Message) error {
+	return errors.New("smtp is down")
+}
+
+func testMessage() Message {
+	return Message{To: []string{"user@example.com"}, Subject: "hello", Text: "hi"}
+}
+
+func assertLen(t *testing.T, rdb *redis.Client, key string, want int64) {
+	t.Helper()
+
+	ctx := context.Background()
+	var (
+		n   int64
+		err error
+	)
+	switch key {
+	case retryKey, leaseKey:
+		n, err = rdb.ZCard(ctx, key).Result()
+	default:
+		n, err = rdb.LLen(ctx, key).Result()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != want {
+		t.Fatalf("%s has %d entries, want %d", key, n, want)
+	}
+}
+
+func TestQueueDelivers(t *testing.T) {
+	ctx := context.Background()
+	mailer := NewMemoryMailer()
+	q, rdb := newTestQueue(t, mailer)
+
+	if err := q.Enqueue(ctx, testMessage()); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	q.take(ctx, time.Second)
+
+	sent := mailer.Messages()
+	if len(sent) != 1 || sent[0].Subject != "hello" {
+		t.Fatalf("unexpected messages %+v", sent)
+	}
+	assertLen(t, rdb, queueKey, 0)
+	assertLen(t, rdb, processingKey, 0)
+	assertLen(t, rdb, leaseKey, 0)
+}
+
+func TestQueueSchedulesRetry(t *testing.T) {
+	ctx := context.Background()
+	q, rdb := newTestQueue(t, failingMailer{})
+
+	if err := q.Enqueue(ctx, testMessage()); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	q.take(ctx, time.Second)
+
+	assertLen(t, rdb, retryKey, 1)
+	assertLen(t, rdb, processingKey, 0)
+	assertLen(t, rdb, leaseKey, 0)
+}
+
+// a worker that took a job and died never acknowledges it,
+// the job comes back once its lease ran out
+func TestQueueReclaimsAbandonedJob(t *testing.T) {
+	ctx := context.Background()
+	mailer := NewMemoryMailer()
+	q, rdb := newTestQueue(t, mailer)
+
+	if err := q.Enqueue(ctx, testMessage()); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	raw, err := rdb.LMove(ctx, queueKey, processingKey, "RIGHT", "LEFT").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// died before leasing, the first look only leases it
+	q.maintain(ctx)
+	assertLen(t, rdb, processingKey, 1)
+	assertLen(t, rdb, leaseKey, 1)
+
+	expired := strconv.FormatInt(time.Now().Add(-time.Second).Unix(), 10)
+	if err := rdb.ZAdd(ctx, leaseKey, redis.Z{Score: mustFloat(t, expired), Member: raw}).Err(); err != nil {
+		t.Fatal(err)
+	}
+	q.maintain(ctx)
+	assertLen(t, rdb, processingKey, 0)
+	assertLen(t, rdb, leaseKey, 0)
+	assertLen(t, rdb, queueKey, 1)
+
+	q.take(ctx, time.Second)
+	if len(mailer.Messages()) != 1 {
+		t.Fatalf("reclaimed job was not delivered")
+	}
+}
+
+func mustFloat(t *testing.T, s string) float64 {
+	t.Helper()
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
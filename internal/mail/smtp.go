@@ -0,0 +1,44 @@
+package mail
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+
+	"auth/config"
+)
+
+type SMTPMailer struct {
+	addr string
+	host string
+	from string
+	auth smtp.Auth
+}
+
+func NewSMTPMailer(cfg config.MailConfig) *SMTPMailer {
+	var auth smtp.Auth
+	if cfg.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPHost)
+	}
+
+	return &SMTPMailer{
+		addr: net.JoinHostPort(cfg.SMTPHost, cfg.SMTPPort),
+		host: cfg.SMTPHost,
+		from: cfg.From,
+		auth: auth,
+	}
+}
+
+// Send uses STARTTLS whenever the server offers it
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	raw, err := buildMessage(m.from, msg)
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return smtp.SendMail(m.addr, m.auth, m.from, msg.To, raw)
+}
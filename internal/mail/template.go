@@ -0,0 +1,159 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+)
+
+const DefaultLocale = "en"
+
+//go:embed templates
+var templateFS embed.FS
+
+type localeTemplates struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+type localeKey struct{}
+
+var (
+	templatesOnce sync.Once
+	templates     map[string]localeTemplates
+	templatesErr  error
+)
+
+// every directory under templates/ is a locale, a mail named "x" is made of
+// x.subject.tmpl and x.txt.tmpl (text/template) plus x.html.tmpl (html/template)
+func loadTemplates() {
+	templates = map[string]localeTemplates{}
+
+	entries, err := fs.ReadDir(templateFS, "templates")
+	if err != nil {
+		templatesErr = err
+		return
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		locale := entry.Name()
+
+		text := texttemplate.New(locale).Funcs(texttemplate.FuncMap{"duration": durationFunc(locale)})
+		if _, err := text.ParseFS(templateFS, "templates/"+locale+"/*.subject.tmpl", "templates/"+locale+"/*.txt.tmpl"); err != nil {
+			templatesErr = fmt.Errorf("failed parsing %s mail templates: %w", locale, err)
+			return
+		}
+
+		html := htmltemplate.New(locale).Funcs(htmltemplate.FuncMap{"duration": durationFunc(locale)})
+		if _, err := html.ParseFS(templateFS, "templates/"+locale+"/*.html.tmpl"); err != nil {
+			templatesErr = fmt.Errorf("failed parsing %s mail templates: %w", locale, err)
+			return
+		}
+
+		templates[locale] = localeTemplates{text: text, html: html}
+	}
+}
+
+// durationUnits are the day, hour and minute words of a locale, singular and plural
+var durationUnits = map[string][3][2]string{
+	"en": {{"day", "days"}, {"hour", "hours"}, {"minute", "minutes"}},
+	"id": {{"hari", "hari"}, {"jam", "jam"}, {"menit", "menit"}},
+}
+
+// durationFunc is the "duration" template func, link lifetimes come from
+// the configured expiry and are written in the largest unit that fits exactly
+func durationFunc(locale string) func(time.Duration) string {
+	units, ok := durationUnits[locale]
+	if !ok {
+		units = durationUnits[DefaultLocale]
+	}
+
+	return func(d time.Duration) string {
+		n, unit := int64(d/time.Minute), units[2]
+		switch {
+		case d >= 48*time.Hour && d%(24*time.Hour) == 0:
+			n, unit = int64(d/(24*time.Hour)), units[0]
+		case d >= time.Hour && d%time.Hour == 0:
+			n, unit = int64(d/time.Hour), units[1]
+		}
+		if n <= 1 {
+			return "1 " + unit[0]
+		}
+		return fmt.Sprintf("%d %s", n, unit[1])
+	}
+}
+
+func WithLocale(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, localeKey{}, locale)
+}
+
+func LocaleFromContext(ctx context.Context) string {
+	locale, _ := ctx.Value(localeKey{}).(string)
+	if locale == "" {
+		return DefaultLocale
+	}
+	return locale
+}
+
+// MatchLocale picks the first supported language of an Accept-Language header
+func MatchLocale(acceptLanguage string) string {
+	templatesOnce.Do(loadTemplates)
+
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		lang := strings.ToLower(strings.SplitN(tag, "-", 2)[0])
+		if _, ok := templates[lang]; ok {
+			return lang
+		}
+	}
+	return DefaultLocale
+}
+
+// Render builds the message named name in the locale stored in ctx,
+// falling back to the default locale when it has no translation
+func Render(ctx context.Context, name string, to string, data any) (Message, error) {
+	templatesOnce.Do(loadTemplates)
+	if templatesErr != nil {
+		return Message{}, templatesErr
+	}
+
+	tmpl, ok := templates[LocaleFromContext(ctx)]
+	if !ok || tmpl.text.Lookup(name+".subject.tmpl") == nil {
+		tmpl, ok = templates[DefaultLocale]
+	}
+	if !ok || tmpl.text.Lookup(name+".subject.tmpl") == nil {
+		return Message{}, fmt.Errorf("unknown mail template %q", name)
+	}
+
+	subject := bytes.Buffer{}
+	if err := tmpl.text.ExecuteTemplate(&subject, name+".subject.tmpl", data); err != nil {
+		return Message{}, err
+	}
+
+	text := bytes.Buffer{}
+	if err := tmpl.text.ExecuteTemplate(&text, name+".txt.tmpl", data); err != nil {
+		return Message{}, err
+	}
+
+	html := bytes.Buffer{}
+	if err := tmpl.html.ExecuteTemplate(&html, name+".html.tmpl", data); err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		To:      []string{to},
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
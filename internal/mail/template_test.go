@@ -0,0 +1,38 @@
+package mail
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderUsesConfiguredExpiry(t *testing.T) {
+	cases := []struct {
+		locale string
+		expiry time.Duration
+		want   string
+	}{
+		{"en", 24 * time.Hour, "expires in 24 hours"},
+		{"en", 72 * time.Hour, "expires in 3 days"},
+		{"en", time.Hour, "expires in 1 hour"},
+		{"en", 45 * time.Minute, "expires in 45 minutes"},
+		{"id", 15 * time.Minute, "berlaku selama 15 menit"},
+		{"id", 48 * time.Hour, "berlaku selama 2 hari"},
+	}
+
+	for _, tc := range cases {
+		ctx := WithLocale(context.Background(), tc.locale)
+		msg, err := Render(ctx, "verify_email", "user@example.com", map[string]any{
+			"Name":   "User",
+			"Link":   "https://example.com/verify",
+			"Expiry": tc.expiry,
+		})
+		if err != nil {
+			t.Fatalf("Render: %v", err)
+		}
+		if !strings.Contains(msg.Text, tc.want) || !strings.Contains(msg.HTML, tc.want) {
+			t.Fatalf("%s %s: mail does not say %q:\n%s", tc.locale, tc.expiry, tc.want, msg.Text)
+		}
+	}
+}
@@ -4,53 +4,228 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
+	"auth/config"
 	"auth/internal/helper"
 	"auth/internal/model"
 )
 
+type contextKey string
+
+const (
+	UserIDKey contextKey = "userID"
+	RoleKey   contextKey = "role"
+	ClaimsKey contextKey = "claims"
+)
+
+func UserIDFromContext(ctx context.Context) (int, bool) {
+	id, ok := ctx.Value(UserIDKey).(int)
+	return id, ok && id != 0
+}
+
+func ClaimsFromContext(ctx context.Context) (*model.ClaimsModel, bool) {
+	claims, ok := ctx.Value(ClaimsKey).(*model.ClaimsModel)
+	return claims, ok
+}
+
+// bearerClaims validates the access token of the request, sent as Bearer
+// or, for tokens bound to a key, as DPoP together with a proof
+func bearerClaims(r *http.Request) (*model.ClaimsModel, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return nil, fmt.Errorf("Authorization header is empty")
+	}
+
+	tokenParts := strings.Split(authHeader, " ")
+	if len(tokenParts) != 2 || (tokenParts[0] != "Bearer" && tokenParts[0] != "DPoP") {
+		return nil, fmt.Errorf("Invalid Authorization header format")
+	}
+	scheme, token := tokenParts[0], tokenParts[1]
+
+	claims, err := helper.ValidateAccessToken(r.Context(), token)
+	if err != nil {
+		return nil, err
+	}
+
+	// exchanged tokens meant for another service are no good here
+	if len(claims.Audience) > 0 && !slices.Contains(claims.Audience, config.BaseURL()) {
+		return nil, fmt.Errorf("token is meant for another audience")
+	}
+
+	if err := checkDPoP(r, scheme, token, claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// checkDPoP makes a bound token come with a proof of its key made for this
+// request and this token, a stolen one is useless without the private key
+func checkDPoP(r *http.Request, scheme string, token string, claims *model.ClaimsModel) error {
+	if claims.Confirmation == nil {
+		if scheme == "DPoP" {
+			return fmt.Errorf("token is not DPoP bound, use the Bearer scheme")
+		}
+		// oauth clients opt in one by one, only first party tokens follow DPOP_REQUIRED
+		if helper.DPoPRequired() && claims.ClientID == "" && !claims.IsMachine() {
+			return helper.ErrDPoPRequired
+		}
+		return nil
+	}
+
+	if scheme != "DPoP" {
+		return fmt.Errorf("token is DPoP bound, use the DPoP scheme")
+	}
+
+	proof, ok := helper.DPoPProofFromContext(r.Context())
+	if !ok {
+		header := r.Header.Get("DPoP")
+		if header == "" {
+			return helper.ErrDPoPRequired
+		}
+		var err error
+		proof, err = helper.VerifyDPoPProof(r.Context(), header, r.Method, config.BaseURL()+r.URL.Path)
+		if err != nil {
+			return err
+		}
+	}
+
+	if proof.ATH != helper.AccessTokenHash(token) {
+		return fmt.Errorf("DPoP proof ath does not match the token")
+	}
+	if proof.JKT != claims.Confirmation.JKT {
+		return fmt.Errorf("DPoP proof is signed by another key")
+	}
+	return nil
+}
+
+func withClaims(r *http.Request, claims *model.ClaimsModel) *http.Request {
+	ctx := r.Context()
+	ctx = context.WithValue(ctx, UserIDKey, claims.UserID)
+	ctx = context.WithValue(ctx, RoleKey, claims.Role)
+	ctx = context.WithValue(ctx, ClaimsKey, claims)
+
+	return r.WithContext(ctx)
+}
+
+// JwtAuth only lets first party user sessions in. Client credentials tokens
+// are rejected so a machine can never pass for user 0, and tokens a user
+// granted an oauth client are limited to their scope, which means nothing here.
 func JwtAuth(n http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			helper.RespondError(w, http.StatusUnauthorized, fmt.Errorf("Authorization header is empty"))
+		claims, err := bearerClaims(r)
+		if err != nil {
+			helper.RespondError(w, http.StatusUnauthorized, err)
 			return
 		}
 
-		tokenParts := strings.Split(authHeader, " ")
-		if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
-			helper.RespondError(w, http.StatusUnauthorized, fmt.Errorf("Invalid Authorization header format"))
+		if claims.IsMachine() || claims.ClientID != "" || claims.Scope != "" {
+			helper.RespondError(w, http.StatusForbidden, fmt.Errorf("client tokens are not accepted here"))
 			return
 		}
 
-		tokenString := tokenParts[1]
-		claims, err := helper.ValidateAccessToken(tokenString)
+		n.ServeHTTP(w, withClaims(r, claims))
+	})
+}
+
+// JwtAuthAny accepts users and clients, use PrincipalFromContext to tell them apart
+func JwtAuthAny(n http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := bearerClaims(r)
 		if err != nil {
 			helper.RespondError(w, http.StatusUnauthorized, err)
 			return
 		}
 
-		type contextKey string
-		const (
-			userIDKey contextKey = "userID"
-			roleKey   contextKey = "role"
-		)
+		n.ServeHTTP(w, withClaims(r, claims))
+	})
+}
+
+// PrincipalFromContext returns who the token belongs to,
+// the user id for users and the client_id for clients
+func PrincipalFromContext(ctx context.Context) (model.PrincipalKind, string, bool) {
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok {
+		return "", "", false
+	}
+	if claims.IsMachine() {
+		return model.PrincipalClient, claims.ClientID, true
+	}
+	return model.PrincipalUser, strconv.Itoa(claims.UserID), true
+}
 
-		ctx := r.Context()
-		ctx = context.WithValue(ctx, userIDKey, claims.UserID)
-		ctx = context.WithValue(ctx, roleKey, claims.Role)
+// RequireScope checks the scope of tokens issued to a client,
+// first party user tokens carry no scope and are let through
+func RequireScope(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := ClaimsFromContext(r.Context())
+			if !ok {
+				helper.RespondError(w, http.StatusUnauthorized, fmt.Errorf("claims not found in context"))
+				return
+			}
 
-		r = r.WithContext(ctx)
+			if claims.ClientID != "" {
+				granted := strings.Fields(claims.Scope)
+				for _, scope := range scopes {
+					if !slices.Contains(granted, scope) {
+						helper.RespondError(w, http.StatusForbidden, fmt.Errorf("missing scope %q", scope))
+						return
+					}
+				}
+			}
 
-		n.ServeHTTP(w, r)
-	})
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireRecentAuth lets a request through only when the user signed in with
+// a credential within maxAge, a stolen session alone cannot add a passkey
+func RequireRecentAuth(maxAge time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := ClaimsFromContext(r.Context())
+			if !ok {
+				helper.RespondError(w, http.StatusUnauthorized, fmt.Errorf("claims not found in context"))
+				return
+			}
+
+			if claims.AuthTime == nil || time.Since(claims.AuthTime.Time) > maxAge {
+				helper.RespondError(w, http.StatusUnauthorized, fmt.Errorf("sign in again to continue"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RoleOrScope is for the admin endpoints backend jobs call too, use it after
+// JwtAuthAny. Every token issued to a client needs the scopes, users need one
+// of the roles on top, machines have no role and pass on the scopes alone.
+func RoleOrScope(roles []model.Role, scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		byRole := RoleChecker(roles...)(next)
+
+		return RequireScope(scopes...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if claims, ok := ClaimsFromContext(r.Context()); ok && claims.IsMachine() {
+				next.ServeHTTP(w, r)
+				return
+			}
+			byRole.ServeHTTP(w, r)
+		}))
+	}
 }
 
 func RoleChecker(allowedRoles ...model.Role) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			role, ok := r.Context().Value("role").(model.Role)
+			role, ok := r.Context().Value(RoleKey).(model.Role)
 			if !ok {
 				helper.RespondError(
 					w,
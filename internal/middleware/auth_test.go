@@ -0,0 +1,126 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"auth/internal/helper"
+	"auth/internal/model"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestRequireRecentAuth(t *testing.T) {
+	handler := RequireRecentAuth(5 * time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	cases := []struct {
+		name     string
+		authTime *jwt.NumericDate
+		want     int
+	}{
+		{"just signed in", jwt.NewNumericDate(time.Now().Add(-time.Minute)), http.StatusNoContent},
+		{"signed in long ago", jwt.NewNumericDate(time.Now().Add(-time.Hour)), http.StatusUnauthorized},
+		{"token without auth_time", nil, http.StatusUnauthorized},
+	}
+
+	for _, tc := range cases {
+		claims := &model.ClaimsModel{UserID: 42, AuthTime: tc.authTime}
+		r := httptest.NewRequest(http.MethodPost, "/user/me/webauthn/register/begin", nil)
+		r = r.WithContext(context.WithValue(r.Context(), ClaimsKey, claims))
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, r)
+		if w.Code != tc.want {
+			t.Fatalf("%s: status %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+}
+
+func withTestClaims(r *http.Request, claims *model.ClaimsModel) *http.Request {
+	return r.WithContext(context.WithValue(
+		context.WithValue(r.Context(), ClaimsKey, claims),
+		RoleKey, claims.Role,
+	))
+}
+
+func TestRoleOrScope(t *testing.T) {
+	handler := RoleOrScope([]model.Role{model.RoleAdmin}, model.ScopeSessionsAdmin)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	cases := []struct {
+		name   string
+		claims *model.ClaimsModel
+		want   int
+	}{
+		{"admin", &model.ClaimsModel{UserID: 1, Role: model.RoleAdmin}, http.StatusNoContent},
+		{"user", &model.ClaimsModel{UserID: 2, Role: model.RoleUser}, http.StatusForbidden},
+		{"job with the scope", &model.ClaimsModel{ClientID: "job", Scope: "sessions:admin", Kind: model.PrincipalClient}, http.StatusNoContent},
+		{"job without the scope", &model.ClaimsModel{ClientID: "job", Scope: "reports", Kind: model.PrincipalClient}, http.StatusForbidden},
+		// an app the admin signed in to only has what it was granted
+		{"admin through a client", &model.ClaimsModel{UserID: 1, Role: model.RoleAdmin, ClientID: "app", Scope: "openid"}, http.StatusForbidden},
+		{"user through a client with the scope", &model.ClaimsModel{UserID: 2, Role: model.RoleUser, ClientID: "app", Scope: "sessions:admin"}, http.StatusForbidden},
+	}
+
+	for _, tc := range cases {
+		r := withTestClaims(httptest.NewRequest(http.MethodDelete, "/user/2/sessions/abc", nil), tc.claims)
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, r)
+		if w.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+}
+
+func TestJwtAuthOnlyTakesFirstPartySessions(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-access-secret-0123456789abcdef")
+	t.Setenv("JWT_SIGNING_ALG", "")
+	t.Setenv("JWT_KEY_STORE", "")
+	t.Setenv("DPOP_REQUIRED", "")
+	ctx := context.Background()
+	user := model.User{ID: 42, Username: "user", Role: model.RoleUser}
+
+	session, err := helper.CreateAccessToken(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delegated, err := helper.CreateClientAccessToken(ctx, user, "app", "openid profile", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	machine, err := helper.CreateMachineAccessToken(ctx, "job", "sessions:admin", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := JwtAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	cases := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"first party session", session, http.StatusNoContent},
+		{"token granted to an oauth client", delegated, http.StatusForbidden},
+		{"client credentials token", machine, http.StatusForbidden},
+	}
+
+	for _, tc := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/user/me/sessions", nil)
+		r.Header.Set("Authorization", "Bearer "+tc.token)
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, r)
+		if w.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+}
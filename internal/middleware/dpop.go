@@ -0,0 +1,42 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"auth/config"
+	"auth/internal/helper"
+)
+
+// DPoP verifies the proof of a request that sends one, tokens issued while
+// handling it are bound to the proof key. Requests without the header pass.
+func DPoP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Values("DPoP")
+		if len(header) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(header) > 1 {
+			respondDPoPError(w, "only one DPoP proof is allowed")
+			return
+		}
+
+		proof, err := helper.VerifyDPoPProof(r.Context(), header[0], r.Method, config.BaseURL()+r.URL.Path)
+		if err != nil {
+			respondDPoPError(w, err.Error())
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(helper.WithDPoPProof(r.Context(), proof)))
+	})
+}
+
+func respondDPoPError(w http.ResponseWriter, description string) {
+	w.Header().Set("WWW-Authenticate", `DPoP error="invalid_dpop_proof"`)
+	w.Header().Set("Cache-Control", "no-store")
+	helper.RespondJSON(w, http.StatusBadRequest, map[string]string{
+		"error":             "invalid_dpop_proof",
+		"error_description": description,
+	})
+}
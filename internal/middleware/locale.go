@@ -0,0 +1,15 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"auth/internal/mail"
+)
+
+// Locale stores the request language so mails are rendered in it
+func Locale(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		locale := mail.MatchLocale(r.Header.Get("Accept-Language"))
+		next.ServeHTTP(w, r.WithContext(mail.WithLocale(r.Context(), locale)))
+	})
+}
@@ -0,0 +1,16 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"auth/internal/helper"
+)
+
+// SessionInfo remembers the device of the request, a session started
+// while handling it is listed with it
+func SessionInfo(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := helper.SessionInfoFromRequest(r)
+		next.ServeHTTP(w, r.WithContext(helper.WithSessionInfo(r.Context(), info)))
+	})
+}
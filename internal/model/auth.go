@@ -5,6 +5,8 @@ import "github.com/golang-jwt/jwt/v5"
 type Logincredential struct {
 	Username string `db:"username" json:"username"`
 	Password string `db:"password" json:"password"`
+	// asks for the longer session of the policy
+	RememberMe bool `json:"remember_me"`
 }
 
 type RegisterCredential struct {
@@ -19,15 +21,82 @@ type VerifyEmail struct {
 	ID    int    `db:"id" json:"id"`
 }
 
+type ForgotPassword struct {
+	Email string `json:"email"`
+}
+
+type MagicLinkRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPassword struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
 type VerifyUsername struct {
 	Username string `db:"username" json:"username"`
 	ID       int    `db:"id" json:"id"`
 }
 
+type PrincipalKind string
+
+const (
+	PrincipalUser PrincipalKind = "user"
+	// a client acting for itself through the client_credentials grant
+	PrincipalClient PrincipalKind = "client"
+)
+
+// ScopeSessionsAdmin lets a client list and end the sessions of any user,
+// for backend jobs that sign out accounts, admins need no scope
+const ScopeSessionsAdmin = "sessions:admin"
+
 type ClaimsModel struct {
 	UserID   int    `json:"id"`
 	Role     Role   `json:"role"`
 	Name     string `json:"name"`
 	Username string `json:"username"`
+	// set on tokens issued to an oauth client, empty for first party logins
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	// empty on user tokens, older tokens never had it
+	Kind PrincipalKind `json:"kind,omitempty"`
+	// set by token exchange, who is acting for the subject
+	Actor *Actor `json:"act,omitempty"`
+	// a DPoP bound token only works together with a proof of this key
+	Confirmation *Confirmation `json:"cnf,omitempty"`
+	// the session the token belongs to across rotations, access tokens carry
+	// it so they end with an evicted session. Refresh tokens only, when the
+	// session ends at the latest and whether it was a "remember me" login.
+	SessionID        string           `json:"sid,omitempty"`
+	SessionExpiresAt *jwt.NumericDate `json:"sess_exp,omitempty"`
+	Remember         bool             `json:"rmb,omitempty"`
+	// user tokens, older than the user's current generation means logged out everywhere
+	Generation int64 `json:"gen,omitempty"`
+	// when the user last signed in with a credential, refreshes keep it
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Confirmation is the RFC 9449 cnf claim, JKT is the thumbprint of the client key
+type Confirmation struct {
+	JKT string `json:"jkt"`
+}
+
+// Actor is the RFC 8693 act claim, a chain of delegations nests the earlier actor
+type Actor struct {
+	Subject  string `json:"sub"`
+	ClientID string `json:"client_id,omitempty"`
+	Actor    *Actor `json:"act,omitempty"`
+}
+
+// IsMachine reports a client token, its subject is the client_id and it has no user or role
+func (c *ClaimsModel) IsMachine() bool {
+	return c.Kind == PrincipalClient
+}
+
+type EmailVerificationClaims struct {
+	UserID int    `json:"id"`
+	Email  string `json:"email"`
 	jwt.RegisteredClaims
 }
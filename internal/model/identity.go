@@ -0,0 +1,12 @@
+package model
+
+import "time"
+
+type UserIdentity struct {
+	ID       int        `db:"id" json:"id"`
+	UserID   int        `db:"user_id" json:"user_id"`
+	Provider string     `db:"provider" json:"provider"`
+	Subject  string     `db:"subject" json:"subject"`
+	Email    *string    `db:"email" json:"email"`
+	LinkedAt *time.Time `db:"linked_at" json:"linked_at"`
+}
@@ -0,0 +1,45 @@
+package model
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type UserTOTP struct {
+	UserID       int        `db:"user_id" json:"user_id"`
+	Secret       string     `db:"secret" json:"-"`
+	ConfirmedAt  *time.Time `db:"confirmed_at" json:"confirmed_at"`
+	LastUsedStep int64      `db:"last_used_step" json:"-"`
+	CreatedAt    *time.Time `db:"created_at" json:"created_at"`
+}
+
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+	QRCode []byte `json:"qr_png"`
+}
+
+type TOTPConfirm struct {
+	Code string `json:"code"`
+}
+
+type MFAVerify struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type MFAChallenge struct {
+	MFARequired bool     `json:"mfa_required"`
+	MFAToken    string   `json:"mfa_token"`
+	Methods     []string `json:"methods"`
+}
+
+type MFAClaims struct {
+	UserID  int    `json:"id"`
+	Purpose string `json:"purpose"`
+	// the "remember me" choice of the password step, the session starts after the second factor
+	RememberMe bool `json:"rmb,omitempty"`
+	jwt.RegisteredClaims
+}
@@ -0,0 +1,147 @@
+package model
+
+import "github.com/golang-jwt/jwt/v5"
+
+type AuthorizeRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Prompt              string
+}
+
+type TokenRequest struct {
+	GrantType    string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+	RefreshToken string
+	DeviceCode   string
+	ClientID     string
+	ClientSecret string
+	Scope        string
+
+	// token exchange
+	SubjectToken       string
+	SubjectTokenType   string
+	ActorToken         string
+	ActorTokenType     string
+	Audience           []string
+	RequestedTokenType string
+}
+
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	// only on token exchange answers
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+}
+
+type IntrospectionRequest struct {
+	Token         string
+	TokenTypeHint string
+	ClientID      string
+	ClientSecret  string
+}
+
+type RevocationRequest struct {
+	Token         string
+	TokenTypeHint string
+	ClientID      string
+	ClientSecret  string
+}
+
+// IntrospectionResponse is the RFC 7662 answer, inactive tokens only carry active
+type IntrospectionResponse struct {
+	Active    bool             `json:"active"`
+	Scope     string           `json:"scope,omitempty"`
+	ClientID  string           `json:"client_id,omitempty"`
+	Username  string           `json:"username,omitempty"`
+	TokenType string           `json:"token_type,omitempty"`
+	ExpiresAt int64            `json:"exp,omitempty"`
+	IssuedAt  int64            `json:"iat,omitempty"`
+	Subject   string           `json:"sub,omitempty"`
+	Audience  jwt.ClaimStrings `json:"aud,omitempty"`
+	Issuer    string           `json:"iss,omitempty"`
+	JTI       string           `json:"jti,omitempty"`
+	Kind      PrincipalKind    `json:"kind,omitempty"`
+	Actor     *Actor           `json:"act,omitempty"`
+	Cnf       *Confirmation    `json:"cnf,omitempty"`
+}
+
+type DeviceAuthorizationRequest struct {
+	ClientID     string
+	ClientSecret string
+	Scope        string
+}
+
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// DevicePrompt is what the verification page asks the user to approve
+type DevicePrompt struct {
+	UserCode   string
+	ClientName string
+	Scopes     []string
+}
+
+// AuthorizationCode is what a code stands for while it waits in redis
+type AuthorizationCode struct {
+	ClientID      string `json:"client_id"`
+	RedirectURI   string `json:"redirect_uri"`
+	UserID        int    `json:"user_id"`
+	Scope         string `json:"scope"`
+	Nonce         string `json:"nonce,omitempty"`
+	CodeChallenge string `json:"code_challenge"`
+}
+
+type IDTokenClaims struct {
+	Nonce             string `json:"nonce,omitempty"`
+	Name              string `json:"name,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+	jwt.RegisteredClaims
+}
+
+type UserInfo struct {
+	Subject           string `json:"sub"`
+	Name              string `json:"name,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+}
+
+type OpenIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	DPoPSigningAlgValuesSupported     []string `json:"dpop_signing_alg_values_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
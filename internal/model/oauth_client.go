@@ -0,0 +1,43 @@
+package model
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type OAuthClient struct {
+	ID              int            `db:"id" json:"id"`
+	ClientID        string         `db:"client_id" json:"client_id"`
+	Name            string         `db:"name" json:"name"`
+	SecretHash      string         `db:"secret_hash" json:"-"`
+	Public          bool           `db:"is_public" json:"public"`
+	RequireDPoP     bool           `db:"require_dpop" json:"require_dpop"`
+	RedirectURIs    pq.StringArray `db:"redirect_uris" json:"redirect_uris"`
+	GrantTypes      pq.StringArray `db:"grant_types" json:"grant_types"`
+	Scopes          pq.StringArray `db:"scopes" json:"scopes"`
+	Audiences       pq.StringArray `db:"audiences" json:"audiences"`
+	AccessTokenTTL  int            `db:"access_token_ttl" json:"access_token_ttl"`
+	RefreshTokenTTL int            `db:"refresh_token_ttl" json:"refresh_token_ttl"`
+	CreatedAt       *time.Time     `db:"created_at" json:"created_at"`
+	UpdatedAt       *time.Time     `db:"updated_at" json:"updated_at"`
+}
+
+type OAuthClientInput struct {
+	ClientID        string   `json:"client_id"`
+	Name            string   `json:"name"`
+	Public          bool     `json:"public"`
+	RequireDPoP     bool     `json:"require_dpop"`
+	RedirectURIs    []string `json:"redirect_uris"`
+	GrantTypes      []string `json:"grant_types"`
+	Scopes          []string `json:"scopes"`
+	Audiences       []string `json:"audiences"`
+	AccessTokenTTL  int      `json:"access_token_ttl"`
+	RefreshTokenTTL int      `json:"refresh_token_ttl"`
+}
+
+// OAuthClientSecret is returned once, on create and on secret rotation
+type OAuthClientSecret struct {
+	OAuthClient
+	ClientSecret string `json:"client_secret,omitempty"`
+}
@@ -0,0 +1,21 @@
+package model
+
+import "time"
+
+const (
+	// a retired refresh token came back, its whole family was revoked
+	SecurityEventRefreshReuse = "refresh_token_reuse"
+	// a login over the session cap signed out the oldest session
+	SecurityEventSessionEvicted = "session_evicted"
+)
+
+// SecurityEvent is something worth a look from whoever watches the accounts
+type SecurityEvent struct {
+	Type      string    `json:"type"`
+	UserID    int       `json:"user_id"`
+	SessionID string    `json:"session_id,omitempty"`
+	ClientID  string    `json:"client_id,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	At        time.Time `json:"at"`
+}
@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+// Session is one sign in on one device, it lives as long as its refresh token
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     int       `json:"user_id"`
+	ClientID   string    `json:"client_id,omitempty"`
+	DeviceName string    `json:"device_name"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	// the absolute end, refreshing does not keep the session past it
+	ExpiresAt  time.Time `json:"expires_at,omitzero"`
+	RememberMe bool      `json:"remember_me"`
+	// the session of the refresh cookie sent with the request
+	Current bool `json:"current"`
+}
+
+type SessionLimit struct {
+	// nil removes the cap of the account, the session policy applies again
+	MaxSessions *int `json:"max_sessions"`
+}
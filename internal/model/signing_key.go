@@ -0,0 +1,23 @@
+package model
+
+import "time"
+
+type SigningKeyState string
+
+const (
+	SigningKeyPending    SigningKeyState = "pending"
+	SigningKeyActive     SigningKeyState = "active"
+	SigningKeyVerifyOnly SigningKeyState = "verify_only"
+	SigningKeyRetired    SigningKeyState = "retired"
+)
+
+type SigningKey struct {
+	ID            int             `db:"id" json:"id"`
+	KID           string          `db:"kid" json:"kid"`
+	Alg           string          `db:"alg" json:"alg"`
+	PrivateKey    string          `db:"private_key" json:"-"`
+	State         SigningKeyState `db:"state" json:"state"`
+	CreatedAt     *time.Time      `db:"created_at" json:"created_at"`
+	DeactivatedAt *time.Time      `db:"deactivated_at" json:"deactivated_at"`
+	RetiredAt     *time.Time      `db:"retired_at" json:"retired_at"`
+}
@@ -3,14 +3,24 @@ package model
 import "time"
 
 type User struct {
-	ID        int        `db:"id" json:"id"`
-	Name      string     `db:"name" json:"name"`
-	Username  string     `db:"username" json:"username"`
-	Password  string     `db:"password" json:"password"`
-	Role      Role       `db:"role" json:"role"`
-	Age       *int       `db:"age" json:"age"`
-	CreatedAt *time.Time `db:"created_at" json:"created_at"`
-	UpdatedAt *time.Time `db:"updated_at" json:"updated_at"`
+	ID            int     `db:"id" json:"id"`
+	Name          string  `db:"name" json:"name"`
+	Username      string  `db:"username" json:"username"`
+	Email         *string `db:"email" json:"email"`
+	EmailVerified bool    `db:"email_verified" json:"email_verified"`
+	Password      string  `db:"password" json:"password"`
+	Role          Role    `db:"role" json:"role"`
+	Age           *int    `db:"age" json:"age"`
+	// concurrent session cap of this account, nil follows the session policy
+	MaxSessions *int       `db:"max_sessions" json:"max_sessions,omitempty"`
+	CreatedAt   *time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt   *time.Time `db:"updated_at" json:"updated_at"`
+}
+
+type ChangePassword struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+	SignOutOthers   bool   `json:"sign_out_others"`
 }
 
 type Role string
@@ -0,0 +1,24 @@
+package model
+
+import "time"
+
+type WebAuthnCredential struct {
+	ID           int        `db:"id" json:"id"`
+	UserID       int        `db:"user_id" json:"user_id"`
+	CredentialID []byte     `db:"credential_id" json:"credential_id"`
+	Name         string     `db:"name" json:"name"`
+	Credential   []byte     `db:"credential" json:"-"`
+	SignCount    int64      `db:"sign_count" json:"sign_count"`
+	CreatedAt    *time.Time `db:"created_at" json:"created_at"`
+	LastUsedAt   *time.Time `db:"last_used_at" json:"last_used_at"`
+}
+
+type WebAuthnBegin struct {
+	Username string `json:"username"`
+	MFAToken string `json:"mfa_token"`
+}
+
+type WebAuthnOptions struct {
+	SessionID string `json:"session_id"`
+	Options   any    `json:"options"`
+}
@@ -13,6 +13,8 @@ type AuthRepo interface {
 	Create(ctx context.Context, user model.User) (*model.User, error)
 	VerifyEmail(ctx context.Context, email string, id int) error
 	VerifyUsername(ctx context.Context, username string, id int) error
+	MarkEmailVerified(ctx context.Context, id int, email string) error
+	UpdatePassword(ctx context.Context, id int, password string) error
 }
 
 type authRepo struct {
@@ -27,14 +29,15 @@ func (s *authRepo) Create(ctx context.Context, user model.User) (*model.User, er
 	data := model.User{
 		Name:     user.Name,
 		Username: user.Username,
+		Email:    user.Email,
 		Password: user.Password,
 		Role:     model.RoleUser,
 	}
 
 	query := `
-		INSERT INTO users (name, username, password, role)
-		VALUES (:name, :username, :password, :role)
-		RETURNING id, name, username, role, password`
+		INSERT INTO users (name, username, email, password, role)
+		VALUES (:name, :username, :email, :password, :role)
+		RETURNING id, name, username, email, email_verified, role, password`
 
 	rows, err := s.db.NamedQueryContext(ctx, query, data)
 	if err != nil {
@@ -59,7 +62,7 @@ func (s *authRepo) VerifyEmail(ctx context.Context, email string, id int) error
 	query := `SELECT 1 
 		FROM users
 		WHERE
-		email = $1 AND 
+		LOWER(email) = LOWER($1) AND 
 		id != $2`
 
 	if err := s.db.GetContext(ctx,
@@ -96,3 +99,45 @@ func (s *authRepo) VerifyUsername(ctx context.Context, username string, id int)
 
 	return nil
 }
+
+func (s *authRepo) MarkEmailVerified(ctx context.Context, id int, email string) error {
+	query := `UPDATE users
+		SET email_verified = TRUE
+		WHERE
+		id = $1 AND
+		LOWER(email) = LOWER($2)`
+
+	res, err := s.db.ExecContext(ctx, query, id, email)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (s *authRepo) UpdatePassword(ctx context.Context, id int, password string) error {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE users SET password = $1 WHERE id = $2`,
+		password, id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
@@ -0,0 +1,190 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"auth/internal/model"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var ErrLastLoginMethod = errors.New("cannot remove the last remaining way to log in")
+
+type IdentityRepo interface {
+	Create(ctx context.Context, identity model.UserIdentity) (*model.UserIdentity, error)
+	CreateWithUser(ctx context.Context, user model.User, identity model.UserIdentity) (*model.User, error)
+	GetByProviderSubject(ctx context.Context, provider string, subject string) (*model.UserIdentity, error)
+	GetByUserId(ctx context.Context, userID int) ([]model.UserIdentity, error)
+	Delete(ctx context.Context, userID int, provider string) error
+}
+
+type identityRepo struct {
+	db *sqlx.DB
+}
+
+func NewIdentityRepo(db *sqlx.DB) *identityRepo {
+	return &identityRepo{db: db}
+}
+
+func (s *identityRepo) Create(ctx context.Context, identity model.UserIdentity) (*model.UserIdentity, error) {
+	query := `
+		INSERT INTO user_identities (user_id, provider, subject, email)
+		VALUES (:user_id, :provider, :subject, :email)
+		RETURNING id, user_id, provider, subject, email, linked_at`
+
+	rows, err := s.db.NamedQueryContext(ctx, query, identity)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		if err := rows.StructScan(&identity); err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, fmt.Errorf("insert succeeded but returned no rows")
+	}
+
+	return &identity, nil
+}
+
+// CreateWithUser provisions a new account for a first social login,
+// the user and its identity are written in one transaction
+func (s *identityRepo) CreateWithUser(
+	ctx context.Context,
+	user model.User,
+	identity model.UserIdentity,
+) (*model.User, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	data := model.User{
+		Name:          user.Name,
+		Username:      user.Username,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Password:      user.Password,
+		Role:          model.RoleUser,
+	}
+
+	userQuery := `
+		INSERT INTO users (name, username, email, email_verified, password, role)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, name, username, email, email_verified, role, password`
+
+	if err := tx.GetContext(ctx, &data, userQuery,
+		data.Name, data.Username, data.Email, data.EmailVerified, data.Password, data.Role); err != nil {
+		return nil, err
+	}
+
+	identityQuery := `
+		INSERT INTO user_identities (user_id, provider, subject, email)
+		VALUES ($1, $2, $3, $4)`
+
+	if _, err := tx.ExecContext(ctx, identityQuery,
+		data.ID, identity.Provider, identity.Subject, identity.Email); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+func (s *identityRepo) GetByProviderSubject(
+	ctx context.Context,
+	provider string,
+	subject string,
+) (*model.UserIdentity, error) {
+	identity := model.UserIdentity{}
+	if err := s.db.GetContext(
+		ctx,
+		&identity,
+		`SELECT * FROM user_identities WHERE provider = $1 AND subject = $2`,
+		provider,
+		subject,
+	); err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (s *identityRepo) GetByUserId(ctx context.Context, userID int) ([]model.UserIdentity, error) {
+	identities := []model.UserIdentity{}
+	if err := s.db.SelectContext(
+		ctx,
+		&identities,
+		`SELECT * FROM user_identities WHERE user_id = $1 ORDER BY linked_at`,
+		userID,
+	); err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+// Delete unlinks a provider, refusing when it is the last way to log in.
+// The user row is locked so two concurrent unlinks cannot both pass the check.
+func (s *identityRepo) Delete(ctx context.Context, userID int, provider string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockUser(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx,
+		`DELETE FROM user_identities WHERE user_id = $1 AND provider = $2`,
+		userID, provider)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err := checkLoginMethodLeft(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func lockUser(ctx context.Context, tx *sqlx.Tx, userID int) error {
+	var id int
+	return tx.GetContext(ctx, &id, `SELECT id FROM users WHERE id = $1 FOR UPDATE`, userID)
+}
+
+// checkLoginMethodLeft runs after a delete inside the same transaction,
+// a password, a linked provider or a passkey each count as a way to log in
+func checkLoginMethodLeft(ctx context.Context, tx *sqlx.Tx, userID int) error {
+	var count int
+	if err := tx.GetContext(ctx, &count, `
+		SELECT
+			(SELECT count(*) FROM users WHERE id = $1 AND password <> '')
+			+ (SELECT count(*) FROM user_identities WHERE user_id = $1)
+			+ (SELECT count(*) FROM webauthn_credentials WHERE user_id = $1)`,
+		userID); err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrLastLoginMethod
+	}
+	return nil
+}
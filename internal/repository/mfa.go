@@ -0,0 +1,171 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"auth/internal/model"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var ErrMFAEnabled = errors.New("two-factor authentication is already enabled")
+
+type MFARepo interface {
+	GetTOTP(ctx context.Context, userID int) (*model.UserTOTP, error)
+	UpsertTOTP(ctx context.Context, userID int, secret string) error
+	ConfirmTOTP(ctx context.Context, userID int, step int64, codeHashes []string) error
+	UseTOTPStep(ctx context.Context, userID int, step int64) error
+	UseRecoveryCode(ctx context.Context, userID int, codeHash string) error
+	Delete(ctx context.Context, userID int) error
+}
+
+type mfaRepo struct {
+	db *sqlx.DB
+}
+
+func NewMFARepo(db *sqlx.DB) *mfaRepo {
+	return &mfaRepo{db: db}
+}
+
+func (s *mfaRepo) GetTOTP(ctx context.Context, userID int) (*model.UserTOTP, error) {
+	totp := model.UserTOTP{}
+	if err := s.db.GetContext(
+		ctx,
+		&totp,
+		`SELECT * FROM user_totp WHERE user_id = $1`,
+		userID,
+	); err != nil {
+		return nil, err
+	}
+	return &totp, nil
+}
+
+// UpsertTOTP starts or restarts an enrollment, a confirmed secret is never replaced
+func (s *mfaRepo) UpsertTOTP(ctx context.Context, userID int, secret string) error {
+	query := `
+		INSERT INTO user_totp (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, created_at = CURRENT_TIMESTAMP
+		WHERE user_totp.confirmed_at IS NULL`
+
+	res, err := s.db.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrMFAEnabled
+	}
+
+	return nil
+}
+
+func (s *mfaRepo) ConfirmTOTP(ctx context.Context, userID int, step int64, codeHashes []string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE user_totp
+		SET confirmed_at = CURRENT_TIMESTAMP, last_used_step = $2
+		WHERE user_id = $1 AND confirmed_at IS NULL`,
+		userID, step)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrMFAEnabled
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	for _, hash := range codeHashes {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)`,
+			userID, hash); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UseTOTPStep moves the last used step forward, a code of an already used
+// step fails so an intercepted code cannot be replayed
+func (s *mfaRepo) UseTOTPStep(ctx context.Context, userID int, step int64) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE user_totp
+		SET last_used_step = $2
+		WHERE user_id = $1 AND confirmed_at IS NOT NULL AND last_used_step < $2`,
+		userID, step)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (s *mfaRepo) UseRecoveryCode(ctx context.Context, userID int, codeHash string) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE mfa_recovery_codes
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`,
+		userID, codeHash)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (s *mfaRepo) Delete(ctx context.Context, userID int) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM user_totp WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
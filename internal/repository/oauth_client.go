@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"auth/internal/model"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type OAuthClientRepo interface {
+	Create(ctx context.Context, client model.OAuthClient) (*model.OAuthClient, error)
+	GetByClientId(ctx context.Context, clientID string) (*model.OAuthClient, error)
+	GetMany(ctx context.Context) ([]model.OAuthClient, error)
+	Update(ctx context.Context, client model.OAuthClient) (*model.OAuthClient, error)
+	UpdateSecret(ctx context.Context, clientID string, secretHash string) error
+	Delete(ctx context.Context, clientID string) error
+}
+
+type oAuthClientRepo struct {
+	db *sqlx.DB
+}
+
+func NewOAuthClientRepo(db *sqlx.DB) *oAuthClientRepo {
+	return &oAuthClientRepo{db: db}
+}
+
+func (s *oAuthClientRepo) Create(ctx context.Context, client model.OAuthClient) (*model.OAuthClient, error) {
+	query := `
+		INSERT INTO oauth_clients (
+			client_id, name, secret_hash, is_public, require_dpop, redirect_uris,
+			grant_types, scopes, audiences, access_token_ttl, refresh_token_ttl
+		)
+		VALUES (
+			:client_id, :name, :secret_hash, :is_public, :require_dpop, :redirect_uris,
+			:grant_types, :scopes, :audiences, :access_token_ttl, :refresh_token_ttl
+		)
+		RETURNING *`
+
+	rows, err := s.db.NamedQueryContext(ctx, query, client)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		if err := rows.StructScan(&client); err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, fmt.Errorf("insert succeeded but returned no rows")
+	}
+
+	return &client, nil
+}
+
+func (s *oAuthClientRepo) GetByClientId(ctx context.Context, clientID string) (*model.OAuthClient, error) {
+	client := model.OAuthClient{}
+	if err := s.db.GetContext(
+		ctx,
+		&client,
+		`SELECT * FROM oauth_clients WHERE client_id = $1`,
+		clientID,
+	); err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+func (s *oAuthClientRepo) GetMany(ctx context.Context) ([]model.OAuthClient, error) {
+	clients := []model.OAuthClient{}
+	if err := s.db.SelectContext(
+		ctx,
+		&clients,
+		`SELECT * FROM oauth_clients ORDER BY client_id`,
+	); err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+// Update leaves the secret alone, it only changes through UpdateSecret
+func (s *oAuthClientRepo) Update(ctx context.Context, client model.OAuthClient) (*model.OAuthClient, error) {
+	query := `
+		UPDATE oauth_clients
+		SET name = :name,
+			is_public = :is_public,
+			require_dpop = :require_dpop,
+			redirect_uris = :redirect_uris,
+			grant_types = :grant_types,
+			scopes = :scopes,
+			audiences = :audiences,
+			access_token_ttl = :access_token_ttl,
+			refresh_token_ttl = :refresh_token_ttl
+		WHERE client_id = :client_id
+		RETURNING *`
+
+	rows, err := s.db.NamedQueryContext(ctx, query, client)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, sql.ErrNoRows
+	}
+	if err := rows.StructScan(&client); err != nil {
+		return nil, err
+	}
+
+	return &client, nil
+}
+
+func (s *oAuthClientRepo) UpdateSecret(ctx context.Context, clientID string, secretHash string) error {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE oauth_clients SET secret_hash = $2 WHERE client_id = $1`,
+		clientID, secretHash)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *oAuthClientRepo) Delete(ctx context.Context, clientID string) error {
+	res, err := s.db.ExecContext(ctx,
+		`DELETE FROM oauth_clients WHERE client_id = $1`, clientID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
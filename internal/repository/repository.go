@@ -5,6 +5,11 @@ import "github.com/jmoiron/sqlx"
 type Repository interface {
 	User() userRepo
 	Auth() authRepo
+	Identity() identityRepo
+	MFA() mfaRepo
+	WebAuthn() webAuthnRepo
+	SigningKey() signingKeyRepo
+	OAuthClient() oAuthClientRepo
 }
 
 type repository struct {
@@ -21,6 +26,26 @@ func (r *repository) Auth() authRepo {
 	return authRepo{db: r.db}
 }
 
+func (r *repository) Identity() identityRepo {
+	return identityRepo{db: r.db}
+}
+
+func (r *repository) MFA() mfaRepo {
+	return mfaRepo{db: r.db}
+}
+
+func (r *repository) WebAuthn() webAuthnRepo {
+	return webAuthnRepo{db: r.db}
+}
+
+func (r *repository) SigningKey() signingKeyRepo {
+	return signingKeyRepo{db: r.db}
+}
+
+func (r *repository) OAuthClient() oAuthClientRepo {
+	return oAuthClientRepo{db: r.db}
+}
+
 func (r *repository) User() userRepo {
 	return userRepo{db: r.db}
 }
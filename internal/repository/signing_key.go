@@ -0,0 +1,174 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"auth/internal/model"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type SigningKeyRepo interface {
+	GetMany(ctx context.Context) ([]model.SigningKey, error)
+	GetUsable(ctx context.Context) ([]model.SigningKey, error)
+	Rotate(ctx context.Context, key model.SigningKey, activeBefore *time.Time) (bool, error)
+	Promote(ctx context.Context, createdBefore time.Time) (string, error)
+	Retire(ctx context.Context, deactivatedBefore time.Time) (int64, error)
+}
+
+type signingKeyRepo struct {
+	db *sqlx.DB
+}
+
+func NewSigningKeyRepo(db *sqlx.DB) *signingKeyRepo {
+	return &signingKeyRepo{db: db}
+}
+
+func (s *signingKeyRepo) GetMany(ctx context.Context) ([]model.SigningKey, error) {
+	keys := []model.SigningKey{}
+	if err := s.db.SelectContext(
+		ctx,
+		&keys,
+		`SELECT * FROM signing_keys ORDER BY created_at DESC`,
+	); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// GetUsable returns the pending, the active and every verify-only key
+func (s *signingKeyRepo) GetUsable(ctx context.Context) ([]model.SigningKey, error) {
+	keys := []model.SigningKey{}
+	if err := s.db.SelectContext(
+		ctx,
+		&keys,
+		`SELECT * FROM signing_keys WHERE state IN ('pending', 'active', 'verify_only') ORDER BY created_at DESC`,
+	); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Rotate inserts the new key in the state it carries. A pending key waits for
+// Promote, nothing is rotated while one is waiting. An active key replaces the
+// active one right away, which is demoted to verify-only.
+// With activeBefore set it only rotates when the active key is older,
+// so instances running the same schedule rotate once between them.
+func (s *signingKeyRepo) Rotate(
+	ctx context.Context,
+	key model.SigningKey,
+	activeBefore *time.Time,
+) (bool, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('signing_keys'))`); err != nil {
+		return false, err
+	}
+
+	var createdAt time.Time
+	err = tx.GetContext(ctx, &createdAt,
+		`SELECT created_at FROM signing_keys WHERE state = 'active'`)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+	if err == nil && activeBefore != nil && !createdAt.Before(*activeBefore) {
+		return false, nil
+	}
+
+	var pending bool
+	if err := tx.GetContext(ctx, &pending,
+		`SELECT EXISTS (SELECT 1 FROM signing_keys WHERE state = 'pending')`); err != nil {
+		return false, err
+	}
+	if pending {
+		return false, nil
+	}
+
+	if key.State == model.SigningKeyActive {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE signing_keys
+			SET state = 'verify_only', deactivated_at = CURRENT_TIMESTAMP
+			WHERE state = 'active'`); err != nil {
+			return false, err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO signing_keys (kid, alg, private_key, state)
+		VALUES ($1, $2, $3, $4)`,
+		key.KID, key.Alg, key.PrivateKey, key.State); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Promote makes the pending key created before createdBefore the active one
+// and demotes the active key to verify-only, it returns the kid it promoted
+func (s *signingKeyRepo) Promote(ctx context.Context, createdBefore time.Time) (string, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('signing_keys'))`); err != nil {
+		return "", err
+	}
+
+	var kid string
+	err = tx.GetContext(ctx, &kid, `
+		SELECT kid FROM signing_keys
+		WHERE state = 'pending' AND created_at < $1
+		ORDER BY created_at
+		LIMIT 1`,
+		createdBefore)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE signing_keys
+		SET state = 'verify_only', deactivated_at = CURRENT_TIMESTAMP
+		WHERE state = 'active'`); err != nil {
+		return "", err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE signing_keys SET state = 'active' WHERE kid = $1`, kid); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+
+	return kid, nil
+}
+
+// Retire drops keys whose tokens have all expired, the sealed key material is wiped
+func (s *signingKeyRepo) Retire(ctx context.Context, deactivatedBefore time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE signing_keys
+		SET state = 'retired', retired_at = CURRENT_TIMESTAMP, private_key = ''
+		WHERE state = 'verify_only' AND deactivated_at < $1`,
+		deactivatedBefore)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
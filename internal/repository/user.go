@@ -12,7 +12,9 @@ import (
 type UserRepo interface {
 	GetById(ctx context.Context, id int) (*model.User, error)
 	GetByUsername(ctx context.Context, username string) (*model.User, error)
+	GetByEmail(ctx context.Context, email string) (*model.User, error)
 	GetMany(ctx context.Context, limit int, offset int) ([]model.User, error)
+	UpdateMaxSessions(ctx context.Context, id int, maxSessions *int) error
 }
 
 type userRepo struct {
@@ -58,6 +60,19 @@ func (s *userRepo) GetByUsername(ctx context.Context, username string) (*model.U
 	return &user, nil
 }
 
+func (s *userRepo) GetByEmail(ctx context.Context, email string) (*model.User, error) {
+	user := model.User{}
+	if err := s.db.GetContext(
+		ctx,
+		&user,
+		`SELECT * FROM users WHERE LOWER(email) = LOWER($1)`,
+		email,
+	); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
 func (s *userRepo) GetMany(ctx context.Context, limit int, offset int) ([]model.User, error) {
 	user := []model.User{}
 
@@ -76,3 +91,22 @@ func (s *userRepo) GetMany(ctx context.Context, limit int, offset int) ([]model.
 	}
 	return user, nil
 }
+
+func (s *userRepo) UpdateMaxSessions(ctx context.Context, id int, maxSessions *int) error {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE users SET max_sessions = $1 WHERE id = $2`,
+		maxSessions, id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
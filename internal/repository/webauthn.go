@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"auth/internal/model"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type WebAuthnRepo interface {
+	Create(ctx context.Context, credential model.WebAuthnCredential) (*model.WebAuthnCredential, error)
+	GetByUserId(ctx context.Context, userID int) ([]model.WebAuthnCredential, error)
+	GetByCredentialId(ctx context.Context, credentialID []byte) (*model.WebAuthnCredential, error)
+	UpdateSignCount(ctx context.Context, credentialID []byte, signCount int64, credential []byte) error
+	Delete(ctx context.Context, userID int, id int) error
+}
+
+type webAuthnRepo struct {
+	db *sqlx.DB
+}
+
+func NewWebAuthnRepo(db *sqlx.DB) *webAuthnRepo {
+	return &webAuthnRepo{db: db}
+}
+
+func (s *webAuthnRepo) Create(ctx context.Context, credential model.WebAuthnCredential) (*model.WebAuthnCredential, error) {
+	query := `
+		INSERT INTO webauthn_credentials (user_id, credential_id, name, credential, sign_count)
+		VALUES (:user_id, :credential_id, :name, :credential, :sign_count)
+		RETURNING *`
+
+	rows, err := s.db.NamedQueryContext(ctx, query, credential)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		if err := rows.StructScan(&credential); err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, fmt.Errorf("insert succeeded but returned no rows")
+	}
+
+	return &credential, nil
+}
+
+func (s *webAuthnRepo) GetByUserId(ctx context.Context, userID int) ([]model.WebAuthnCredential, error) {
+	credentials := []model.WebAuthnCredential{}
+	if err := s.db.SelectContext(
+		ctx,
+		&credentials,
+		`SELECT * FROM webauthn_credentials WHERE user_id = $1 ORDER BY created_at`,
+		userID,
+	); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+func (s *webAuthnRepo) GetByCredentialId(ctx context.Context, credentialID []byte) (*model.WebAuthnCredential, error) {
+	credential := model.WebAuthnCredential{}
+	if err := s.db.GetContext(
+		ctx,
+		&credential,
+		`SELECT * FROM webauthn_credentials WHERE credential_id = $1`,
+		credentialID,
+	); err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+// UpdateSignCount only moves the counter forward, two assertions racing with
+// the same counter cannot both pass. Authenticators without a counter always send 0.
+func (s *webAuthnRepo) UpdateSignCount(
+	ctx context.Context,
+	credentialID []byte,
+	signCount int64,
+	credential []byte,
+) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE webauthn_credentials
+		SET sign_count = $2, credential = $3, last_used_at = CURRENT_TIMESTAMP
+		WHERE credential_id = $1 AND (sign_count < $2 OR $2 = 0)`,
+		credentialID, signCount, credential)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (s *webAuthnRepo) Delete(ctx context.Context, userID int, id int) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockUser(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx,
+		`DELETE FROM webauthn_credentials WHERE user_id = $1 AND id = $2`,
+		userID, id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err := checkLoginMethodLeft(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
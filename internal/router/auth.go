@@ -11,4 +11,11 @@ func AuthRoutes(r chi.Router, auth controller.AuthController) {
 	r.Post("/login", auth.Login)
 	r.Post("/refresh", auth.RefreshToken)
 	r.Post("/logout", auth.Logout)
+	r.Post("/mfa/verify", auth.VerifyMFA)
+	r.Get("/verify-email", auth.VerifyEmail)
+	r.Post("/verify-email/resend", auth.ResendVerification)
+	r.Post("/password/forgot", auth.ForgotPassword)
+	r.Post("/password/reset", auth.ResetPassword)
+	r.Post("/magic-link", auth.RequestMagicLink)
+	r.Get("/magic-link/consume", auth.ConsumeMagicLink)
 }
@@ -0,0 +1,16 @@
+package router
+
+import (
+	"auth/internal/controller"
+	middlewares "auth/internal/middleware"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func IdentityRoutes(r chi.Router, identity controller.IdentityController) {
+	r.Use(middlewares.JwtAuth)
+
+	r.Get("/", identity.GetMany)
+	r.Post("/{provider}", identity.Link)
+	r.Delete("/{provider}", identity.Unlink)
+}
@@ -0,0 +1,23 @@
+package router
+
+import (
+	"auth/internal/controller"
+	middlewares "auth/internal/middleware"
+	"auth/internal/model"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func MFARoutes(r chi.Router, mfa controller.MFAController) {
+	r.Use(middlewares.JwtAuth)
+
+	r.Post("/totp", mfa.EnrollTOTP)
+	r.Post("/totp/confirm", mfa.ConfirmTOTP)
+}
+
+func AdminMFARoutes(r chi.Router, mfa controller.MFAController) {
+	r.Use(middlewares.JwtAuth)
+	r.Use(middlewares.RoleChecker(model.RoleAdmin))
+
+	r.Delete("/", mfa.Reset)
+}
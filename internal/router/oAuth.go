@@ -0,0 +1,12 @@
+package router
+
+import (
+	"auth/internal/controller"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func OAuthRoutes(r chi.Router, oauth controller.OAuthController) {
+	r.Get("/{provider}/login", oauth.Login)
+	r.Get("/{provider}/callback", oauth.Callback)
+}
@@ -0,0 +1,21 @@
+package router
+
+import (
+	"auth/internal/controller"
+	middlewares "auth/internal/middleware"
+	"auth/internal/model"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func OAuthClientRoutes(r chi.Router, client controller.OAuthClientController) {
+	r.Use(middlewares.JwtAuth)
+	r.Use(middlewares.RoleChecker(model.RoleAdmin))
+
+	r.Get("/", client.GetMany)
+	r.Post("/", client.Create)
+	r.Get("/{id}", client.GetByClientId)
+	r.Put("/{id}", client.Update)
+	r.Delete("/{id}", client.Delete)
+	r.Post("/{id}/secret", client.RotateSecret)
+}
@@ -0,0 +1,23 @@
+package router
+
+import (
+	"auth/internal/controller"
+	middlewares "auth/internal/middleware"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func OIDCRoutes(r chi.Router, oidc controller.OIDCController) {
+	r.Get("/authorize", oidc.Authorize)
+	r.Post("/token", oidc.Token)
+	r.Post("/introspect", oidc.Introspect)
+	r.Post("/revoke", oidc.Revoke)
+
+	r.Post("/device_authorization", oidc.DeviceAuthorization)
+	r.Get("/device", oidc.DevicePage)
+	r.Post("/device", oidc.DeviceDecide)
+
+	// tokens of oauth clients are what userinfo is for, the scope decides what it shows
+	r.With(middlewares.JwtAuthAny).Get("/userinfo", oidc.UserInfo)
+	r.With(middlewares.JwtAuthAny).Post("/userinfo", oidc.UserInfo)
+}
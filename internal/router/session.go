@@ -0,0 +1,26 @@
+package router
+
+import (
+	"auth/internal/controller"
+	middlewares "auth/internal/middleware"
+	"auth/internal/model"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func SessionRoutes(r chi.Router, session controller.SessionController) {
+	r.Use(middlewares.JwtAuth)
+
+	r.Get("/", session.GetMine)
+	r.Delete("/{sessionId}", session.RevokeMine)
+}
+
+// AdminSessionRoutes take admins and backend jobs holding the sessions:admin scope
+func AdminSessionRoutes(r chi.Router, session controller.SessionController) {
+	r.Use(middlewares.JwtAuthAny)
+	r.Use(middlewares.RoleOrScope([]model.Role{model.RoleAdmin}, model.ScopeSessionsAdmin))
+
+	r.Get("/", session.GetByUser)
+	r.Put("/limit", session.SetLimitByUser)
+	r.Delete("/{sessionId}", session.RevokeByUser)
+}
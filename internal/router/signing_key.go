@@ -0,0 +1,17 @@
+package router
+
+import (
+	"auth/internal/controller"
+	middlewares "auth/internal/middleware"
+	"auth/internal/model"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func SigningKeyRoutes(r chi.Router, signingKey controller.SigningKeyController) {
+	r.Use(middlewares.JwtAuth)
+	r.Use(middlewares.RoleChecker(model.RoleAdmin))
+
+	r.Get("/", signingKey.GetMany)
+	r.Post("/rotate", signingKey.Rotate)
+}
@@ -2,6 +2,8 @@ package router
 
 import (
 	"auth/internal/controller"
+	middlewares "auth/internal/middleware"
+	"auth/internal/model"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -11,6 +13,12 @@ func UserRoutes(r chi.Router, user controller.UserController) {
 	// r.Post("/login", user.Login)
 	r.Get("/", user.GetMany)
 	r.Get("/{id}", user.GetById)
+	r.With(middlewares.JwtAuth).Put("/me/password", user.ChangePassword)
+	r.With(middlewares.JwtAuth).Post("/me/logout-all", user.LogoutAll)
+	r.With(
+		middlewares.JwtAuthAny,
+		middlewares.RoleOrScope([]model.Role{model.RoleAdmin}, model.ScopeSessionsAdmin),
+	).Post("/{id}/logout-all", user.ForceLogout)
 	// r.Put("/{id}", user.Update)
 	// r.Delete("/{id}", user.Delete)
 }
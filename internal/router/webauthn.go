@@ -0,0 +1,29 @@
+package router
+
+import (
+	"auth/config"
+	"auth/internal/controller"
+	middlewares "auth/internal/middleware"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func WebAuthnRoutes(r chi.Router, webAuthn controller.WebAuthnController) {
+	r.Use(middlewares.JwtAuth)
+
+	r.Get("/", webAuthn.GetMany)
+	r.Group(func(r chi.Router) {
+		r.Use(middlewares.RequireRecentAuth(config.WebAuthnReauthMaxAge()))
+
+		r.Post("/register/begin", webAuthn.BeginRegistration)
+		r.Post("/register/finish", webAuthn.FinishRegistration)
+	})
+	r.Delete("/{id}", webAuthn.Delete)
+}
+
+func WebAuthnAuthRoutes(r chi.Router, webAuthn controller.WebAuthnController) {
+	r.Post("/login/begin", webAuthn.BeginLogin)
+	r.Post("/login/finish", webAuthn.FinishLogin)
+	r.Post("/mfa/begin", webAuthn.BeginMFA)
+	r.Post("/mfa/finish", webAuthn.FinishMFA)
+}
@@ -0,0 +1,12 @@
+package router
+
+import (
+	"auth/internal/controller"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func WellKnownRoutes(r chi.Router, wellKnown controller.WellKnownController) {
+	r.Get("/jwks.json", wellKnown.JWKS)
+	r.Get("/openid-configuration", wellKnown.OpenIDConfiguration)
+}
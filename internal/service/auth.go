@@ -2,10 +2,17 @@ package service
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"log"
+	"net/url"
+	"os"
 	"time"
 
+	"auth/config"
 	"auth/internal/helper"
+	"auth/internal/mail"
 	"auth/internal/model"
 	"auth/internal/repository"
 
@@ -13,24 +20,51 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+var (
+	ErrEmailTaken    = errors.New("email already registered")
+	ErrRateLimited   = errors.New("too many requests, try again later")
+	ErrWrongPassword = errors.New("wrong password")
+)
+
+const (
+	verificationResendLimit  = 3
+	verificationResendWindow = 15 * time.Minute
+
+	passwordResetLimit  = 3
+	passwordResetWindow = 15 * time.Minute
+
+	magicLinkLimit  = 3
+	magicLinkWindow = 15 * time.Minute
+)
+
 type AuthService interface {
 	Create(ctx context.Context, user model.User) (*model.User, error)
 	Login(ctx context.Context, user model.User) (*model.User, string, string, error)
 	RefreshToken(ctx context.Context, refreshToken string) (string, string, error)
+	VerifyEmail(ctx context.Context, token string) error
+	ResendVerification(ctx context.Context, email string) error
+	ForgotPassword(ctx context.Context, email string) error
+	ResetPassword(ctx context.Context, token string, password string) error
+	// RequestMagicLink returns the browser binding for the cookie, also when no mail was sent
+	RequestMagicLink(ctx context.Context, email string) (string, error)
+	ConsumeMagicLink(ctx context.Context, token string, binding string) (*model.User, string, string, error)
 }
 
 type authService struct {
 	repo        repository.Repository
 	redisClient *redis.Client
+	mail        *mail.Queue
 }
 
 func NewAuthService(
 	repo repository.Repository,
 	redisClient *redis.Client,
+	mailQueue *mail.Queue,
 ) AuthService {
 	return &authService{
 		repo:        repo,
 		redisClient: redisClient,
+		mail:        mailQueue,
 	}
 }
 
@@ -46,6 +80,27 @@ func (h *authService) Create(ctx context.Context, user model.User) (*model.User,
 		return nil, fmt.Errorf("Name cannot contain name")
 	}
 
+	if err := helper.ValidatePassword(user.Password); err != nil {
+		return nil, err
+	}
+
+	// the email is optional, a given one must be valid and unused
+	r := h.repo.Auth()
+	var email *string
+	if user.Email != nil {
+		normalized := helper.NormalizeEmail(*user.Email)
+		if !helper.IsValidEmail(normalized) {
+			return nil, fmt.Errorf("a valid email is required")
+		}
+
+		if err := r.VerifyEmail(ctx, normalized, 0); err == nil {
+			return nil, ErrEmailTaken
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed checking email: %w", err)
+		}
+		email = &normalized
+	}
+
 	password := user.Password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
@@ -56,13 +111,21 @@ func (h *authService) Create(ctx context.Context, user model.User) (*model.User,
 		Name:     user.Name,
 		Password: string(hashedPassword),
 		Username: user.Username,
+		Email:    email,
 	}
 
-	r := h.repo.Auth()
 	res, err := r.Create(ctx, registerData)
 	if err != nil {
 		return nil, fmt.Errorf("failed create user: %w", err)
 	}
+
+	// the account exists either way, a lost mail can be resent
+	if res.Email != nil {
+		if err := h.sendVerificationEmail(ctx, *res); err != nil {
+			log.Printf("failed sending verification email to user %d: %v", res.ID, err)
+		}
+	}
+
 	return res, nil
 }
 
@@ -78,17 +141,39 @@ func (h *authService) Login(ctx context.Context, user model.User) (*model.User,
 		return nil, "", "", fmt.Errorf("wrong password")
 	}
 
-	refreshToken, err := helper.CreateRefreshToken(ctx, user, h.redisClient, &time.Time{})
+	refreshToken, token, err := completeLogin(ctx, h.repo, h.redisClient, *res)
 	if err != nil {
-		return nil, "", "", fmt.Errorf("user not found: %w", err)
+		return nil, "", "", err
+	}
+
+	return res, refreshToken, token, nil
+}
+
+// issueTokens creates the refresh/access pair for an authenticated user
+func issueTokens(
+	ctx context.Context,
+	user model.User,
+	rdb *redis.Client,
+) (string, string, error) {
+	if _, ok := helper.DPoPProofFromContext(ctx); helper.DPoPRequired() && !ok {
+		return "", "", helper.ErrDPoPRequired
 	}
 
-	token, err := helper.CreateAccessToken(*res)
+	// every caller just checked a credential of the user
+	ctx = helper.WithAuthTime(ctx, time.Now())
+
+	refreshToken, err := helper.CreateRefreshToken(ctx, user, rdb)
 	if err != nil {
-		return nil, "", "", fmt.Errorf("user not found: %w", err)
+		return "", "", fmt.Errorf("failed creating refresh token: %w", err)
 	}
+	ctx = helper.WithRefreshSession(ctx, refreshToken)
 
-	return res, refreshToken, token, nil
+	token, err := helper.CreateAccessToken(ctx, user)
+	if err != nil {
+		return "", "", fmt.Errorf("failed creating access token: %w", err)
+	}
+
+	return refreshToken, token, nil
 }
 
 // generate new token here
@@ -96,6 +181,10 @@ func (h *authService) RefreshToken(
 	ctx context.Context,
 	refreshToken string,
 ) (string, string, error) {
+	if _, ok := helper.DPoPProofFromContext(ctx); helper.DPoPRequired() && !ok {
+		return "", "", helper.ErrDPoPRequired
+	}
+
 	refreshClaims, err := helper.ValidateRefreshToken(
 		ctx,
 		refreshToken,
@@ -111,12 +200,19 @@ func (h *authService) RefreshToken(
 		Role:     model.Role(refreshClaims.Role),
 	}
 
-	newAccessToken, err := helper.CreateAccessToken(user)
+	// a refresh is no new sign in, the access token keeps the login's auth_time
+	if refreshClaims.AuthTime != nil {
+		ctx = helper.WithAuthTime(ctx, refreshClaims.AuthTime.Time)
+	}
+
+	// rotate first, of two requests racing with one token only one gets an access token
+	newRefreshToken, err := helper.RefreshRotation(ctx, refreshToken, user, h.redisClient)
 	if err != nil {
 		return "", "", err
 	}
+	ctx = helper.WithRefreshSession(ctx, newRefreshToken)
 
-	newRefreshToken, err := helper.RefreshRotation(ctx, refreshToken, user, h.redisClient)
+	newAccessToken, err := helper.CreateAccessToken(ctx, user)
 	if err != nil {
 		return "", "", err
 	}
@@ -124,12 +220,281 @@ func (h *authService) RefreshToken(
 	return newRefreshToken, newAccessToken, nil
 }
 
+// Logout revokes the refresh token and, when the client sent it,
+// the access token so it stops working before it expires
 func (h *authService) Logout(
 	ctx context.Context,
 	refreshToken string,
+	accessToken string,
 ) error {
 	if err := helper.RevokeRefreshToken(refreshToken, h.redisClient); err != nil {
 		return err
 	}
+
+	if accessToken != "" {
+		if claims, err := helper.ValidateAccessToken(ctx, accessToken); err == nil {
+			if err := helper.RevokeAccessToken(ctx, claims, h.redisClient); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (h *authService) VerifyEmail(ctx context.Context, token string) error {
+	if token == "" {
+		return fmt.Errorf("verification token is required")
+	}
+
+	claims, err := helper.ConsumeEmailVerificationToken(ctx, token, h.redisClient)
+	if err != nil {
+		return err
+	}
+
+	// the email may have changed since the link was sent
+	r := h.repo.Auth()
+	if err := r.MarkEmailVerified(ctx, claims.UserID, claims.Email); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("verification link does not match the account email")
+		}
+		return fmt.Errorf("failed verifying email: %w", err)
+	}
+
 	return nil
 }
+
+// ResendVerification never tells whether the email belongs to an account
+func (h *authService) ResendVerification(ctx context.Context, email string) error {
+	email = helper.NormalizeEmail(email)
+	if !helper.IsValidEmail(email) {
+		return fmt.Errorf("a valid email is required")
+	}
+
+	allowed, err := helper.AllowRate(
+		ctx,
+		h.redisClient,
+		"verify-email:"+email,
+		verificationResendLimit,
+		verificationResendWindow,
+	)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return ErrRateLimited
+	}
+
+	rU := h.repo.User()
+	user, err := rU.GetByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return fmt.Errorf("failed getting user: %w", err)
+	}
+	if user.EmailVerified {
+		return nil
+	}
+
+	return h.sendVerificationEmail(ctx, *user)
+}
+
+// ForgotPassword answers the same way whether the account exists or not,
+// a rate limited request is silently dropped for the same reason
+func (h *authService) ForgotPassword(ctx context.Context, email string) error {
+	email = helper.NormalizeEmail(email)
+	if !helper.IsValidEmail(email) {
+		return fmt.Errorf("a valid email is required")
+	}
+
+	allowed, err := helper.AllowRate(
+		ctx,
+		h.redisClient,
+		"password-reset:"+email,
+		passwordResetLimit,
+		passwordResetWindow,
+	)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return nil
+	}
+
+	rU := h.repo.User()
+	user, err := rU.GetByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return fmt.Errorf("failed getting user: %w", err)
+	}
+
+	token, err := helper.CreatePasswordResetToken(ctx, user.ID, h.redisClient)
+	if err != nil {
+		return err
+	}
+
+	expiry, err := helper.PasswordResetExpiry()
+	if err != nil {
+		return err
+	}
+
+	resetURL := os.Getenv("PASSWORD_RESET_URL")
+	if resetURL == "" {
+		resetURL = config.BaseURL() + "/auth/password/reset"
+	}
+
+	return h.mail.SendTemplate(ctx, "password_reset", email, map[string]any{
+		"Name":   user.Name,
+		"Link":   resetURL + "?token=" + url.QueryEscape(token),
+		"Expiry": expiry,
+	})
+}
+
+func (h *authService) ResetPassword(ctx context.Context, token string, password string) error {
+	if token == "" {
+		return fmt.Errorf("reset token is required")
+	}
+	if err := helper.ValidatePassword(password); err != nil {
+		return err
+	}
+
+	userID, err := helper.ConsumePasswordResetToken(ctx, token, h.redisClient)
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hashing password: %w", err)
+	}
+
+	r := h.repo.Auth()
+	if err := r.UpdatePassword(ctx, userID, string(hashedPassword)); err != nil {
+		return fmt.Errorf("failed updating password: %w", err)
+	}
+
+	// whoever knew the old password must lose every session and access token
+	if err := helper.RevokeUserTokens(ctx, userID, h.redisClient); err != nil {
+		return fmt.Errorf("failed revoking sessions: %w", err)
+	}
+
+	return nil
+}
+
+func (h *authService) sendVerificationEmail(ctx context.Context, user model.User) error {
+	token, err := helper.CreateEmailVerificationToken(ctx, user, h.redisClient)
+	if err != nil {
+		return err
+	}
+
+	expiry, err := helper.EmailVerificationExpiry()
+	if err != nil {
+		return err
+	}
+
+	link := config.BaseURL() + "/auth/verify-email?token=" + url.QueryEscape(token)
+
+	return h.mail.SendTemplate(ctx, "verify_email", *user.Email, map[string]any{
+		"Name":   user.Name,
+		"Link":   link,
+		"Expiry": expiry,
+	})
+}
+
+// RequestMagicLink answers the same way whether or not the email has an account
+func (h *authService) RequestMagicLink(ctx context.Context, email string) (string, error) {
+	email = helper.NormalizeEmail(email)
+	if !helper.IsValidEmail(email) {
+		return "", fmt.Errorf("a valid email is required")
+	}
+
+	binding, err := helper.CreateMagicLinkBinding()
+	if err != nil {
+		return "", err
+	}
+
+	allowed, err := helper.AllowRate(
+		ctx,
+		h.redisClient,
+		"magic-link:"+email,
+		magicLinkLimit,
+		magicLinkWindow,
+	)
+	if err != nil {
+		return "", err
+	}
+	if !allowed {
+		return binding, nil
+	}
+
+	rU := h.repo.User()
+	user, err := rU.GetByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return binding, nil
+		}
+		return "", fmt.Errorf("failed getting user: %w", err)
+	}
+
+	token, err := helper.CreateMagicLinkToken(ctx, user.ID, binding, h.redisClient)
+	if err != nil {
+		return "", err
+	}
+
+	expiry, err := helper.MagicLinkExpiry()
+	if err != nil {
+		return "", err
+	}
+
+	linkURL := os.Getenv("MAGIC_LINK_URL")
+	if linkURL == "" {
+		linkURL = config.BaseURL() + "/auth/magic-link/consume"
+	}
+
+	if err := h.mail.SendTemplate(ctx, "magic_link", email, map[string]any{
+		"Name":   user.Name,
+		"Link":   linkURL + "?token=" + url.QueryEscape(token),
+		"Expiry": expiry,
+	}); err != nil {
+		return "", err
+	}
+
+	return binding, nil
+}
+
+// ConsumeMagicLink is a first factor like the password, mfa users still get a challenge
+func (h *authService) ConsumeMagicLink(
+	ctx context.Context,
+	token string,
+	binding string,
+) (*model.User, string, string, error) {
+	userID, err := helper.ConsumeMagicLinkToken(ctx, token, binding, h.redisClient)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	rU := h.repo.User()
+	user, err := rU.GetById(ctx, userID)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed getting user: %w", err)
+	}
+
+	// opening the link proves the inbox belongs to the user
+	if !user.EmailVerified && user.Email != nil {
+		r := h.repo.Auth()
+		err := r.MarkEmailVerified(ctx, user.ID, *user.Email)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, "", "", fmt.Errorf("failed verifying email: %w", err)
+		}
+		user.EmailVerified = err == nil
+	}
+
+	refreshToken, accessToken, err := completeLogin(ctx, h.repo, h.redisClient, *user)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	return user, refreshToken, accessToken, nil
+}
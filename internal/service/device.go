@@ -0,0 +1,161 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"auth/config"
+	"auth/internal/helper"
+	"auth/internal/model"
+)
+
+var (
+	ErrDeviceCodeNotFound = helper.ErrDeviceCodeNotFound
+	ErrDeviceCodeUsed     = helper.ErrDeviceCodeUsed
+)
+
+func deviceVerificationURI() string {
+	return config.BaseURL() + "/oauth/device"
+}
+
+// DeviceAuthorization starts RFC 8628, the device shows the user code and polls the token endpoint
+func (h *oidcService) DeviceAuthorization(
+	ctx context.Context,
+	req model.DeviceAuthorizationRequest,
+) (*model.DeviceAuthorizationResponse, error) {
+	client, err := h.authenticateClient(ctx, req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	if !slices.Contains(client.GrantTypes, GrantDeviceCode) {
+		return nil, oauthError("unauthorized_client", "the client may not use the device_code grant")
+	}
+	if err := checkScope(client, req.Scope); err != nil {
+		return nil, err
+	}
+	if hasScope(req.Scope, "openid") && helper.SigningAlg() == helper.AlgHS256 {
+		return nil, oauthError("server_error", "id tokens need an asymmetric JWT_SIGNING_ALG")
+	}
+
+	lifetime, err := helper.DeviceCodeExpiry()
+	if err != nil {
+		return nil, err
+	}
+
+	deviceCode, userCode, err := helper.CreateDeviceCode(ctx, client.ClientID, req.Scope, h.redisClient)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating device code: %w", err)
+	}
+
+	userCode = helper.FormatUserCode(userCode)
+
+	return &model.DeviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationURI:         deviceVerificationURI(),
+		VerificationURIComplete: redirectWith(deviceVerificationURI(), url.Values{"user_code": {userCode}}),
+		ExpiresIn:               int(lifetime.Seconds()),
+		Interval:                int(helper.DevicePollInterval.Seconds()),
+	}, nil
+}
+
+// DeviceLoginURL is where the verification page sends a visitor without a session,
+// empty when OIDC_LOGIN_URL is not set
+func (h *oidcService) DeviceLoginURL(userCode string) string {
+	loginURL := os.Getenv("OIDC_LOGIN_URL")
+	if loginURL == "" {
+		return ""
+	}
+
+	returnTo := deviceVerificationURI()
+	if userCode != "" {
+		returnTo = redirectWith(returnTo, url.Values{"user_code": {userCode}})
+	}
+	return redirectWith(loginURL, url.Values{"return_to": {returnTo}})
+}
+
+func (h *oidcService) DevicePrompt(ctx context.Context, userCode string, session string) (*model.DevicePrompt, error) {
+	if _, err := h.sessionUser(ctx, session); err != nil {
+		return nil, err
+	}
+
+	code, err := helper.GetDeviceCodeByUserCode(ctx, userCode, h.redisClient)
+	if err != nil {
+		return nil, err
+	}
+	if code.Status != helper.DeviceCodePending {
+		return nil, ErrDeviceCodeUsed
+	}
+
+	client, err := h.client(ctx, code.ClientID)
+	if err != nil {
+		return nil, err
+	}
+
+	name := client.Name
+	if name == "" {
+		name = client.ClientID
+	}
+
+	return &model.DevicePrompt{
+		UserCode:   helper.FormatUserCode(code.UserCode),
+		ClientName: name,
+		Scopes:     strings.Fields(code.Scope),
+	}, nil
+}
+
+func (h *oidcService) DecideDevice(ctx context.Context, userCode string, approve bool, session string) error {
+	user, err := h.sessionUser(ctx, session)
+	if err != nil {
+		return err
+	}
+
+	return helper.DecideDeviceCode(ctx, userCode, approve, user.ID, h.redisClient)
+}
+
+// deviceToken answers one poll, authorization_pending until the user decides
+func (h *oidcService) deviceToken(
+	ctx context.Context,
+	client *model.OAuthClient,
+	req model.TokenRequest,
+) (*model.TokenResponse, error) {
+	if req.DeviceCode == "" {
+		return nil, oauthError("invalid_request", "device_code is required")
+	}
+
+	code, slow, err := helper.PollDeviceCode(ctx, req.DeviceCode, h.redisClient)
+	if err != nil {
+		if errors.Is(err, helper.ErrDeviceCodeNotFound) {
+			return nil, oauthError("expired_token", "device code invalid or expired")
+		}
+		return nil, err
+	}
+
+	if code.ClientID != client.ClientID {
+		return nil, oauthError("invalid_grant", "device code was issued to another client")
+	}
+	if slow {
+		return nil, oauthError("slow_down", "polling too fast, wait longer between requests")
+	}
+
+	switch code.Status {
+	case helper.DeviceCodePending:
+		return nil, oauthError("authorization_pending", "the user has not answered yet")
+	case helper.DeviceCodeDenied:
+		return nil, oauthError("access_denied", "the user denied the request")
+	}
+
+	rU := h.repo.User()
+	user, err := rU.GetById(ctx, code.UserID)
+	if err != nil {
+		return nil, oauthError("invalid_grant", "user no longer exists")
+	}
+
+	return h.grantUser(ctx, client, *user, code.Scope, "")
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"auth/internal/helper"
+	"auth/internal/model"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func testDeviceClient(clientID string) *model.OAuthClient {
+	return &model.OAuthClient{
+		ClientID:   clientID,
+		GrantTypes: []string{GrantDeviceCode},
+		Scopes:     []string{"openid"},
+	}
+}
+
+func pollDevice(s *oidcService, clientID string, deviceCode string) error {
+	_, err := s.deviceToken(context.Background(), testDeviceClient(clientID), model.TokenRequest{
+		GrantType:  GrantDeviceCode,
+		DeviceCode: deviceCode,
+	})
+	return err
+}
+
+func newDeviceCode(t *testing.T, rdb *redis.Client) (string, string) {
+	t.Helper()
+
+	deviceCode, userCode, err := helper.CreateDeviceCode(context.Background(), "tv", "openid", rdb)
+	if err != nil {
+		t.Fatalf("CreateDeviceCode: %v", err)
+	}
+	return deviceCode, userCode
+}
+
+func TestDeviceTokenPolling(t *testing.T) {
+	t.Setenv("DEVICE_CODE_EXPIRED", "")
+	rdb := newTestRedis(t)
+	s := &oidcService{redisClient: rdb}
+	deviceCode, _ := newDeviceCode(t, rdb)
+
+	wantOAuthError(t, pollDevice(s, "tv", deviceCode), "authorization_pending")
+	wantOAuthError(t, pollDevice(s, "tv", deviceCode), "slow_down")
+	wantOAuthError(t, pollDevice(s, "tv", ""), "invalid_request")
+	wantOAuthError(t, pollDevice(s, "tv", "made-up"), "expired_token")
+}
+
+// another client cannot collect the tokens of a code it did not start
+func TestDeviceTokenOtherClient(t *testing.T) {
+	t.Setenv("DEVICE_CODE_EXPIRED", "")
+	ctx := context.Background()
+	rdb := newTestRedis(t)
+	s := &oidcService{redisClient: rdb}
+	deviceCode, userCode := newDeviceCode(t, rdb)
+
+	if err := helper.DecideDeviceCode(ctx, userCode, true, 42, rdb); err != nil {
+		t.Fatalf("DecideDeviceCode: %v", err)
+	}
+	wantOAuthError(t, pollDevice(s, "other", deviceCode), "invalid_grant")
+}
+
+func TestDeviceTokenDenied(t *testing.T) {
+	t.Setenv("DEVICE_CODE_EXPIRED", "")
+	ctx := context.Background()
+	rdb := newTestRedis(t)
+	s := &oidcService{redisClient: rdb}
+	deviceCode, userCode := newDeviceCode(t, rdb)
+
+	if err := helper.DecideDeviceCode(ctx, userCode, false, 42, rdb); err != nil {
+		t.Fatalf("DecideDeviceCode: %v", err)
+	}
+	wantOAuthError(t, pollDevice(s, "tv", deviceCode), "access_denied")
+
+	// the answer is given once, the code is gone after it
+	wantOAuthError(t, pollDevice(s, "tv", deviceCode), "expired_token")
+}
+
+func TestDecideDeviceNeedsSession(t *testing.T) {
+	s := &oidcService{redisClient: newTestRedis(t)}
+
+	wantOAuthError(t, s.DecideDevice(context.Background(), "BCDF-GHJK", true, ""), "login_required")
+	_, err := s.DevicePrompt(context.Background(), "BCDF-GHJK", "not-a-session")
+	wantOAuthError(t, err, "login_required")
+}
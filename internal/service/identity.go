@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"auth/internal/model"
+	"auth/internal/repository"
+)
+
+var ErrIdentityNotFound = errors.New("provider is not linked to this account")
+
+type IdentityService interface {
+	GetByUserId(ctx context.Context, userID int) ([]model.UserIdentity, error)
+	Unlink(ctx context.Context, userID int, provider string) error
+}
+
+type identityService struct {
+	repo repository.Repository
+}
+
+func NewIdentityService(repo repository.Repository) IdentityService {
+	return &identityService{repo: repo}
+}
+
+func (h *identityService) GetByUserId(ctx context.Context, userID int) ([]model.UserIdentity, error) {
+	r := h.repo.Identity()
+	res, err := r.GetByUserId(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting identities: %w", err)
+	}
+	return res, nil
+}
+
+func (h *identityService) Unlink(ctx context.Context, userID int, provider string) error {
+	r := h.repo.Identity()
+	if err := r.Delete(ctx, userID, provider); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrIdentityNotFound
+		}
+		if errors.Is(err, repository.ErrLastLoginMethod) {
+			return err
+		}
+		return fmt.Errorf("failed unlinking identity: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,268 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"auth/internal/helper"
+	"auth/internal/model"
+	"auth/internal/repository"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/skip2/go-qrcode"
+)
+
+const recoveryCodeCount = 10
+
+var (
+	ErrInvalidMFACode = errors.New("invalid two-factor code")
+	ErrMFANotEnrolled = errors.New("two-factor enrollment not started")
+)
+
+// MFARequiredError is returned instead of tokens when the password was right
+// but the account has a second factor, Token is the mfa_pending token
+// and Methods lists the factors the user can answer with
+type MFARequiredError struct {
+	Token   string
+	Methods []string
+}
+
+func (e *MFARequiredError) Error() string {
+	return "two-factor authentication required"
+}
+
+type MFAService interface {
+	EnrollTOTP(ctx context.Context, userID int) (*model.TOTPEnrollment, error)
+	ConfirmTOTP(ctx context.Context, userID int, code string) ([]string, error)
+	Verify(ctx context.Context, body model.MFAVerify) (*model.User, string, string, error)
+	Reset(ctx context.Context, userID int) error
+}
+
+type mfaService struct {
+	repo        repository.Repository
+	redisClient *redis.Client
+}
+
+func NewMFAService(
+	repo repository.Repository,
+	redisClient *redis.Client,
+) MFAService {
+	return &mfaService{
+		repo:        repo,
+		redisClient: redisClient,
+	}
+}
+
+// completeLogin runs after the first factor passed, it either issues the
+// token pair or asks for the second factor with an MFARequiredError
+func completeLogin(
+	ctx context.Context,
+	repo repository.Repository,
+	rdb *redis.Client,
+	user model.User,
+) (string, string, error) {
+	methods := []string{}
+
+	r := repo.MFA()
+	totp, err := r.GetTOTP(ctx, user.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", "", fmt.Errorf("failed getting mfa: %w", err)
+	}
+	if err == nil && totp.ConfirmedAt != nil {
+		methods = append(methods, "totp")
+	}
+
+	rW := repo.WebAuthn()
+	passkeys, err := rW.GetByUserId(ctx, user.ID)
+	if err != nil {
+		return "", "", fmt.Errorf("failed getting passkeys: %w", err)
+	}
+	if len(passkeys) > 0 {
+		methods = append(methods, "webauthn")
+	}
+
+	if len(methods) > 0 {
+		token, err := helper.CreateMFAPendingToken(ctx, user.ID, rdb)
+		if err != nil {
+			return "", "", err
+		}
+		return "", "", &MFARequiredError{Token: token, Methods: methods}
+	}
+
+	return issueTokens(ctx, user, rdb)
+}
+
+func (h *mfaService) EnrollTOTP(ctx context.Context, userID int) (*model.TOTPEnrollment, error) {
+	rU := h.repo.User()
+	user, err := rU.GetById(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting user: %w", err)
+	}
+
+	secret, err := helper.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := helper.EncryptSecret([]byte(secret))
+	if err != nil {
+		return nil, err
+	}
+
+	r := h.repo.MFA()
+	if err := r.UpsertTOTP(ctx, userID, sealed); err != nil {
+		if errors.Is(err, repository.ErrMFAEnabled) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed saving totp secret: %w", err)
+	}
+
+	account := user.Username
+	if user.Email != nil {
+		account = *user.Email
+	}
+	uri := helper.TOTPURI(account, secret)
+
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating qr code: %w", err)
+	}
+
+	return &model.TOTPEnrollment{
+		Secret: secret,
+		URI:    uri,
+		QRCode: png,
+	}, nil
+}
+
+// ConfirmTOTP turns mfa on with the first valid code and returns the recovery
+// codes, they are only stored hashed so this is the one time they are shown
+func (h *mfaService) ConfirmTOTP(ctx context.Context, userID int, code string) ([]string, error) {
+	r := h.repo.MFA()
+	totp, err := r.GetTOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrMFANotEnrolled
+		}
+		return nil, fmt.Errorf("failed getting totp: %w", err)
+	}
+	if totp.ConfirmedAt != nil {
+		return nil, repository.ErrMFAEnabled
+	}
+
+	secret, err := helper.DecryptSecret(totp.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	step, ok := helper.ValidateTOTP(string(secret), code, time.Now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, hashes, err := helper.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.ConfirmTOTP(ctx, userID, step, hashes); err != nil {
+		if errors.Is(err, repository.ErrMFAEnabled) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed confirming totp: %w", err)
+	}
+
+	return codes, nil
+}
+
+func (h *mfaService) Verify(ctx context.Context, body model.MFAVerify) (*model.User, string, string, error) {
+	claims, err := helper.ValidateMFAPendingToken(ctx, body.MFAToken, h.redisClient)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	if err := h.checkSecondFactor(ctx, claims.UserID, body); err != nil {
+		if errors.Is(err, ErrInvalidMFACode) {
+			if failErr := helper.FailMFAPendingToken(ctx, claims, h.redisClient); failErr != nil {
+				return nil, "", "", failErr
+			}
+		}
+		return nil, "", "", err
+	}
+
+	consumed, err := helper.ConsumeMFAPendingToken(ctx, claims, h.redisClient)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if !consumed {
+		return nil, "", "", fmt.Errorf("mfa token expired or already used")
+	}
+
+	rU := h.repo.User()
+	user, err := rU.GetById(ctx, claims.UserID)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed getting user: %w", err)
+	}
+
+	ctx = helper.WithRememberMe(ctx, claims.RememberMe)
+	refreshToken, token, err := issueTokens(ctx, *user, h.redisClient)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	return user, refreshToken, token, nil
+}
+
+func (h *mfaService) checkSecondFactor(ctx context.Context, userID int, body model.MFAVerify) error {
+	r := h.repo.MFA()
+
+	if body.RecoveryCode != "" {
+		if err := r.UseRecoveryCode(ctx, userID, helper.HashRecoveryCode(body.RecoveryCode)); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrInvalidMFACode
+			}
+			return fmt.Errorf("failed using recovery code: %w", err)
+		}
+		return nil
+	}
+
+	totp, err := r.GetTOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrMFANotEnrolled
+		}
+		return fmt.Errorf("failed getting totp: %w", err)
+	}
+
+	secret, err := helper.DecryptSecret(totp.Secret)
+	if err != nil {
+		return err
+	}
+
+	step, ok := helper.ValidateTOTP(string(secret), body.Code, time.Now())
+	if !ok {
+		return ErrInvalidMFACode
+	}
+
+	if err := r.UseTOTPStep(ctx, userID, step); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvalidMFACode
+		}
+		return fmt.Errorf("failed saving totp step: %w", err)
+	}
+
+	return nil
+}
+
+// Reset is the admin escape hatch for a user who lost the authenticator
+// and the recovery codes
+func (h *mfaService) Reset(ctx context.Context, userID int) error {
+	r := h.repo.MFA()
+	if err := r.Delete(ctx, userID); err != nil {
+		return fmt.Errorf("failed resetting mfa: %w", err)
+	}
+	return nil
+}
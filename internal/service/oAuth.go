@@ -0,0 +1,337 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"auth/internal/helper"
+	"auth/internal/model"
+	"auth/internal/repository"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/oauth2"
+)
+
+// OAuthStateTTL is how long a started provider login can be finished
+const OAuthStateTTL = 10 * time.Minute
+
+var (
+	ErrIdentityLinked    = errors.New("provider account is already linked to another user")
+	ErrOAuthStateBinding = errors.New("oauth login must be finished in the browser that started it")
+)
+
+type OAuthService interface {
+	// AuthURL also returns the browser binding, the callback only
+	// accepts the state together with it
+	AuthURL(ctx context.Context, provider string) (string, string, error)
+	LinkURL(ctx context.Context, provider string, userID int) (string, string, error)
+	// Callback returns empty tokens when the state belongs to an account link
+	Callback(ctx context.Context, provider string, state string, code string, binding string) (*model.User, string, string, error)
+}
+
+type oAuthService struct {
+	repo        repository.Repository
+	redisClient *redis.Client
+	providers   *OAuthRegistry
+}
+
+func NewOAuthService(
+	repo repository.Repository,
+	redisClient *redis.Client,
+	providers *OAuthRegistry,
+) OAuthService {
+	return &oAuthService{
+		repo:        repo,
+		redisClient: redisClient,
+		providers:   providers,
+	}
+}
+
+type oauthState struct {
+	Provider   string `json:"provider"`
+	Verifier   string `json:"verifier"`
+	Nonce      string `json:"nonce"`
+	LinkUserID int    `json:"link_user_id,omitempty"`
+	// hash of the random value kept in the cookie of the browser that started
+	// the flow, a callback opened anywhere else is refused
+	Binding string `json:"binding,omitempty"`
+}
+
+func oauthStateKey(state string) string {
+	return "oauth:state:" + state
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (h *oAuthService) AuthURL(ctx context.Context, provider string) (string, string, error) {
+	return h.authURL(ctx, provider, 0)
+}
+
+// LinkURL starts the same flow for a logged in user,
+// the callback then links the provider account instead of logging in.
+// The binding keeps anyone else from finishing the link with their own account.
+func (h *oAuthService) LinkURL(ctx context.Context, provider string, userID int) (string, string, error) {
+	if userID == 0 {
+		return "", "", fmt.Errorf("user id is required")
+	}
+	return h.authURL(ctx, provider, userID)
+}
+
+func (h *oAuthService) authURL(ctx context.Context, provider string, linkUserID int) (string, string, error) {
+	p, err := h.providers.get(provider)
+	if err != nil {
+		return "", "", err
+	}
+
+	cfg, err := p.oauth2Config(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	binding, err := randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	data := oauthState{
+		Provider:   p.cfg.Name,
+		Verifier:   oauth2.GenerateVerifier(),
+		Nonce:      nonce,
+		LinkUserID: linkUserID,
+		Binding:    helper.HashToken(binding),
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := h.redisClient.Set(ctx, oauthStateKey(state), raw, OAuthStateTTL).Err(); err != nil {
+		return "", "", fmt.Errorf("failed storing oauth state: %w", err)
+	}
+
+	url := cfg.AuthCodeURL(
+		state,
+		oauth2.S256ChallengeOption(data.Verifier),
+		oidc.Nonce(data.Nonce),
+	)
+
+	return url, binding, nil
+}
+
+func (h *oAuthService) Callback(
+	ctx context.Context,
+	provider string,
+	state string,
+	code string,
+	binding string,
+) (*model.User, string, string, error) {
+	name, data, account, err := h.resolve(ctx, provider, state, code, binding)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	if data.LinkUserID != 0 {
+		user, err := h.link(ctx, data.LinkUserID, name, account)
+		if err != nil {
+			return nil, "", "", err
+		}
+		return user, "", "", nil
+	}
+
+	user, err := h.findOrCreateUser(ctx, name, account)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	refreshToken, token, err := completeLogin(ctx, h.repo, h.redisClient, *user)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	return user, refreshToken, token, nil
+}
+
+// resolve burns the state, checks it was started by this browser and trades
+// the code for the provider account
+func (h *oAuthService) resolve(
+	ctx context.Context,
+	provider string,
+	state string,
+	code string,
+	binding string,
+) (string, *oauthState, *externalUser, error) {
+	p, err := h.providers.get(provider)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	if state == "" || code == "" {
+		return "", nil, nil, fmt.Errorf("missing state or code")
+	}
+
+	// state is single use, GETDEL makes a replayed callback fail
+	raw, err := h.redisClient.GetDel(ctx, oauthStateKey(state)).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return "", nil, nil, fmt.Errorf("invalid or expired oauth state")
+		}
+		return "", nil, nil, err
+	}
+
+	data := oauthState{}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return "", nil, nil, err
+	}
+	if data.Provider != p.cfg.Name {
+		return "", nil, nil, fmt.Errorf("oauth state was issued for another provider")
+	}
+	if binding == "" || data.Binding != helper.HashToken(binding) {
+		return "", nil, nil, ErrOAuthStateBinding
+	}
+
+	cfg, err := p.oauth2Config(ctx)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	oauthToken, err := cfg.Exchange(ctx, code, oauth2.VerifierOption(data.Verifier))
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("failed exchanging code: %w", err)
+	}
+
+	claims, err := p.claims(ctx, cfg, oauthToken, data.Nonce)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	account, err := p.mapUser(claims)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	return p.cfg.Name, &data, account, nil
+}
+
+func (h *oAuthService) link(
+	ctx context.Context,
+	userID int,
+	provider string,
+	account *externalUser,
+) (*model.User, error) {
+	rI := h.repo.Identity()
+	existing, err := rI.GetByProviderSubject(ctx, provider, account.Subject)
+	if err == nil {
+		if existing.UserID != userID {
+			return nil, ErrIdentityLinked
+		}
+	} else if errors.Is(err, sql.ErrNoRows) {
+		if _, err := rI.Create(ctx, model.UserIdentity{
+			UserID:   userID,
+			Provider: provider,
+			Subject:  account.Subject,
+			Email:    optionalString(account.Email),
+		}); err != nil {
+			return nil, fmt.Errorf("failed linking %s: %w", provider, err)
+		}
+	} else {
+		return nil, fmt.Errorf("failed getting identity: %w", err)
+	}
+
+	rU := h.repo.User()
+	res, err := rU.GetById(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting user: %w", err)
+	}
+	return res, nil
+}
+
+// provider accounts are matched through user_identities by the stable subject,
+// never by email, so a social login cannot take over an existing account
+func (h *oAuthService) findOrCreateUser(
+	ctx context.Context,
+	provider string,
+	account *externalUser,
+) (*model.User, error) {
+	rI := h.repo.Identity()
+	identity, err := rI.GetByProviderSubject(ctx, provider, account.Subject)
+	if err == nil {
+		rU := h.repo.User()
+		res, err := rU.GetById(ctx, identity.UserID)
+		if err != nil {
+			return nil, fmt.Errorf("failed getting user: %w", err)
+		}
+		return res, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed getting identity: %w", err)
+	}
+
+	name := account.Name
+	if name == "" {
+		name = account.Username
+	}
+	if name == "" {
+		name = account.Email
+	}
+
+	// empty password hash, bcrypt compare always fails so password login is impossible
+	user := model.User{
+		Name:     name,
+		Username: provider + "_" + account.Subject,
+		Password: "",
+	}
+
+	// a verified provider email is copied only when no other account uses it,
+	// an existing owner has to link the provider explicitly
+	email := helper.NormalizeEmail(account.Email)
+	if account.EmailVerified && helper.IsValidEmail(email) {
+		rA := h.repo.Auth()
+		if err := rA.VerifyEmail(ctx, email, 0); errors.Is(err, sql.ErrNoRows) {
+			user.Email = &email
+			user.EmailVerified = true
+		}
+	}
+
+	res, err := rI.CreateWithUser(
+		ctx,
+		user,
+		model.UserIdentity{
+			Provider: provider,
+			Subject:  account.Subject,
+			Email:    optionalString(account.Email),
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed create user: %w", err)
+	}
+
+	return res, nil
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,224 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"auth/config"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var ErrUnknownProvider = errors.New("unknown oauth provider")
+
+type OAuthRegistry struct {
+	providers map[string]*oauthProvider
+}
+
+type oauthProvider struct {
+	cfg config.OAuthProviderConfig
+
+	// discovery is done once and reused, a failed attempt is retried on the next request
+	mu   sync.Mutex
+	oidc *oidc.Provider
+}
+
+// externalUser is the provider account after the claim mapping is applied
+type externalUser struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Username      string
+}
+
+func NewOAuthRegistry(cfgs []config.OAuthProviderConfig) *OAuthRegistry {
+	providers := make(map[string]*oauthProvider, len(cfgs))
+	for _, cfg := range cfgs {
+		providers[cfg.Name] = &oauthProvider{cfg: cfg}
+	}
+	return &OAuthRegistry{providers: providers}
+}
+
+func (r *OAuthRegistry) get(name string) (*oauthProvider, error) {
+	if r == nil {
+		return nil, ErrUnknownProvider
+	}
+	p, ok := r.providers[strings.ToLower(name)]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return p, nil
+}
+
+func (p *oauthProvider) discover(ctx context.Context) (*oidc.Provider, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.oidc != nil {
+		return p.oidc, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, p.cfg.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("failed %s discovery: %w", p.cfg.Name, err)
+	}
+
+	p.oidc = provider
+	return p.oidc, nil
+}
+
+func (p *oauthProvider) oauth2Config(ctx context.Context) (oauth2.Config, error) {
+	cfg := oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		RedirectURL:  p.cfg.RedirectURL,
+		Scopes:       p.cfg.Scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  p.cfg.AuthURL,
+			TokenURL: p.cfg.TokenURL,
+		},
+	}
+
+	if p.cfg.Type != config.OAuthTypeOIDC {
+		return cfg, nil
+	}
+
+	provider, err := p.discover(ctx)
+	if err != nil {
+		return oauth2.Config{}, err
+	}
+
+	// explicit urls in the config win over the discovery document
+	endpoint := provider.Endpoint()
+	if cfg.Endpoint.AuthURL == "" {
+		cfg.Endpoint.AuthURL = endpoint.AuthURL
+	}
+	if cfg.Endpoint.TokenURL == "" {
+		cfg.Endpoint.TokenURL = endpoint.TokenURL
+	}
+	cfg.Endpoint.AuthStyle = endpoint.AuthStyle
+
+	return cfg, nil
+}
+
+// claims returns the verified id token claims for oidc providers,
+// merged with the userinfo response when a userinfo url is configured
+func (p *oauthProvider) claims(
+	ctx context.Context,
+	cfg oauth2.Config,
+	token *oauth2.Token,
+	nonce string,
+) (map[string]any, error) {
+	claims := map[string]any{}
+
+	if p.cfg.Type == config.OAuthTypeOIDC {
+		provider, err := p.discover(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		rawIDToken, ok := token.Extra("id_token").(string)
+		if !ok || rawIDToken == "" {
+			return nil, fmt.Errorf("id_token missing from token response")
+		}
+
+		verifier := provider.Verifier(&oidc.Config{ClientID: cfg.ClientID})
+		idToken, err := verifier.Verify(ctx, rawIDToken)
+		if err != nil {
+			return nil, fmt.Errorf("invalid id_token: %w", err)
+		}
+		if idToken.Nonce != nonce {
+			return nil, fmt.Errorf("invalid id_token nonce")
+		}
+
+		if err := idToken.Claims(&claims); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.cfg.UserInfoURL == "" {
+		return claims, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.UserInfoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := cfg.Client(ctx, token).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting userinfo: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("userinfo returned status %d", resp.StatusCode)
+	}
+
+	userInfo := map[string]any{}
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&userInfo); err != nil {
+		return nil, fmt.Errorf("failed decoding userinfo: %w", err)
+	}
+
+	// id token claims are verified, userinfo never overrides them
+	for k, v := range userInfo {
+		if _, ok := claims[k]; !ok {
+			claims[k] = v
+		}
+	}
+
+	return claims, nil
+}
+
+func (p *oauthProvider) mapUser(claims map[string]any) (*externalUser, error) {
+	mapping := p.cfg.Claims
+
+	user := externalUser{
+		Subject:  claimString(claims, mapping.Subject),
+		Email:    claimString(claims, mapping.Email),
+		Name:     claimString(claims, mapping.Name),
+		Username: claimString(claims, mapping.Username),
+	}
+	user.EmailVerified, _ = strconv.ParseBool(claimString(claims, mapping.EmailVerified))
+
+	if user.Subject == "" {
+		return nil, fmt.Errorf("%s account has no %q claim", p.cfg.Name, mapping.Subject)
+	}
+
+	return &user, nil
+}
+
+func claimString(claims map[string]any, path string) string {
+	var cur any = claims
+	for _, part := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return ""
+		}
+		cur = m[part]
+	}
+
+	switch v := cur.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,301 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"auth/config"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedis(t *testing.T) *redis.Client {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	return rdb
+}
+
+// fakeOIDCProvider is a minimal openid provider, it answers discovery, jwks
+// and the token endpoint and signs the id token with the nonce of the login
+type fakeOIDCProvider struct {
+	srv *httptest.Server
+	key *rsa.PrivateKey
+
+	mu        sync.Mutex
+	challenge string
+	nonce     string
+	subject   string
+}
+
+func newFakeOIDCProvider(t *testing.T) *fakeOIDCProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeOIDCProvider{key: key, subject: "fake-user-1"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                f.srv.URL,
+			"authorization_endpoint":                f.srv.URL + "/authorize",
+			"token_endpoint":                        f.srv.URL + "/token",
+			"jwks_uri":                              f.srv.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "fake",
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", f.token)
+
+	f.srv = httptest.NewServer(mux)
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+// started remembers what the auth url asked for, like the provider would
+// when the browser arrives at its login page
+func (f *fakeOIDCProvider) started(t *testing.T, authURL string) (string, url.Values) {
+	t.Helper()
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+
+	f.mu.Lock()
+	f.challenge = query.Get("code_challenge")
+	f.nonce = query.Get("nonce")
+	f.mu.Unlock()
+
+	return query.Get("state"), query
+}
+
+func (f *fakeOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if r.PostForm.Get("code") != "good-code" || base64.RawURLEncoding.EncodeToString(sum[:]) != f.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            f.srv.URL,
+		"aud":            "fake-client",
+		"sub":            f.subject,
+		"email":          "Fake.User@Example.com",
+		"email_verified": true,
+		"name":           "Fake User",
+		"nonce":          f.nonce,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Minute).Unix(),
+	})
+	idToken.Header["kid"] = "fake"
+	signed, err := idToken.SignedString(f.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "fake-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     signed,
+	})
+}
+
+func newFakeOAuthService(t *testing.T, f *fakeOIDCProvider) *oAuthService {
+	t.Helper()
+
+	cfg := config.OAuthProviderConfig{
+		Name:         "fake",
+		Type:         config.OAuthTypeOIDC,
+		Issuer:       f.srv.URL,
+		ClientID:     "fake-client",
+		ClientSecret: "fake-secret",
+		RedirectURL:  "https://auth.example.com/auth/oauth/fake/callback",
+		Scopes:       []string{"openid", "email", "profile"},
+		Claims: config.OAuthClaimMapping{
+			Subject:       "sub",
+			Email:         "email",
+			EmailVerified: "email_verified",
+			Name:          "name",
+			Username:      "preferred_username",
+		},
+	}
+
+	return &oAuthService{
+		redisClient: newTestRedis(t),
+		providers:   NewOAuthRegistry([]config.OAuthProviderConfig{cfg}),
+	}
+}
+
+func TestOAuthResolveFakeProvider(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeOIDCProvider(t)
+	s := newFakeOAuthService(t, f)
+
+	authURL, binding, err := s.AuthURL(ctx, "fake")
+	if err != nil {
+		t.Fatalf("AuthURL: %v", err)
+	}
+	if binding == "" {
+		t.Fatal("AuthURL returned no browser binding")
+	}
+	if !strings.HasPrefix(authURL, f.srv.URL+"/authorize?") {
+		t.Fatalf("auth url %q does not point at the provider", authURL)
+	}
+
+	state, query := f.started(t, authURL)
+	if query.Get("code_challenge_method") != "S256" || query.Get("nonce") == "" {
+		t.Fatalf("auth url misses pkce or nonce: %v", query)
+	}
+
+	name, data, account, err := s.resolve(ctx, "fake", state, "good-code", binding)
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if name != "fake" || data.LinkUserID != 0 {
+		t.Fatalf("unexpected state %q %+v", name, data)
+	}
+	if account.Subject != "fake-user-1" || account.Email != "Fake.User@Example.com" || !account.EmailVerified || account.Name != "Fake User" {
+		t.Fatalf("unexpected account %+v", account)
+	}
+
+	// the state is single use
+	if _, _, _, err := s.resolve(ctx, "fake", state, "good-code", binding); err == nil {
+		t.Fatal("replayed callback was accepted")
+	}
+}
+
+func TestOAuthResolveRejectsOtherBrowser(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeOIDCProvider(t)
+	s := newFakeOAuthService(t, f)
+
+	for _, binding := range []string{"", "someone-elses-cookie"} {
+		authURL, _, err := s.AuthURL(ctx, "fake")
+		if err != nil {
+			t.Fatalf("AuthURL: %v", err)
+		}
+		state, _ := f.started(t, authURL)
+
+		_, _, _, err = s.resolve(ctx, "fake", state, "good-code", binding)
+		if !errors.Is(err, ErrOAuthStateBinding) {
+			t.Fatalf("binding %q: got %v, want ErrOAuthStateBinding", binding, err)
+		}
+	}
+}
+
+func TestOAuthResolveRejectsBadExchange(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeOIDCProvider(t)
+	s := newFakeOAuthService(t, f)
+
+	authURL, binding, err := s.AuthURL(ctx, "fake")
+	if err != nil {
+		t.Fatalf("AuthURL: %v", err)
+	}
+	state, _ := f.started(t, authURL)
+	if _, _, _, err := s.resolve(ctx, "fake", state, "bad-code", binding); err == nil {
+		t.Fatal("a code the provider refused was accepted")
+	}
+
+	// an id token minted for another login fails the nonce check
+	authURL, binding, err = s.AuthURL(ctx, "fake")
+	if err != nil {
+		t.Fatalf("AuthURL: %v", err)
+	}
+	state, _ = f.started(t, authURL)
+	f.mu.Lock()
+	f.nonce = "another-login"
+	f.mu.Unlock()
+	if _, _, _, err := s.resolve(ctx, "fake", state, "good-code", binding); err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Fatalf("got %v, want a nonce error", err)
+	}
+
+	// a state of one provider cannot finish at another
+	authURL, binding, err = s.AuthURL(ctx, "fake")
+	if err != nil {
+		t.Fatalf("AuthURL: %v", err)
+	}
+	state, _ = f.started(t, authURL)
+	if _, _, _, err := s.resolve(ctx, "github", state, "good-code", binding); !errors.Is(err, ErrUnknownProvider) {
+		t.Fatalf("got %v, want ErrUnknownProvider", err)
+	}
+}
+
+// a link state names the account the provider identity is attached to,
+// it only finishes in the browser of that account's user
+func TestOAuthResolveLinkNeedsBinding(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeOIDCProvider(t)
+	s := newFakeOAuthService(t, f)
+
+	authURL, binding, err := s.LinkURL(ctx, "fake", 42)
+	if err != nil {
+		t.Fatalf("LinkURL: %v", err)
+	}
+	state, _ := f.started(t, authURL)
+
+	// the attacker opens the victim's link url with their own provider account
+	if _, _, _, err := s.resolve(ctx, "fake", state, "good-code", ""); !errors.Is(err, ErrOAuthStateBinding) {
+		t.Fatalf("got %v, want ErrOAuthStateBinding", err)
+	}
+
+	authURL, binding, err = s.LinkURL(ctx, "fake", 42)
+	if err != nil {
+		t.Fatalf("LinkURL: %v", err)
+	}
+	state, _ = f.started(t, authURL)
+	_, data, _, err := s.resolve(ctx, "fake", state, "good-code", binding)
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if data.LinkUserID != 42 {
+		t.Fatalf("link user id = %d, want 42", data.LinkUserID)
+	}
+}
@@ -0,0 +1,315 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+
+	"auth/internal/helper"
+	"auth/internal/model"
+	"auth/internal/repository"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrOAuthClientNotFound = errors.New("oauth client not found")
+	ErrOAuthClientExists   = errors.New("client_id already registered")
+	ErrInvalidOAuthClient  = errors.New("invalid oauth client")
+)
+
+var supportedGrantTypes = []string{
+	GrantAuthorizationCode,
+	GrantRefreshToken,
+	GrantClientCredentials,
+	GrantDeviceCode,
+	GrantTokenExchange,
+}
+
+// validScope follows the RFC 6749 scope-token syntax,
+// printable ascii without space, quote or backslash
+func validScope(scope string) bool {
+	if scope == "" {
+		return false
+	}
+	for _, c := range scope {
+		if c < 0x21 || c > 0x7e || c == '"' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+// validRedirectURI allows https, plain http only back to the device itself
+// and the private-use schemes of native apps, which RFC 8252 wants in
+// reverse domain form, so javascript:, data: and friends never pass
+func validRedirectURI(u *url.URL) bool {
+	switch u.Scheme {
+	case "https":
+		return u.Host != ""
+	case "http":
+		host := u.Hostname()
+		if host == "localhost" {
+			return true
+		}
+		ip := net.ParseIP(host)
+		return ip != nil && ip.IsLoopback()
+	default:
+		return strings.Contains(u.Scheme, ".")
+	}
+}
+
+type OAuthClientService interface {
+	// Create returns the plain client secret once, only its hash is stored
+	Create(ctx context.Context, input model.OAuthClientInput) (*model.OAuthClientSecret, error)
+	GetByClientId(ctx context.Context, clientID string) (*model.OAuthClient, error)
+	GetMany(ctx context.Context) ([]model.OAuthClient, error)
+	Update(ctx context.Context, clientID string, input model.OAuthClientInput) (*model.OAuthClient, error)
+	RotateSecret(ctx context.Context, clientID string) (*model.OAuthClientSecret, error)
+	Delete(ctx context.Context, clientID string) error
+}
+
+type oAuthClientService struct {
+	repo repository.Repository
+}
+
+func NewOAuthClientService(repo repository.Repository) OAuthClientService {
+	return &oAuthClientService{repo: repo}
+}
+
+// oauthClientFromInput checks the input and fills the defaults, a client without
+// grant types gets the user flows and, with them, every user scope.
+// Scopes other than the user ones are free form, they are what
+// client_credentials tokens carry.
+func oauthClientFromInput(input model.OAuthClientInput) (*model.OAuthClient, error) {
+	client := model.OAuthClient{
+		ClientID:        strings.TrimSpace(input.ClientID),
+		Name:            strings.TrimSpace(input.Name),
+		Public:          input.Public,
+		RequireDPoP:     input.RequireDPoP,
+		RedirectURIs:    input.RedirectURIs,
+		GrantTypes:      input.GrantTypes,
+		Scopes:          input.Scopes,
+		Audiences:       input.Audiences,
+		AccessTokenTTL:  input.AccessTokenTTL,
+		RefreshTokenTTL: input.RefreshTokenTTL,
+	}
+
+	if len(client.GrantTypes) == 0 {
+		client.GrantTypes = []string{GrantAuthorizationCode, GrantRefreshToken}
+	}
+	if len(client.Scopes) == 0 && slices.Contains(client.GrantTypes, GrantAuthorizationCode) {
+		client.Scopes = slices.Clone(supportedScopes)
+	}
+	if client.Scopes == nil {
+		client.Scopes = []string{}
+	}
+	if client.RedirectURIs == nil {
+		client.RedirectURIs = []string{}
+	}
+	if client.Audiences == nil {
+		client.Audiences = []string{}
+	}
+
+	for _, grant := range client.GrantTypes {
+		if !slices.Contains(supportedGrantTypes, grant) {
+			return nil, fmt.Errorf("%w: grant type %q is not supported", ErrInvalidOAuthClient, grant)
+		}
+	}
+	for _, scope := range client.Scopes {
+		if !validScope(scope) {
+			return nil, fmt.Errorf("%w: scope %q is not valid", ErrInvalidOAuthClient, scope)
+		}
+	}
+
+	if client.Public && slices.Contains(client.GrantTypes, GrantClientCredentials) {
+		return nil, fmt.Errorf("%w: public clients cannot use the client_credentials grant", ErrInvalidOAuthClient)
+	}
+	if client.Public && slices.Contains(client.GrantTypes, GrantTokenExchange) {
+		return nil, fmt.Errorf("%w: public clients cannot use the token exchange grant", ErrInvalidOAuthClient)
+	}
+	for _, aud := range client.Audiences {
+		if strings.TrimSpace(aud) == "" {
+			return nil, fmt.Errorf("%w: audiences cannot be empty", ErrInvalidOAuthClient)
+		}
+	}
+
+	if slices.Contains(client.GrantTypes, GrantAuthorizationCode) && len(client.RedirectURIs) == 0 {
+		return nil, fmt.Errorf("%w: redirect_uris is required for the authorization_code grant", ErrInvalidOAuthClient)
+	}
+	for _, uri := range client.RedirectURIs {
+		u, err := url.Parse(uri)
+		if err != nil || u.Scheme == "" || u.Fragment != "" {
+			return nil, fmt.Errorf("%w: redirect uri %q must be absolute and without a fragment", ErrInvalidOAuthClient, uri)
+		}
+		if !validRedirectURI(u) {
+			return nil, fmt.Errorf("%w: redirect uri %q must use https, http on loopback or a reverse domain scheme", ErrInvalidOAuthClient, uri)
+		}
+	}
+
+	if client.AccessTokenTTL < 0 || client.RefreshTokenTTL < 0 {
+		return nil, fmt.Errorf("%w: token lifetimes cannot be negative", ErrInvalidOAuthClient)
+	}
+	// signing keys are retired once JWT_EXPIRED has passed, a longer lived
+	// token would outlive the key it was signed with
+	expiry, err := helper.AccessTokenExpiry()
+	if err != nil {
+		return nil, err
+	}
+	if clientTTL(client.AccessTokenTTL) > expiry {
+		return nil, fmt.Errorf("%w: access_token_ttl cannot be longer than JWT_EXPIRED (%s)", ErrInvalidOAuthClient, expiry)
+	}
+
+	return &client, nil
+}
+
+func newClientSecret() (string, string, error) {
+	secret, err := randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return "", "", err
+	}
+
+	return secret, string(hash), nil
+}
+
+func (h *oAuthClientService) Create(ctx context.Context, input model.OAuthClientInput) (*model.OAuthClientSecret, error) {
+	client, err := oauthClientFromInput(input)
+	if err != nil {
+		return nil, err
+	}
+
+	if client.ClientID == "" {
+		id, err := randomString(16)
+		if err != nil {
+			return nil, err
+		}
+		client.ClientID = id
+	}
+
+	r := h.repo.OAuthClient()
+	if _, err := r.GetByClientId(ctx, client.ClientID); err == nil {
+		return nil, ErrOAuthClientExists
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed checking client: %w", err)
+	}
+
+	// public clients (spa, mobile) have no secret and rely on pkce alone
+	secret := ""
+	if !client.Public {
+		secret, client.SecretHash, err = newClientSecret()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := r.Create(ctx, *client)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating client: %w", err)
+	}
+
+	return &model.OAuthClientSecret{OAuthClient: *res, ClientSecret: secret}, nil
+}
+
+func (h *oAuthClientService) GetByClientId(ctx context.Context, clientID string) (*model.OAuthClient, error) {
+	r := h.repo.OAuthClient()
+	res, err := r.GetByClientId(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrOAuthClientNotFound
+		}
+		return nil, fmt.Errorf("failed getting client: %w", err)
+	}
+	return res, nil
+}
+
+func (h *oAuthClientService) GetMany(ctx context.Context) ([]model.OAuthClient, error) {
+	r := h.repo.OAuthClient()
+	res, err := r.GetMany(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting clients: %w", err)
+	}
+	return res, nil
+}
+
+func (h *oAuthClientService) Update(
+	ctx context.Context,
+	clientID string,
+	input model.OAuthClientInput,
+) (*model.OAuthClient, error) {
+	client, err := oauthClientFromInput(input)
+	if err != nil {
+		return nil, err
+	}
+	client.ClientID = clientID
+
+	r := h.repo.OAuthClient()
+	current, err := r.GetByClientId(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrOAuthClientNotFound
+		}
+		return nil, fmt.Errorf("failed getting client: %w", err)
+	}
+
+	// turning public into confidential needs a secret first
+	if current.Public && !client.Public && current.SecretHash == "" {
+		return nil, fmt.Errorf("%w: rotate the secret before making the client confidential", ErrInvalidOAuthClient)
+	}
+
+	res, err := r.Update(ctx, *client)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrOAuthClientNotFound
+		}
+		return nil, fmt.Errorf("failed updating client: %w", err)
+	}
+	return res, nil
+}
+
+// RotateSecret replaces the secret right away, the old one stops working
+func (h *oAuthClientService) RotateSecret(ctx context.Context, clientID string) (*model.OAuthClientSecret, error) {
+	r := h.repo.OAuthClient()
+	client, err := r.GetByClientId(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrOAuthClientNotFound
+		}
+		return nil, fmt.Errorf("failed getting client: %w", err)
+	}
+
+	secret, hash, err := newClientSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.UpdateSecret(ctx, clientID, hash); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrOAuthClientNotFound
+		}
+		return nil, fmt.Errorf("failed updating client secret: %w", err)
+	}
+	client.SecretHash = hash
+
+	return &model.OAuthClientSecret{OAuthClient: *client, ClientSecret: secret}, nil
+}
+
+func (h *oAuthClientService) Delete(ctx context.Context, clientID string) error {
+	r := h.repo.OAuthClient()
+	if err := r.Delete(ctx, clientID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrOAuthClientNotFound
+		}
+		return fmt.Errorf("failed deleting client: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"auth/internal/model"
+)
+
+func TestOAuthClientRedirectURIs(t *testing.T) {
+	t.Setenv("JWT_EXPIRED", "")
+
+	allowed := []string{
+		"https://app.example.com/callback",
+		"http://localhost:3000/callback",
+		"http://127.0.0.1:8400/",
+		"http://[::1]/cb",
+		"com.example.app:/oauth2redirect",
+	}
+	for _, uri := range allowed {
+		if _, err := oauthClientFromInput(model.OAuthClientInput{Name: "app", RedirectURIs: []string{uri}}); err != nil {
+			t.Errorf("%s: %v", uri, err)
+		}
+	}
+
+	refused := []string{
+		"javascript:alert(document.cookie)//",
+		"data:text/html,<script>alert(1)</script>",
+		"vbscript:msgbox",
+		"file:///etc/passwd",
+		"http://app.example.com/callback",
+		"http://localhost.evil.test/callback",
+		"https:///callback",
+		"myapp:/callback",
+		"https://app.example.com/callback#token",
+		"/callback",
+	}
+	for _, uri := range refused {
+		_, err := oauthClientFromInput(model.OAuthClientInput{Name: "app", RedirectURIs: []string{uri}})
+		if !errors.Is(err, ErrInvalidOAuthClient) {
+			t.Errorf("%s: got %v, want ErrInvalidOAuthClient", uri, err)
+		}
+	}
+}
+
+// a token must not outlive the key it was signed with,
+// keys are retired once JWT_EXPIRED has passed
+func TestOAuthClientAccessTokenTTL(t *testing.T) {
+	t.Setenv("JWT_EXPIRED", "10m")
+
+	input := model.OAuthClientInput{Name: "machine", GrantTypes: []string{GrantClientCredentials}, AccessTokenTTL: 600}
+	if _, err := oauthClientFromInput(input); err != nil {
+		t.Fatalf("ttl of JWT_EXPIRED refused: %v", err)
+	}
+
+	input.AccessTokenTTL = 3600
+	if _, err := oauthClientFromInput(input); !errors.Is(err, ErrInvalidOAuthClient) {
+		t.Fatalf("got %v, want ErrInvalidOAuthClient", err)
+	}
+
+	// registered before JWT_EXPIRED was lowered
+	lifetime, err := accessTokenLifetime(&model.OAuthClient{AccessTokenTTL: 3600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lifetime != 10*time.Minute {
+		t.Fatalf("lifetime = %s, want 10m", lifetime)
+	}
+
+	lifetime, err = accessTokenLifetime(&model.OAuthClient{AccessTokenTTL: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lifetime != time.Minute {
+		t.Fatalf("lifetime = %s, want 1m", lifetime)
+	}
+}
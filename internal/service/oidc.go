@@ -0,0 +1,813 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"auth/config"
+	"auth/internal/helper"
+	"auth/internal/model"
+	"auth/internal/repository"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/oauth2"
+)
+
+const authorizationCodeTTL = time.Minute
+
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantClientCredentials = "client_credentials"
+	GrantDeviceCode        = "urn:ietf:params:oauth:grant-type:device_code"
+	GrantTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange"
+)
+
+var supportedScopes = []string{"openid", "profile", "email", "offline_access"}
+
+// OAuthError is an RFC 6749 error, Code goes out as the error field
+type OAuthError struct {
+	Code        string
+	Description string
+}
+
+func (e *OAuthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func oauthError(code string, description string) error {
+	return &OAuthError{Code: code, Description: description}
+}
+
+type OIDCService interface {
+	Configuration() model.OpenIDConfiguration
+	// Authorize returns where to send the browser, the client redirect uri with
+	// a code or an error, or the login page when there is no session.
+	// An error means the request could not be tied to a registered redirect uri.
+	Authorize(ctx context.Context, req model.AuthorizeRequest, session string) (string, error)
+	Token(ctx context.Context, req model.TokenRequest) (*model.TokenResponse, error)
+	// Introspect answers a confidential client, a token that fails any check is just inactive
+	Introspect(ctx context.Context, req model.IntrospectionRequest) (*model.IntrospectionResponse, error)
+	// Revoke is RFC 7009, unknown, dead or foreign tokens are not an error
+	Revoke(ctx context.Context, req model.RevocationRequest) error
+	DeviceAuthorization(ctx context.Context, req model.DeviceAuthorizationRequest) (*model.DeviceAuthorizationResponse, error)
+	DeviceLoginURL(userCode string) string
+	// DevicePrompt needs a session, without one it fails with login_required
+	DevicePrompt(ctx context.Context, userCode string, session string) (*model.DevicePrompt, error)
+	DecideDevice(ctx context.Context, userCode string, approve bool, session string) error
+	UserInfo(ctx context.Context, claims *model.ClaimsModel) (*model.UserInfo, error)
+}
+
+type oidcService struct {
+	repo        repository.Repository
+	redisClient *redis.Client
+}
+
+func NewOIDCService(
+	repo repository.Repository,
+	redisClient *redis.Client,
+) OIDCService {
+	return &oidcService{
+		repo:        repo,
+		redisClient: redisClient,
+	}
+}
+
+func (h *oidcService) client(ctx context.Context, clientID string) (*model.OAuthClient, error) {
+	if clientID == "" {
+		return nil, oauthError("invalid_client", "client_id is required")
+	}
+
+	r := h.repo.OAuthClient()
+	client, err := r.GetByClientId(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, oauthError("invalid_client", "unknown client_id")
+		}
+		return nil, fmt.Errorf("failed getting client: %w", err)
+	}
+	return client, nil
+}
+
+func clientTTL(seconds int) time.Duration {
+	return time.Duration(seconds) * time.Second
+}
+
+// checkScope makes sure every requested scope is one the client may ask for
+func checkScope(client *model.OAuthClient, scope string) error {
+	for _, s := range strings.Fields(scope) {
+		if !slices.Contains(supportedScopes, s) {
+			return oauthError("invalid_scope", fmt.Sprintf("scope %q is not supported", s))
+		}
+		if !slices.Contains(client.Scopes, s) {
+			return oauthError("invalid_scope", fmt.Sprintf("scope %q is not allowed for this client", s))
+		}
+	}
+	return nil
+}
+
+func authorizationCodeKey(code string) string {
+	return "oauth:code:" + helper.HashToken(code)
+}
+
+func hasScope(scope string, want string) bool {
+	return slices.Contains(strings.Fields(scope), want)
+}
+
+func (h *oidcService) Configuration() model.OpenIDConfiguration {
+	issuer := config.BaseURL()
+
+	return model.OpenIDConfiguration{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/oauth/authorize",
+		TokenEndpoint:                     issuer + "/oauth/token",
+		IntrospectionEndpoint:             issuer + "/oauth/introspect",
+		RevocationEndpoint:                issuer + "/oauth/revoke",
+		DeviceAuthorizationEndpoint:       issuer + "/oauth/device_authorization",
+		UserInfoEndpoint:                  issuer + "/oauth/userinfo",
+		JWKSURI:                           issuer + "/.well-known/jwks.json",
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               supportedGrantTypes,
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{helper.SigningAlg()},
+		ScopesSupported:                   supportedScopes,
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		DPoPSigningAlgValuesSupported:     []string{helper.AlgES256, helper.AlgRS256, helper.AlgEdDSA},
+		ClaimsSupported: []string{
+			"sub", "iss", "aud", "exp", "iat", "nonce",
+			"name", "preferred_username", "email", "email_verified",
+		},
+	}
+}
+
+func (h *oidcService) Authorize(
+	ctx context.Context,
+	req model.AuthorizeRequest,
+	session string,
+) (string, error) {
+	client, err := h.client(ctx, req.ClientID)
+	if err != nil {
+		return "", err
+	}
+
+	return h.authorize(ctx, client, req, session)
+}
+
+func (h *oidcService) authorize(
+	ctx context.Context,
+	client *model.OAuthClient,
+	req model.AuthorizeRequest,
+	session string,
+) (string, error) {
+	// never redirect to an uri that is not registered, exact match only
+	if req.RedirectURI == "" || !slices.Contains(client.RedirectURIs, req.RedirectURI) {
+		return "", oauthError("invalid_request", "redirect_uri is not registered for this client")
+	}
+
+	fail := func(code string, description string) (string, error) {
+		return redirectWith(req.RedirectURI, url.Values{
+			"error":             {code},
+			"error_description": {description},
+			"state":             {req.State},
+			"iss":               {config.BaseURL()},
+		}), nil
+	}
+
+	if req.ResponseType != "code" {
+		return fail("unsupported_response_type", "only response_type=code is supported")
+	}
+	if !slices.Contains(client.GrantTypes, GrantAuthorizationCode) {
+		return fail("unauthorized_client", "the client may not use the authorization_code grant")
+	}
+	if err := checkScope(client, req.Scope); err != nil {
+		var oauthErr *OAuthError
+		if errors.As(err, &oauthErr) {
+			return fail(oauthErr.Code, oauthErr.Description)
+		}
+		return "", err
+	}
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != "S256" {
+		return fail("invalid_request", "pkce with code_challenge_method=S256 is required")
+	}
+	if hasScope(req.Scope, "openid") && helper.SigningAlg() == helper.AlgHS256 {
+		return fail("server_error", "id tokens need an asymmetric JWT_SIGNING_ALG")
+	}
+
+	user, err := h.sessionUser(ctx, session)
+	if err != nil {
+		loginURL := os.Getenv("OIDC_LOGIN_URL")
+		if req.Prompt == "none" || loginURL == "" {
+			return fail("login_required", "the user is not logged in")
+		}
+		returnTo := config.BaseURL() + "/oauth/authorize?" + authorizeQuery(req).Encode()
+		return redirectWith(loginURL, url.Values{"return_to": {returnTo}}), nil
+	}
+
+	// first party clients only, so there is no consent screen
+	return h.issueCode(ctx, client, req, user.ID)
+}
+
+// issueCode stores a single use code for the approved request and
+// returns the client redirect uri carrying it
+func (h *oidcService) issueCode(
+	ctx context.Context,
+	client *model.OAuthClient,
+	req model.AuthorizeRequest,
+	userID int,
+) (string, error) {
+	code, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	raw, err := json.Marshal(model.AuthorizationCode{
+		ClientID:      client.ClientID,
+		RedirectURI:   req.RedirectURI,
+		UserID:        userID,
+		Scope:         req.Scope,
+		Nonce:         req.Nonce,
+		CodeChallenge: req.CodeChallenge,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err := h.redisClient.Set(ctx, authorizationCodeKey(code), raw, authorizationCodeTTL).Err(); err != nil {
+		return "", fmt.Errorf("failed storing authorization code: %w", err)
+	}
+
+	return redirectWith(req.RedirectURI, url.Values{
+		"code":  {code},
+		"state": {req.State},
+		"iss":   {config.BaseURL()},
+	}), nil
+}
+
+// sessionUser is the user of the browser session, the first party refresh token cookie
+func (h *oidcService) sessionUser(ctx context.Context, session string) (*model.User, error) {
+	if session == "" {
+		return nil, oauthError("login_required", "the user is not logged in")
+	}
+
+	claims, err := helper.ValidateRefreshToken(ctx, session, h.redisClient)
+	if err != nil || claims.ClientID != "" {
+		return nil, oauthError("login_required", "the user is not logged in")
+	}
+
+	rU := h.repo.User()
+	user, err := rU.GetById(ctx, claims.UserID)
+	if err != nil {
+		return nil, oauthError("login_required", "the user is not logged in")
+	}
+	return user, nil
+}
+
+func authorizeQuery(req model.AuthorizeRequest) url.Values {
+	values := url.Values{}
+	set := func(key string, value string) {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+	set("response_type", req.ResponseType)
+	set("client_id", req.ClientID)
+	set("redirect_uri", req.RedirectURI)
+	set("scope", req.Scope)
+	set("state", req.State)
+	set("nonce", req.Nonce)
+	set("code_challenge", req.CodeChallenge)
+	set("code_challenge_method", req.CodeChallengeMethod)
+	return values
+}
+
+// redirectWith adds the params to the query the uri may already have, empty values are left out
+func redirectWith(uri string, params url.Values) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+
+	query := u.Query()
+	for key, values := range params {
+		if len(values) > 0 && values[0] != "" {
+			query.Set(key, values[0])
+		}
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+func (h *oidcService) authenticateClient(ctx context.Context, clientID string, clientSecret string) (*model.OAuthClient, error) {
+	client, err := h.client(ctx, clientID)
+	if err != nil {
+		var oauthErr *OAuthError
+		if errors.As(err, &oauthErr) {
+			return nil, oauthError("invalid_client", "client authentication failed")
+		}
+		return nil, err
+	}
+
+	if client.Public {
+		if clientSecret != "" {
+			return nil, oauthError("invalid_client", "public clients have no secret")
+		}
+		return client, nil
+	}
+
+	if clientSecret == "" || client.SecretHash == "" ||
+		bcrypt.CompareHashAndPassword([]byte(client.SecretHash), []byte(clientSecret)) != nil {
+		return nil, oauthError("invalid_client", "client authentication failed")
+	}
+	return client, nil
+}
+
+func (h *oidcService) Token(ctx context.Context, req model.TokenRequest) (*model.TokenResponse, error) {
+	client, err := h.authenticateClient(ctx, req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := helper.DPoPProofFromContext(ctx); client.RequireDPoP && !ok {
+		return nil, oauthError("invalid_dpop_proof", "this client must send a DPoP proof")
+	}
+
+	if req.GrantType != "" && slices.Contains(supportedGrantTypes, req.GrantType) &&
+		!slices.Contains(client.GrantTypes, req.GrantType) {
+		return nil, oauthError("unauthorized_client", fmt.Sprintf("the client may not use the %s grant", req.GrantType))
+	}
+
+	switch req.GrantType {
+	case GrantAuthorizationCode:
+		return h.exchangeCode(ctx, client, req)
+	case GrantRefreshToken:
+		return h.refresh(ctx, client, req)
+	case GrantClientCredentials:
+		return h.clientCredentials(ctx, client, req)
+	case GrantDeviceCode:
+		return h.deviceToken(ctx, client, req)
+	case GrantTokenExchange:
+		return h.tokenExchange(ctx, client, req)
+	default:
+		return nil, oauthError("unsupported_grant_type", fmt.Sprintf("grant_type %q is not supported", req.GrantType))
+	}
+}
+
+func (h *oidcService) exchangeCode(
+	ctx context.Context,
+	client *model.OAuthClient,
+	req model.TokenRequest,
+) (*model.TokenResponse, error) {
+	code, err := h.redeemCode(ctx, client, req)
+	if err != nil {
+		return nil, err
+	}
+
+	rU := h.repo.User()
+	user, err := rU.GetById(ctx, code.UserID)
+	if err != nil {
+		return nil, oauthError("invalid_grant", "user no longer exists")
+	}
+
+	return h.grantUser(ctx, client, *user, code.Scope, code.Nonce)
+}
+
+// redeemCode takes the code out of redis, it only comes back for the
+// client and redirect uri it was issued to and the matching code_verifier
+func (h *oidcService) redeemCode(
+	ctx context.Context,
+	client *model.OAuthClient,
+	req model.TokenRequest,
+) (*model.AuthorizationCode, error) {
+	if req.Code == "" {
+		return nil, oauthError("invalid_request", "code is required")
+	}
+
+	// codes are single use
+	raw, err := h.redisClient.GetDel(ctx, authorizationCodeKey(req.Code)).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, oauthError("invalid_grant", "code is invalid or expired")
+		}
+		return nil, err
+	}
+
+	code := model.AuthorizationCode{}
+	if err := json.Unmarshal(raw, &code); err != nil {
+		return nil, err
+	}
+
+	if code.ClientID != client.ClientID || code.RedirectURI != req.RedirectURI {
+		return nil, oauthError("invalid_grant", "code was issued to another client or redirect_uri")
+	}
+
+	if len(req.CodeVerifier) < 43 || len(req.CodeVerifier) > 128 ||
+		subtle.ConstantTimeCompare(
+			[]byte(oauth2.S256ChallengeFromVerifier(req.CodeVerifier)),
+			[]byte(code.CodeChallenge),
+		) != 1 {
+		return nil, oauthError("invalid_grant", "code_verifier does not match")
+	}
+
+	return &code, nil
+}
+
+// grantUser hands out the tokens of a grant the user approved
+func (h *oidcService) grantUser(
+	ctx context.Context,
+	client *model.OAuthClient,
+	user model.User,
+	scope string,
+	nonce string,
+) (*model.TokenResponse, error) {
+	// refresh tokens only go to clients allowed to use them
+	refreshToken := ""
+	if slices.Contains(client.GrantTypes, GrantRefreshToken) {
+		var err error
+		refreshToken, err = helper.CreateClientRefreshToken(
+			ctx, user, client.ClientID, scope, clientTTL(client.RefreshTokenTTL), h.redisClient,
+		)
+		if err != nil {
+			if errors.Is(err, ErrSessionLimit) {
+				return nil, oauthError("access_denied", err.Error())
+			}
+			return nil, fmt.Errorf("failed creating refresh token: %w", err)
+		}
+	}
+
+	res, err := h.tokenResponse(ctx, user, client, scope, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if hasScope(scope, "openid") {
+		idToken, err := h.idToken(user, client, scope, nonce)
+		if err != nil {
+			return nil, err
+		}
+		res.IDToken = idToken
+	}
+
+	return res, nil
+}
+
+func (h *oidcService) refresh(
+	ctx context.Context,
+	client *model.OAuthClient,
+	req model.TokenRequest,
+) (*model.TokenResponse, error) {
+	if req.RefreshToken == "" {
+		return nil, oauthError("invalid_request", "refresh_token is required")
+	}
+
+	claims, err := helper.ValidateRefreshToken(ctx, req.RefreshToken, h.redisClient)
+	if err != nil {
+		return nil, oauthError("invalid_grant", err.Error())
+	}
+	if claims.ClientID != client.ClientID {
+		return nil, oauthError("invalid_grant", "refresh token was issued to another client")
+	}
+
+	// scopes taken away from the client since the grant are dropped
+	granted := []string{}
+	for _, s := range strings.Fields(claims.Scope) {
+		if slices.Contains(client.Scopes, s) {
+			granted = append(granted, s)
+		}
+	}
+	claims.Scope = strings.Join(granted, " ")
+
+	// a narrower scope only applies to the new access token
+	scope := claims.Scope
+	if req.Scope != "" {
+		for _, s := range strings.Fields(req.Scope) {
+			if !hasScope(claims.Scope, s) {
+				return nil, oauthError("invalid_scope", fmt.Sprintf("scope %q was not granted", s))
+			}
+		}
+		scope = req.Scope
+	}
+
+	rU := h.repo.User()
+	user, err := rU.GetById(ctx, claims.UserID)
+	if err != nil {
+		return nil, oauthError("invalid_grant", "user no longer exists")
+	}
+
+	refreshToken, err := helper.ClientRefreshRotation(
+		ctx, req.RefreshToken, *user, clientTTL(client.RefreshTokenTTL), h.redisClient,
+	)
+	if err != nil {
+		return nil, oauthError("invalid_grant", err.Error())
+	}
+
+	return h.tokenResponse(ctx, *user, client, scope, refreshToken)
+}
+
+// clientCredentials issues a token for the client itself, there is no user,
+// no refresh token and none of the user scopes
+func (h *oidcService) clientCredentials(
+	ctx context.Context,
+	client *model.OAuthClient,
+	req model.TokenRequest,
+) (*model.TokenResponse, error) {
+	if client.Public {
+		return nil, oauthError("unauthorized_client", "public clients cannot use the client_credentials grant")
+	}
+
+	scopes := strings.Fields(req.Scope)
+	if len(scopes) == 0 {
+		// no scope asked, grant everything the client may have
+		for _, s := range client.Scopes {
+			if !slices.Contains(supportedScopes, s) {
+				scopes = append(scopes, s)
+			}
+		}
+	}
+	for _, s := range scopes {
+		if slices.Contains(supportedScopes, s) {
+			return nil, oauthError("invalid_scope", fmt.Sprintf("scope %q needs a user", s))
+		}
+		if !slices.Contains(client.Scopes, s) {
+			return nil, oauthError("invalid_scope", fmt.Sprintf("scope %q is not allowed for this client", s))
+		}
+	}
+	scope := strings.Join(scopes, " ")
+
+	lifetime, err := accessTokenLifetime(client)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := helper.CreateMachineAccessToken(ctx, client.ClientID, scope, lifetime)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating access token: %w", err)
+	}
+
+	return &model.TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   helper.TokenType(ctx),
+		ExpiresIn:   int(lifetime.Seconds()),
+		Scope:       scope,
+	}, nil
+}
+
+// accessTokenLifetime is the client's own lifetime, cut to JWT_EXPIRED for
+// clients registered before it was lowered, keys are retired on JWT_EXPIRED
+func accessTokenLifetime(client *model.OAuthClient) (time.Duration, error) {
+	lifetime, err := helper.AccessTokenExpiry()
+	if err != nil {
+		return 0, err
+	}
+	if client.AccessTokenTTL > 0 {
+		lifetime = min(lifetime, clientTTL(client.AccessTokenTTL))
+	}
+	return lifetime, nil
+}
+
+func (h *oidcService) tokenResponse(
+	ctx context.Context,
+	user model.User,
+	client *model.OAuthClient,
+	scope string,
+	refreshToken string,
+) (*model.TokenResponse, error) {
+	lifetime, err := accessTokenLifetime(client)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = helper.WithRefreshSession(ctx, refreshToken)
+	accessToken, err := helper.CreateClientAccessToken(ctx, user, client.ClientID, scope, lifetime)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating access token: %w", err)
+	}
+
+	return &model.TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    helper.TokenType(ctx),
+		ExpiresIn:    int(lifetime.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        scope,
+	}, nil
+}
+
+func (h *oidcService) idToken(user model.User, client *model.OAuthClient, scope string, nonce string) (string, error) {
+	lifetime, err := accessTokenLifetime(client)
+	if err != nil {
+		return "", err
+	}
+
+	info := userInfo(user, scope)
+	claims := model.IDTokenClaims{
+		Nonce:             nonce,
+		Name:              info.Name,
+		PreferredUsername: info.PreferredUsername,
+		Email:             info.Email,
+		EmailVerified:     info.EmailVerified,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    config.BaseURL(),
+			Subject:   info.Subject,
+			Audience:  jwt.ClaimStrings{client.ClientID},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(lifetime)),
+		},
+	}
+
+	idToken, err := helper.SignIDToken(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed creating id token: %w", err)
+	}
+	return idToken, nil
+}
+
+// userInfo maps the user to the standard claims the scope allows
+func userInfo(user model.User, scope string) model.UserInfo {
+	info := model.UserInfo{Subject: strconv.Itoa(user.ID)}
+
+	if hasScope(scope, "profile") {
+		info.Name = user.Name
+		info.PreferredUsername = user.Username
+	}
+	if hasScope(scope, "email") && user.Email != nil {
+		verified := user.EmailVerified
+		info.Email = *user.Email
+		info.EmailVerified = &verified
+	}
+
+	return info
+}
+
+func (h *oidcService) UserInfo(ctx context.Context, claims *model.ClaimsModel) (*model.UserInfo, error) {
+	if claims.IsMachine() {
+		return nil, oauthError("invalid_token", "client credentials tokens have no user")
+	}
+
+	scope := claims.Scope
+	if claims.ClientID == "" {
+		// first party tokens carry no scope and may see everything
+		scope = strings.Join(supportedScopes, " ")
+	}
+	if !hasScope(scope, "openid") {
+		return nil, oauthError("insufficient_scope", "the openid scope is required")
+	}
+
+	rU := h.repo.User()
+	user, err := rU.GetById(ctx, claims.UserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, oauthError("invalid_token", "user no longer exists")
+		}
+		return nil, fmt.Errorf("failed getting user: %w", err)
+	}
+
+	info := userInfo(*user, scope)
+	return &info, nil
+}
+
+func (h *oidcService) Introspect(
+	ctx context.Context,
+	req model.IntrospectionRequest,
+) (*model.IntrospectionResponse, error) {
+	client, err := h.authenticateClient(ctx, req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+	if client.Public {
+		return nil, oauthError("invalid_client", "public clients cannot introspect tokens")
+	}
+
+	return h.introspect(ctx, req)
+}
+
+func (h *oidcService) introspect(
+	ctx context.Context,
+	req model.IntrospectionRequest,
+) (*model.IntrospectionResponse, error) {
+	if req.Token == "" {
+		return nil, oauthError("invalid_request", "token is required")
+	}
+
+	// the hint only decides what is tried first
+	inspect := []func() *model.IntrospectionResponse{
+		func() *model.IntrospectionResponse { return introspectAccessToken(ctx, req.Token) },
+		func() *model.IntrospectionResponse { return h.introspectRefreshToken(ctx, req.Token) },
+	}
+	if req.TokenTypeHint == "refresh_token" {
+		slices.Reverse(inspect)
+	}
+
+	for _, fn := range inspect {
+		if res := fn(); res != nil {
+			return res, nil
+		}
+	}
+
+	return &model.IntrospectionResponse{Active: false}, nil
+}
+
+func introspectAccessToken(ctx context.Context, token string) *model.IntrospectionResponse {
+	claims, err := helper.ValidateAccessToken(ctx, token)
+	if err != nil {
+		return nil
+	}
+
+	res := introspectionClaims(claims)
+	res.TokenType = "Bearer"
+	if claims.Confirmation != nil {
+		res.TokenType = "DPoP"
+	}
+	return res
+}
+
+// introspectRefreshToken goes by the refresh:<jti> state,
+// a revoked or rotated token is inactive even though its signature is fine
+func (h *oidcService) introspectRefreshToken(ctx context.Context, token string) *model.IntrospectionResponse {
+	claims, err := helper.InspectRefreshToken(ctx, token, h.redisClient)
+	if err != nil {
+		return nil
+	}
+
+	return introspectionClaims(claims)
+}
+
+func introspectionClaims(claims *model.ClaimsModel) *model.IntrospectionResponse {
+	res := &model.IntrospectionResponse{
+		Active:   true,
+		Scope:    claims.Scope,
+		ClientID: claims.ClientID,
+		Subject:  claims.Subject,
+		Audience: claims.Audience,
+		Issuer:   claims.Issuer,
+		JTI:      claims.ID,
+		Kind:     claims.Kind,
+		Actor:    claims.Actor,
+		Cnf:      claims.Confirmation,
+	}
+	if !claims.IsMachine() {
+		res.Username = claims.Username
+		res.Kind = model.PrincipalUser
+	}
+	if claims.ExpiresAt != nil {
+		res.ExpiresAt = claims.ExpiresAt.Unix()
+	}
+	// older refresh tokens used iat for the absolute session end, it was no issue time
+	if claims.IssuedAt != nil && claims.IssuedAt.Before(time.Now()) {
+		res.IssuedAt = claims.IssuedAt.Unix()
+	}
+	return res
+}
+
+func (h *oidcService) Revoke(ctx context.Context, req model.RevocationRequest) error {
+	client, err := h.authenticateClient(ctx, req.ClientID, req.ClientSecret)
+	if err != nil {
+		return err
+	}
+
+	return h.revoke(ctx, client, req.Token)
+}
+
+// revoke only acts on tokens of the client, a token of another client or a
+// first party one is treated like an invalid token, RFC 7009 section 2.2
+func (h *oidcService) revoke(ctx context.Context, client *model.OAuthClient, token string) error {
+	if token == "" {
+		return oauthError("invalid_request", "token is required")
+	}
+
+	// the two kinds are signed with different keys, so the
+	// token_type_hint is not needed to tell them apart
+	if access, err := helper.ValidateAccessToken(ctx, token); err == nil {
+		if access.ClientID != client.ClientID {
+			return nil
+		}
+		if err := helper.RevokeAccessToken(ctx, access, h.redisClient); err != nil {
+			return fmt.Errorf("failed revoking access token: %w", err)
+		}
+		return nil
+	}
+
+	if refresh, err := helper.InspectRefreshToken(ctx, token, h.redisClient); err == nil {
+		if refresh.ClientID != client.ClientID {
+			return nil
+		}
+		if err := helper.RevokeRefreshToken(token, h.redisClient); err != nil {
+			return fmt.Errorf("failed revoking refresh token: %w", err)
+		}
+	}
+
+	// invalid, expired or already revoked, nothing left to do
+	return nil
+}
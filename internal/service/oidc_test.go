@@ -0,0 +1,385 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"auth/internal/helper"
+	"auth/internal/model"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/oauth2"
+)
+
+// useTestSigningKey signs with an ES256 key of the key store, id tokens
+// need an asymmetric key
+func useTestSigningKey(t *testing.T) *helper.SigningKey {
+	t.Helper()
+
+	useTestTokenSecrets(t)
+	t.Setenv("JWT_SIGNING_ALG", helper.AlgES256)
+	t.Setenv("JWT_KEY_STORE", "database")
+
+	key, err := helper.GenerateSigningKey(helper.AlgES256)
+	if err != nil {
+		t.Fatalf("GenerateSigningKey: %v", err)
+	}
+	keys, err := helper.SigningKeys()
+	if err != nil {
+		t.Fatalf("SigningKeys: %v", err)
+	}
+	keys.Replace(key)
+	t.Cleanup(func() { keys.Replace(nil) })
+	return key
+}
+
+func testWebClient() *model.OAuthClient {
+	return &model.OAuthClient{
+		ClientID:     "web",
+		RedirectURIs: []string{"https://app.example.com/callback"},
+		GrantTypes:   []string{GrantAuthorizationCode},
+		Scopes:       []string{"openid", "profile", "email"},
+	}
+}
+
+func testAuthorizeRequest(verifier string) model.AuthorizeRequest {
+	return model.AuthorizeRequest{
+		ResponseType:        "code",
+		ClientID:            "web",
+		RedirectURI:         "https://app.example.com/callback",
+		Scope:               "openid profile",
+		State:               "xyz",
+		Nonce:               "n-0S6_WzA2Mj",
+		CodeChallenge:       oauth2.S256ChallengeFromVerifier(verifier),
+		CodeChallengeMethod: "S256",
+	}
+}
+
+// issue runs the approved authorize request and returns the code of the redirect
+func issue(t *testing.T, s *oidcService, req model.AuthorizeRequest) string {
+	t.Helper()
+
+	redirect, err := s.issueCode(context.Background(), testWebClient(), req, 42)
+	if err != nil {
+		t.Fatalf("issueCode: %v", err)
+	}
+	u, err := url.Parse(redirect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Query().Get("state") != req.State {
+		t.Fatalf("state = %q, want %q", u.Query().Get("state"), req.State)
+	}
+	return u.Query().Get("code")
+}
+
+func codeRequest(code string, verifier string) model.TokenRequest {
+	return model.TokenRequest{
+		GrantType:    GrantAuthorizationCode,
+		Code:         code,
+		RedirectURI:  "https://app.example.com/callback",
+		CodeVerifier: verifier,
+	}
+}
+
+func wantOAuthError(t *testing.T, err error, code string) {
+	t.Helper()
+
+	var oauthErr *OAuthError
+	if !errors.As(err, &oauthErr) || oauthErr.Code != code {
+		t.Fatalf("got %v, want %s", err, code)
+	}
+}
+
+func TestAuthorizeRejectsUnregisteredRedirect(t *testing.T) {
+	ctx := context.Background()
+	s := &oidcService{redisClient: newTestRedis(t)}
+	req := testAuthorizeRequest(oauth2.GenerateVerifier())
+
+	for _, uri := range []string{"", "https://app.example.com/callback/", "https://evil.example.com/callback"} {
+		req.RedirectURI = uri
+		redirect, err := s.authorize(ctx, testWebClient(), req, "")
+		wantOAuthError(t, err, "invalid_request")
+		if redirect != "" {
+			t.Fatalf("%q: redirected to %q", uri, redirect)
+		}
+	}
+
+	// a registered uri gets the error, pkce is not optional
+	req = testAuthorizeRequest(oauth2.GenerateVerifier())
+	req.CodeChallenge = ""
+	redirect, err := s.authorize(ctx, testWebClient(), req, "")
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	u, _ := url.Parse(redirect)
+	if u.Host != "app.example.com" || u.Query().Get("error") != "invalid_request" {
+		t.Fatalf("unexpected redirect %q", redirect)
+	}
+}
+
+func TestCodeExchangeChecksVerifier(t *testing.T) {
+	ctx := context.Background()
+	s := &oidcService{redisClient: newTestRedis(t)}
+	verifier := oauth2.GenerateVerifier()
+	code := issue(t, s, testAuthorizeRequest(verifier))
+
+	_, err := s.redeemCode(ctx, testWebClient(), codeRequest(code, oauth2.GenerateVerifier()))
+	wantOAuthError(t, err, "invalid_grant")
+
+	// the guess burnt the code, the right verifier is too late now
+	_, err = s.redeemCode(ctx, testWebClient(), codeRequest(code, verifier))
+	wantOAuthError(t, err, "invalid_grant")
+}
+
+func TestCodeIsSingleUse(t *testing.T) {
+	ctx := context.Background()
+	s := &oidcService{redisClient: newTestRedis(t)}
+	verifier := oauth2.GenerateVerifier()
+	code := issue(t, s, testAuthorizeRequest(verifier))
+
+	redeemed, err := s.redeemCode(ctx, testWebClient(), codeRequest(code, verifier))
+	if err != nil {
+		t.Fatalf("redeemCode: %v", err)
+	}
+	if redeemed.UserID != 42 || redeemed.Scope != "openid profile" {
+		t.Fatalf("unexpected code %+v", redeemed)
+	}
+
+	_, err = s.redeemCode(ctx, testWebClient(), codeRequest(code, verifier))
+	wantOAuthError(t, err, "invalid_grant")
+}
+
+func TestCodeExchangeChecksRedirectURIAndClient(t *testing.T) {
+	ctx := context.Background()
+	s := &oidcService{redisClient: newTestRedis(t)}
+	verifier := oauth2.GenerateVerifier()
+
+	code := issue(t, s, testAuthorizeRequest(verifier))
+	req := codeRequest(code, verifier)
+	req.RedirectURI = "https://app.example.com/other"
+	_, err := s.redeemCode(ctx, testWebClient(), req)
+	wantOAuthError(t, err, "invalid_grant")
+
+	code = issue(t, s, testAuthorizeRequest(verifier))
+	other := testWebClient()
+	other.ClientID = "other"
+	_, err = s.redeemCode(ctx, other, codeRequest(code, verifier))
+	wantOAuthError(t, err, "invalid_grant")
+}
+
+// the nonce of the authorize request comes back in the id token
+func TestIDTokenCarriesNonce(t *testing.T) {
+	key := useTestSigningKey(t)
+	ctx := context.Background()
+	s := &oidcService{redisClient: newTestRedis(t)}
+	verifier := oauth2.GenerateVerifier()
+	req := testAuthorizeRequest(verifier)
+
+	code, err := s.redeemCode(ctx, testWebClient(), codeRequest(issue(t, s, req), verifier))
+	if err != nil {
+		t.Fatalf("redeemCode: %v", err)
+	}
+	user := model.User{ID: 42, Username: "user", Name: "User", Role: model.RoleUser}
+	res, err := s.grantUser(ctx, testWebClient(), user, code.Scope, code.Nonce)
+	if err != nil {
+		t.Fatalf("grantUser: %v", err)
+	}
+	if res.IDToken == "" || res.RefreshToken != "" {
+		t.Fatalf("unexpected response %+v", res)
+	}
+
+	claims := &model.IDTokenClaims{}
+	if _, err := jwt.ParseWithClaims(res.IDToken, claims, func(*jwt.Token) (any, error) {
+		return key.Public, nil
+	}, jwt.WithAudience("web"), jwt.WithValidMethods([]string{helper.AlgES256})); err != nil {
+		t.Fatalf("id token does not verify: %v", err)
+	}
+	if claims.Nonce != req.Nonce || claims.Subject != "42" || claims.PreferredUsername != "user" {
+		t.Fatalf("unexpected id token claims %+v", claims)
+	}
+	if claims.Email != "" {
+		t.Fatal("email was released without the email scope")
+	}
+}
+
+// useTestDenylist makes access token validation see revocations in rdb
+func useTestDenylist(t *testing.T, rdb *redis.Client) {
+	t.Helper()
+
+	helper.UseAccessTokenDenylist(rdb)
+	t.Cleanup(func() { helper.UseAccessTokenDenylist(nil) })
+}
+
+func testClientTokens(t *testing.T, rdb *redis.Client, clientID string) (string, string) {
+	t.Helper()
+
+	ctx := context.Background()
+	user := model.User{ID: 42, Username: "user", Role: model.RoleUser}
+	refresh, err := helper.CreateClientRefreshToken(ctx, user, clientID, "openid", 0, rdb)
+	if err != nil {
+		t.Fatalf("CreateClientRefreshToken: %v", err)
+	}
+	access, err := helper.CreateClientAccessToken(ctx, user, clientID, "openid", 0)
+	if err != nil {
+		t.Fatalf("CreateClientAccessToken: %v", err)
+	}
+	return access, refresh
+}
+
+func TestRevokeOwnTokens(t *testing.T) {
+	useTestTokenSecrets(t)
+	ctx := context.Background()
+	rdb := newTestRedis(t)
+	useTestDenylist(t, rdb)
+	s := &oidcService{redisClient: rdb}
+	access, refresh := testClientTokens(t, rdb, "web")
+
+	if err := s.revoke(ctx, testWebClient(), access); err != nil {
+		t.Fatalf("revoke access token: %v", err)
+	}
+	if _, err := helper.ValidateAccessToken(ctx, access); err == nil {
+		t.Fatal("a revoked access token still validates")
+	}
+
+	if err := s.revoke(ctx, testWebClient(), refresh); err != nil {
+		t.Fatalf("revoke refresh token: %v", err)
+	}
+	if _, err := helper.InspectRefreshToken(ctx, refresh, rdb); err == nil {
+		t.Fatal("a revoked refresh token is still active")
+	}
+
+	// again, and a token that never was one
+	for _, token := range []string{refresh, "not-a-token"} {
+		if err := s.revoke(ctx, testWebClient(), token); err != nil {
+			t.Fatalf("revoke %q: %v", token, err)
+		}
+	}
+	wantOAuthError(t, s.revoke(ctx, testWebClient(), ""), "invalid_request")
+}
+
+// a foreign token gets the same answer as an invalid one and is left alone
+func TestRevokeIgnoresForeignTokens(t *testing.T) {
+	useTestTokenSecrets(t)
+	ctx := context.Background()
+	rdb := newTestRedis(t)
+	useTestDenylist(t, rdb)
+	s := &oidcService{redisClient: rdb}
+
+	otherAccess, otherRefresh := testClientTokens(t, rdb, "other")
+	user := model.User{ID: 42, Username: "user", Role: model.RoleUser}
+	firstPartyRefresh, err := helper.CreateRefreshToken(ctx, user, rdb)
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	firstPartyAccess, err := helper.CreateAccessToken(ctx, user)
+	if err != nil {
+		t.Fatalf("CreateAccessToken: %v", err)
+	}
+
+	for _, token := range []string{otherAccess, otherRefresh, firstPartyAccess, firstPartyRefresh} {
+		if err := s.revoke(ctx, testWebClient(), token); err != nil {
+			t.Fatalf("revoke of a foreign token failed: %v", err)
+		}
+	}
+
+	for _, token := range []string{otherAccess, firstPartyAccess} {
+		if _, err := helper.ValidateAccessToken(ctx, token); err != nil {
+			t.Fatalf("another client revoked an access token: %v", err)
+		}
+	}
+	for _, token := range []string{otherRefresh, firstPartyRefresh} {
+		if _, err := helper.InspectRefreshToken(ctx, token, rdb); err != nil {
+			t.Fatalf("another client revoked a refresh token: %v", err)
+		}
+	}
+}
+
+func introspect(t *testing.T, s *oidcService, token string, hint string) *model.IntrospectionResponse {
+	t.Helper()
+
+	res, err := s.introspect(context.Background(), model.IntrospectionRequest{Token: token, TokenTypeHint: hint})
+	if err != nil {
+		t.Fatalf("introspect: %v", err)
+	}
+	return res
+}
+
+func TestIntrospectActiveTokens(t *testing.T) {
+	useTestTokenSecrets(t)
+	rdb := newTestRedis(t)
+	useTestDenylist(t, rdb)
+	s := &oidcService{redisClient: rdb}
+	access, refresh := testClientTokens(t, rdb, "web")
+
+	for _, hint := range []string{"", "access_token", "refresh_token"} {
+		res := introspect(t, s, access, hint)
+		if !res.Active || res.ClientID != "web" || res.Scope != "openid" || res.Username != "user" || res.TokenType != "Bearer" {
+			t.Fatalf("access token with hint %q: %+v", hint, res)
+		}
+
+		res = introspect(t, s, refresh, hint)
+		if !res.Active || res.ClientID != "web" || res.TokenType != "" || res.JTI == "" {
+			t.Fatalf("refresh token with hint %q: %+v", hint, res)
+		}
+	}
+}
+
+func TestIntrospectInactiveTokens(t *testing.T) {
+	useTestTokenSecrets(t)
+	ctx := context.Background()
+	rdb := newTestRedis(t)
+	useTestDenylist(t, rdb)
+	s := &oidcService{redisClient: rdb}
+
+	expired := jwt.NewWithClaims(jwt.SigningMethodHS256, model.ClaimsModel{
+		UserID:   42,
+		Username: "user",
+		ClientID: "web",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "expired",
+			IssuedAt:  jwt.NewNumericDate(time.Now().Add(-time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+		},
+	})
+	expired.Header["typ"] = "at+jwt"
+	expiredToken, err := expired.SignedString([]byte("test-access-secret-0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revokedAccess, revokedRefresh := testClientTokens(t, rdb, "web")
+	if err := s.revoke(ctx, testWebClient(), revokedAccess); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	if err := s.revoke(ctx, testWebClient(), revokedRefresh); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+
+	// a rotated refresh token is spent even though its signature is fine
+	_, rotated := testClientTokens(t, rdb, "web")
+	user := model.User{ID: 42, Username: "user", Role: model.RoleUser}
+	if _, err := helper.ClientRefreshRotation(ctx, rotated, user, 0, rdb); err != nil {
+		t.Fatalf("ClientRefreshRotation: %v", err)
+	}
+
+	for name, token := range map[string]string{
+		"expired access token": expiredToken,
+		"revoked access token": revokedAccess,
+		"revoked refresh":      revokedRefresh,
+		"rotated refresh":      rotated,
+		"garbage":              "not-a-token",
+	} {
+		res := introspect(t, s, token, "")
+		if res.Active || res.ClientID != "" || res.Subject != "" {
+			t.Fatalf("%s: %+v", name, res)
+		}
+	}
+
+	_, err = s.introspect(ctx, model.IntrospectionRequest{})
+	wantOAuthError(t, err, "invalid_request")
+}
@@ -1,34 +1,85 @@
 package service
 
 import (
+	"auth/internal/mail"
 	"auth/internal/repository"
 
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/redis/go-redis/v9"
 )
 
 type Service interface {
 	User() userService
 	Auth() authService
+	OAuth() oAuthService
+	Identity() identityService
+	MFA() mfaService
+	WebAuthn() webAuthnService
+	SigningKey() signingKeyService
+	OIDC() oidcService
+	OAuthClient() oAuthClientService
+	Session() sessionService
 }
 type service struct {
 	repo        repository.Repository
 	redisClient *redis.Client
+	providers   *OAuthRegistry
+	mail        *mail.Queue
+	webAuthn    *webauthn.WebAuthn
 }
 
 func NewService(
 	repo repository.Repository,
 	redisClient *redis.Client,
+	providers *OAuthRegistry,
+	mailQueue *mail.Queue,
+	webAuthn *webauthn.WebAuthn,
 ) *service {
 	return &service{
 		repo:        repo,
 		redisClient: redisClient,
+		providers:   providers,
+		mail:        mailQueue,
+		webAuthn:    webAuthn,
 	}
 }
 
 func (s *service) Auth() authService {
-	return authService{repo: s.repo, redisClient: s.redisClient}
+	return authService{repo: s.repo, redisClient: s.redisClient, mail: s.mail}
+}
+
+func (s *service) OAuth() oAuthService {
+	return oAuthService{repo: s.repo, redisClient: s.redisClient, providers: s.providers}
+}
+
+func (s *service) Identity() identityService {
+	return identityService{repo: s.repo}
+}
+
+func (s *service) MFA() mfaService {
+	return mfaService{repo: s.repo, redisClient: s.redisClient}
+}
+
+func (s *service) WebAuthn() webAuthnService {
+	return webAuthnService{repo: s.repo, redisClient: s.redisClient, webAuthn: s.webAuthn}
+}
+
+func (s *service) SigningKey() signingKeyService {
+	return signingKeyService{repo: s.repo}
+}
+
+func (s *service) OIDC() oidcService {
+	return oidcService{repo: s.repo, redisClient: s.redisClient}
+}
+
+func (s *service) OAuthClient() oAuthClientService {
+	return oAuthClientService{repo: s.repo}
+}
+
+func (s *service) Session() sessionService {
+	return sessionService{repo: s.repo, redisClient: s.redisClient}
 }
 
 func (s *service) User() userService {
-	return userService{repo: s.repo}
+	return userService{repo: s.repo, redisClient: s.redisClient}
 }
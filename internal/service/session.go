@@ -0,0 +1,101 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"auth/internal/helper"
+	"auth/internal/model"
+	"auth/internal/repository"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	ErrSessionNotFound = helper.ErrSessionNotFound
+	// a login over the concurrent session cap when the policy rejects it
+	ErrSessionLimit = helper.ErrSessionLimit
+)
+
+type SessionService interface {
+	// List marks the session of currentRefresh, pass it empty when there is none
+	List(ctx context.Context, userID int, currentRefresh string) ([]model.Session, error)
+	Revoke(ctx context.Context, userID int, sessionID string) error
+	// RevokeAll logs the user out everywhere, access tokens stop working right away
+	RevokeAll(ctx context.Context, userID int) error
+	// SetLimit caps the sessions of one account, nil goes back to the policy
+	SetLimit(ctx context.Context, userID int, maxSessions *int) error
+}
+
+type sessionService struct {
+	repo        repository.Repository
+	redisClient *redis.Client
+}
+
+func NewSessionService(
+	repo repository.Repository,
+	redisClient *redis.Client,
+) SessionService {
+	return &sessionService{
+		repo:        repo,
+		redisClient: redisClient,
+	}
+}
+
+func (h *sessionService) List(ctx context.Context, userID int, currentRefresh string) ([]model.Session, error) {
+	sessions, err := helper.ListSessions(ctx, userID, h.redisClient)
+	if err != nil {
+		return nil, fmt.Errorf("failed listing sessions: %w", err)
+	}
+
+	if currentRefresh == "" {
+		return sessions, nil
+	}
+
+	// a cookie of another user or an invalid one marks nothing
+	claims, err := helper.InspectRefreshToken(ctx, currentRefresh, h.redisClient)
+	if err != nil || claims.UserID != userID {
+		return sessions, nil
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == claims.SessionID
+	}
+
+	return sessions, nil
+}
+
+// Revoke signs one session out, a session of another user is not found
+func (h *sessionService) Revoke(ctx context.Context, userID int, sessionID string) error {
+	if err := helper.RevokeSession(ctx, userID, sessionID, h.redisClient); err != nil {
+		if errors.Is(err, helper.ErrSessionNotFound) {
+			return ErrSessionNotFound
+		}
+		return fmt.Errorf("failed revoking session: %w", err)
+	}
+	return nil
+}
+
+func (h *sessionService) RevokeAll(ctx context.Context, userID int) error {
+	if err := helper.RevokeUserTokens(ctx, userID, h.redisClient); err != nil {
+		return fmt.Errorf("failed revoking sessions: %w", err)
+	}
+	return nil
+}
+
+func (h *sessionService) SetLimit(ctx context.Context, userID int, maxSessions *int) error {
+	if maxSessions != nil && *maxSessions < 1 {
+		return fmt.Errorf("max_sessions must be at least 1")
+	}
+
+	// sessions already over the new cap stay, the cap applies from the next login
+	r := h.repo.User()
+	if err := r.UpdateMaxSessions(ctx, userID, maxSessions); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("user not found")
+		}
+		return fmt.Errorf("failed updating session limit: %w", err)
+	}
+	return nil
+}
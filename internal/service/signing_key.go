@@ -0,0 +1,270 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"auth/internal/helper"
+	"auth/internal/model"
+	"auth/internal/repository"
+)
+
+var (
+	ErrKeyStoreDisabled   = errors.New("signing key store is disabled, set JWT_KEY_STORE=database")
+	ErrKeyRotationPending = errors.New("a rotated signing key is already waiting to become active")
+)
+
+// a verify-only key is kept this much longer than the token lifetime,
+// covers clock skew and clients holding a token a moment past expiry
+const signingKeyRetireGrace = 5 * time.Minute
+
+type SigningKeyService interface {
+	GetMany(ctx context.Context) ([]model.SigningKey, error)
+	// Load fills the key set from the store, creating the first key when there is none
+	Load(ctx context.Context) error
+	// Rotate publishes a new pending key, it signs once the jwks caches have expired
+	Rotate(ctx context.Context) (*model.SigningKey, error)
+	Run(ctx context.Context)
+}
+
+type signingKeyService struct {
+	repo repository.Repository
+}
+
+func NewSigningKeyService(repo repository.Repository) SigningKeyService {
+	return &signingKeyService{repo: repo}
+}
+
+func signingKeyRotationInterval() (time.Duration, error) {
+	intervalStr := os.Getenv("JWT_KEY_ROTATION")
+	if intervalStr == "" {
+		intervalStr = "30d"
+	}
+	return helper.ParseExpiry(intervalStr)
+}
+
+func (h *signingKeyService) GetMany(ctx context.Context) ([]model.SigningKey, error) {
+	r := h.repo.SigningKey()
+	res, err := r.GetMany(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting signing keys: %w", err)
+	}
+	return res, nil
+}
+
+func (h *signingKeyService) Load(ctx context.Context) error {
+	if !helper.KeyStoreEnabled() {
+		return nil
+	}
+
+	keys, err := helper.SigningKeys()
+	if err != nil {
+		return err
+	}
+	keys.SetLoader(func() error {
+		loadCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return h.reload(loadCtx)
+	})
+
+	if err := h.reload(ctx); err != nil {
+		return err
+	}
+
+	// nothing was signed yet, no verifier can hold a stale key set
+	if keys.Active() == nil {
+		if _, err := h.rotate(ctx, nil, model.SigningKeyActive); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h *signingKeyService) reload(ctx context.Context) error {
+	r := h.repo.SigningKey()
+	rows, err := r.GetUsable(ctx)
+	if err != nil {
+		return fmt.Errorf("failed getting signing keys: %w", err)
+	}
+
+	var (
+		active     *helper.SigningKey
+		verifyOnly []*helper.SigningKey
+	)
+	for _, row := range rows {
+		raw, err := helper.DecryptSecret(row.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("failed decrypting signing key %s: %w", row.KID, err)
+		}
+
+		key, err := helper.UnmarshalSigningKey(row.Alg, row.KID, raw)
+		if err != nil {
+			return fmt.Errorf("failed loading signing key %s: %w", row.KID, err)
+		}
+
+		// a pending key is published and verifies, it does not sign yet
+		if row.State == model.SigningKeyActive {
+			active = key
+		} else {
+			verifyOnly = append(verifyOnly, key)
+		}
+	}
+
+	keys, err := helper.SigningKeys()
+	if err != nil {
+		return err
+	}
+	keys.Replace(active, verifyOnly...)
+
+	return nil
+}
+
+// Rotate adds a pending key, maintain promotes it after JWKSMaxAge and the
+// old one keeps verifying until every token it signed has expired
+func (h *signingKeyService) Rotate(ctx context.Context) (*model.SigningKey, error) {
+	if !helper.KeyStoreEnabled() {
+		return nil, ErrKeyStoreDisabled
+	}
+
+	res, err := h.rotate(ctx, nil, model.SigningKeyPending)
+	if err != nil {
+		return nil, err
+	}
+	if res == nil {
+		return nil, ErrKeyRotationPending
+	}
+	return res, nil
+}
+
+// rotate returns nil when a pending key is waiting, or when activeBefore
+// is set and the active key is still young
+func (h *signingKeyService) rotate(
+	ctx context.Context,
+	activeBefore *time.Time,
+	state model.SigningKeyState,
+) (*model.SigningKey, error) {
+	key, err := helper.GenerateSigningKey(helper.SigningAlg())
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := helper.MarshalSigningKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := helper.EncryptSecret(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	data := model.SigningKey{
+		KID:        key.KID,
+		Alg:        key.Alg,
+		PrivateKey: sealed,
+		State:      state,
+	}
+
+	r := h.repo.SigningKey()
+	rotated, err := r.Rotate(ctx, data, activeBefore)
+	if err != nil {
+		return nil, fmt.Errorf("failed rotating signing key: %w", err)
+	}
+
+	if err := h.reload(ctx); err != nil {
+		return nil, err
+	}
+
+	if !rotated {
+		return nil, nil
+	}
+	return &data, nil
+}
+
+// Run rotates on schedule, retires expired keys and reloads the key set
+// so keys rotated by another instance or the cli are picked up
+func (h *signingKeyService) Run(ctx context.Context) {
+	if !helper.KeyStoreEnabled() {
+		return
+	}
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		h.tick(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *signingKeyService) tick(ctx context.Context) {
+	if err := h.maintain(ctx); err != nil && ctx.Err() == nil {
+		log.Printf("signing keys: %v", err)
+	}
+
+	if err := h.reload(ctx); err != nil && ctx.Err() == nil {
+		log.Printf("signing keys: failed reloading: %v", err)
+	}
+}
+
+func (h *signingKeyService) maintain(ctx context.Context) error {
+	interval, err := signingKeyRotationInterval()
+	if err != nil {
+		return fmt.Errorf("invalid JWT_KEY_ROTATION: %w", err)
+	}
+	activeBefore := time.Now().Add(-interval)
+
+	r := h.repo.SigningKey()
+	rows, err := r.GetUsable(ctx)
+	if err != nil {
+		return fmt.Errorf("failed getting signing keys: %w", err)
+	}
+
+	// only generate a key when one is due, the repo check guards the race
+	due := true
+	for _, row := range rows {
+		if row.State == model.SigningKeyPending ||
+			row.State == model.SigningKeyActive && row.CreatedAt != nil && row.CreatedAt.After(activeBefore) {
+			due = false
+		}
+	}
+	if due {
+		res, err := h.rotate(ctx, &activeBefore, model.SigningKeyPending)
+		if err != nil {
+			return fmt.Errorf("scheduled rotation failed: %w", err)
+		}
+		if res != nil {
+			log.Printf("signing keys: rotated, new kid %s is pending", res.KID)
+		}
+	}
+
+	// every cached jwks has picked the pending key up by now
+	kid, err := r.Promote(ctx, time.Now().Add(-helper.JWKSMaxAge))
+	if err != nil {
+		return fmt.Errorf("failed promoting pending key: %w", err)
+	}
+	if kid != "" {
+		log.Printf("signing keys: kid %s is active", kid)
+	}
+
+	lifetime, err := helper.AccessTokenExpiry()
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.Retire(ctx, time.Now().Add(-lifetime-signingKeyRetireGrace)); err != nil {
+		return fmt.Errorf("failed retiring keys: %w", err)
+	}
+
+	return nil
+}
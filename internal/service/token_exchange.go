@@ -0,0 +1,176 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"auth/internal/helper"
+	"auth/internal/model"
+)
+
+const TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+
+// tokenExchange is RFC 8693. The new token keeps the subject, can only lose
+// scope and audience, and always says who is acting in its act claim.
+func (h *oidcService) tokenExchange(
+	ctx context.Context,
+	client *model.OAuthClient,
+	req model.TokenRequest,
+) (*model.TokenResponse, error) {
+	if client.Public {
+		return nil, oauthError("unauthorized_client", "public clients cannot exchange tokens")
+	}
+
+	if req.SubjectToken == "" || req.SubjectTokenType == "" {
+		return nil, oauthError("invalid_request", "subject_token and subject_token_type are required")
+	}
+	if req.SubjectTokenType != TokenTypeAccessToken {
+		return nil, oauthError("invalid_request", "only access tokens can be exchanged")
+	}
+	if req.RequestedTokenType != "" && req.RequestedTokenType != TokenTypeAccessToken {
+		return nil, oauthError("invalid_request", "only access tokens can be issued")
+	}
+
+	subject, err := helper.ValidateAccessToken(ctx, req.SubjectToken)
+	if err != nil {
+		return nil, oauthError("invalid_grant", "subject_token is invalid or expired")
+	}
+	// a stolen bound token is worth nothing here either
+	if err := helper.CheckDPoPBinding(ctx, subject); err != nil {
+		return nil, oauthError("invalid_grant", "subject_token is DPoP bound, the request needs a proof of its key")
+	}
+
+	scope, err := exchangeScope(client, subject, req.Scope)
+	if err != nil {
+		return nil, err
+	}
+
+	audience, err := exchangeAudience(client, subject, req.Audience)
+	if err != nil {
+		return nil, err
+	}
+
+	actor, err := h.exchangeActor(ctx, client, subject, req)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl, err := accessTokenLifetime(client)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, lifetime, err := helper.CreateExchangedAccessToken(
+		ctx,
+		subject, client.ClientID, scope, audience, actor, ttl,
+	)
+	if err != nil {
+		return nil, oauthError("invalid_grant", err.Error())
+	}
+
+	return &model.TokenResponse{
+		AccessToken:     accessToken,
+		TokenType:       helper.TokenType(ctx),
+		ExpiresIn:       int(lifetime.Seconds()),
+		Scope:           scope,
+		IssuedTokenType: TokenTypeAccessToken,
+	}, nil
+}
+
+// exchangeScope only narrows. A first party user token has no scope and
+// stands for everything, it is narrowed to what the client may have.
+func exchangeScope(client *model.OAuthClient, subject *model.ClaimsModel, requested string) (string, error) {
+	held := strings.Fields(subject.Scope)
+	if subject.ClientID == "" {
+		held = client.Scopes
+	}
+
+	scopes := strings.Fields(requested)
+	if len(scopes) == 0 {
+		for _, s := range held {
+			if slices.Contains(client.Scopes, s) {
+				scopes = append(scopes, s)
+			}
+		}
+	}
+
+	for _, s := range scopes {
+		if !slices.Contains(held, s) {
+			return "", oauthError("invalid_scope", fmt.Sprintf("scope %q is not held by the subject token", s))
+		}
+		if !slices.Contains(client.Scopes, s) {
+			return "", oauthError("invalid_scope", fmt.Sprintf("scope %q is not allowed for this client", s))
+		}
+	}
+
+	return strings.Join(scopes, " "), nil
+}
+
+// exchangeAudience keeps the audience of the subject token when none is asked,
+// a token already meant for some audiences cannot be moved to another. A token
+// without one goes to the audiences of the client, an exchanged token always
+// names where it is meant to be used.
+func exchangeAudience(client *model.OAuthClient, subject *model.ClaimsModel, requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		if len(subject.Audience) > 0 {
+			return subject.Audience, nil
+		}
+		if len(client.Audiences) == 0 {
+			return nil, oauthError("invalid_target", "an audience is required, the client has none registered")
+		}
+		return client.Audiences, nil
+	}
+
+	for _, aud := range requested {
+		if !slices.Contains(client.Audiences, aud) {
+			return nil, oauthError("invalid_target", fmt.Sprintf("audience %q is not allowed for this client", aud))
+		}
+		if len(subject.Audience) > 0 && !slices.Contains(subject.Audience, aud) {
+			return nil, oauthError("invalid_target", fmt.Sprintf("audience %q is outside the subject token audience", aud))
+		}
+	}
+
+	return requested, nil
+}
+
+// exchangeActor is the actor_token principal, or the client itself when there
+// is none. Earlier actors of the subject token stay nested under it.
+func (h *oidcService) exchangeActor(
+	ctx context.Context,
+	client *model.OAuthClient,
+	subject *model.ClaimsModel,
+	req model.TokenRequest,
+) (*model.Actor, error) {
+	actor := &model.Actor{Subject: client.ClientID, ClientID: client.ClientID}
+
+	if req.ActorToken != "" {
+		if req.ActorTokenType != TokenTypeAccessToken {
+			return nil, oauthError("invalid_request", "actor_token_type must be an access token")
+		}
+
+		claims, err := helper.ValidateAccessToken(ctx, req.ActorToken)
+		if err != nil {
+			return nil, oauthError("invalid_grant", "actor_token is invalid or expired")
+		}
+		if err := helper.CheckDPoPBinding(ctx, claims); err != nil {
+			return nil, oauthError("invalid_grant", "actor_token is DPoP bound, the request needs a proof of its key")
+		}
+		// a client can only name itself or principals it holds tokens of
+		if claims.ClientID != client.ClientID {
+			return nil, oauthError("invalid_grant", "actor_token was issued to another client")
+		}
+		actor = &model.Actor{Subject: claims.Subject, ClientID: claims.ClientID}
+	} else if req.ActorTokenType != "" {
+		return nil, oauthError("invalid_request", "actor_token_type without actor_token")
+	}
+
+	// a client narrowing its own token is not acting for anyone
+	if subject.IsMachine() && subject.Subject == actor.Subject && subject.Actor == nil {
+		return nil, nil
+	}
+
+	actor.Actor = subject.Actor
+	return actor, nil
+}
@@ -0,0 +1,240 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"auth/internal/helper"
+	"auth/internal/model"
+)
+
+func useTestTokenSecrets(t *testing.T) {
+	t.Helper()
+
+	t.Setenv("JWT_SECRET", "test-access-secret-0123456789abcdef")
+	t.Setenv("JWT_REFRESH_SECRET", "test-refresh-secret-0123456789abcdef")
+	t.Setenv("JWT_SIGNING_ALG", "")
+	t.Setenv("JWT_KEY_STORE", "")
+	t.Setenv("JWT_EXPIRED", "")
+	t.Setenv("DPOP_REQUIRED", "")
+}
+
+func testExchangeClient(audiences ...string) *model.OAuthClient {
+	return &model.OAuthClient{
+		ClientID:   "gateway",
+		GrantTypes: []string{GrantTokenExchange},
+		Scopes:     []string{"openid", "reports"},
+		Audiences:  audiences,
+	}
+}
+
+func exchange(t *testing.T, client *model.OAuthClient, subjectToken string, audience ...string) (*model.ClaimsModel, error) {
+	t.Helper()
+
+	ctx := context.Background()
+	res, err := (&oidcService{}).tokenExchange(ctx, client, model.TokenRequest{
+		GrantType:        GrantTokenExchange,
+		SubjectToken:     subjectToken,
+		SubjectTokenType: TokenTypeAccessToken,
+		Audience:         audience,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := helper.ValidateAccessToken(ctx, res.AccessToken)
+	if err != nil {
+		t.Fatalf("exchanged token does not validate: %v", err)
+	}
+	return claims, nil
+}
+
+func adminAccessToken(t *testing.T) string {
+	t.Helper()
+
+	token, err := helper.CreateAccessToken(context.Background(), model.User{ID: 1, Username: "admin", Role: model.RoleAdmin})
+	if err != nil {
+		t.Fatalf("CreateAccessToken: %v", err)
+	}
+	return token
+}
+
+// an exchanged admin token must not carry the admin role anywhere
+func TestTokenExchangeDropsRole(t *testing.T) {
+	useTestTokenSecrets(t)
+
+	claims, err := exchange(t, testExchangeClient("https://reports.example.com"), adminAccessToken(t))
+	if err != nil {
+		t.Fatalf("tokenExchange: %v", err)
+	}
+	if claims.Role != "" {
+		t.Fatalf("exchanged token carries role %q", claims.Role)
+	}
+	if claims.UserID != 1 || claims.ClientID != "gateway" || claims.Actor == nil || claims.Actor.ClientID != "gateway" {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+}
+
+func TestTokenExchangeAlwaysSetsAudience(t *testing.T) {
+	useTestTokenSecrets(t)
+	subject := adminAccessToken(t)
+
+	claims, err := exchange(t, testExchangeClient("https://reports.example.com"), subject)
+	if err != nil {
+		t.Fatalf("tokenExchange: %v", err)
+	}
+	if !slices.Equal(claims.Audience, []string{"https://reports.example.com"}) {
+		t.Fatalf("audience = %v, want the client's", claims.Audience)
+	}
+
+	claims, err = exchange(t, testExchangeClient("https://reports.example.com", "https://billing.example.com"), subject, "https://billing.example.com")
+	if err != nil {
+		t.Fatalf("tokenExchange: %v", err)
+	}
+	if !slices.Equal(claims.Audience, []string{"https://billing.example.com"}) {
+		t.Fatalf("audience = %v, want the requested one", claims.Audience)
+	}
+
+	// nothing to default to, an audience-less token would pass for one of ours
+	_, err = exchange(t, testExchangeClient(), subject)
+	var oauthErr *OAuthError
+	if !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_target" {
+		t.Fatalf("got %v, want invalid_target", err)
+	}
+
+	// a token meant for reports cannot be moved to billing
+	narrowed, _, err := helper.CreateExchangedAccessToken(
+		context.Background(),
+		&model.ClaimsModel{UserID: 1, Username: "admin"},
+		"gateway", "reports", []string{"https://reports.example.com"}, nil, 0,
+	)
+	if err != nil {
+		t.Fatalf("CreateExchangedAccessToken: %v", err)
+	}
+	both := testExchangeClient("https://reports.example.com", "https://billing.example.com")
+	if _, err := exchange(t, both, narrowed, "https://billing.example.com"); !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_target" {
+		t.Fatalf("got %v, want invalid_target", err)
+	}
+	claims, err = exchange(t, both, narrowed)
+	if err != nil {
+		t.Fatalf("tokenExchange: %v", err)
+	}
+	if !slices.Equal(claims.Audience, []string{"https://reports.example.com"}) {
+		t.Fatalf("audience = %v, want the subject token's", claims.Audience)
+	}
+}
+
+func TestTokenExchangeOnlyTakesAccessTokens(t *testing.T) {
+	useTestTokenSecrets(t)
+
+	idToken, err := helper.SignIDToken(model.IDTokenClaims{})
+	if err != nil {
+		t.Fatalf("SignIDToken: %v", err)
+	}
+	_, err = exchange(t, testExchangeClient("https://reports.example.com"), idToken)
+	var oauthErr *OAuthError
+	if !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_grant" {
+		t.Fatalf("got %v, want invalid_grant", err)
+	}
+}
+
+func withProof(jkt string) context.Context {
+	return helper.WithDPoPProof(context.Background(), &helper.DPoPProof{JKT: jkt})
+}
+
+func TestTokenExchangeNeedsProofOfBoundTokens(t *testing.T) {
+	useTestTokenSecrets(t)
+	client := testExchangeClient("https://reports.example.com")
+	user := model.User{ID: 7, Username: "user", Role: model.RoleUser}
+	s := &oidcService{}
+
+	bound, err := helper.CreateAccessToken(withProof("key-a"), user)
+	if err != nil {
+		t.Fatalf("CreateAccessToken: %v", err)
+	}
+	req := model.TokenRequest{
+		GrantType:        GrantTokenExchange,
+		SubjectToken:     bound,
+		SubjectTokenType: TokenTypeAccessToken,
+	}
+
+	for name, ctx := range map[string]context.Context{
+		"no proof":           context.Background(),
+		"proof of other key": withProof("key-b"),
+	} {
+		var oauthErr *OAuthError
+		if _, err := s.tokenExchange(ctx, client, req); !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_grant" {
+			t.Fatalf("%s: got %v, want invalid_grant", name, err)
+		}
+	}
+
+	res, err := s.tokenExchange(withProof("key-a"), client, req)
+	if err != nil {
+		t.Fatalf("tokenExchange: %v", err)
+	}
+	claims, err := helper.ValidateAccessToken(context.Background(), res.AccessToken)
+	if err != nil {
+		t.Fatalf("ValidateAccessToken: %v", err)
+	}
+	if claims.Confirmation == nil || claims.Confirmation.JKT != "key-a" {
+		t.Fatalf("exchanged token is not bound to the proof key: %+v", claims.Confirmation)
+	}
+
+	// the actor token is held to its key the same way
+	actor, err := helper.CreateClientAccessToken(withProof("key-b"), user, client.ClientID, "reports", 0)
+	if err != nil {
+		t.Fatalf("CreateClientAccessToken: %v", err)
+	}
+	req.ActorToken, req.ActorTokenType = actor, TokenTypeAccessToken
+	if _, err := s.tokenExchange(withProof("key-a"), client, req); err == nil {
+		t.Fatal("bound actor token was accepted without a proof of its key")
+	}
+}
+
+// an exchanged token ends with the session of its subject token
+func TestTokenExchangeKeepsSession(t *testing.T) {
+	useTestTokenSecrets(t)
+	ctx := context.Background()
+	rdb := newTestRedis(t)
+	useTestDenylist(t, rdb)
+
+	user := model.User{ID: 42, Username: "jane", Role: model.RoleUser}
+	refresh, err := helper.CreateRefreshToken(ctx, user, rdb)
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	subject, err := helper.CreateAccessToken(helper.WithRefreshSession(ctx, refresh), user)
+	if err != nil {
+		t.Fatalf("CreateAccessToken: %v", err)
+	}
+	subjectClaims, err := helper.ValidateAccessToken(ctx, subject)
+	if err != nil {
+		t.Fatalf("ValidateAccessToken: %v", err)
+	}
+
+	res, err := (&oidcService{}).tokenExchange(ctx, testExchangeClient("https://reports.example.com"), model.TokenRequest{
+		GrantType:        GrantTokenExchange,
+		SubjectToken:     subject,
+		SubjectTokenType: TokenTypeAccessToken,
+		Audience:         []string{"https://reports.example.com"},
+	})
+	if err != nil {
+		t.Fatalf("tokenExchange: %v", err)
+	}
+	claims, err := helper.ValidateAccessToken(ctx, res.AccessToken)
+	if err != nil {
+		t.Fatalf("ValidateAccessToken: %v", err)
+	}
+	if claims.SessionID == "" || claims.SessionID != subjectClaims.SessionID {
+		t.Fatalf("exchanged token is in session %q, want %q", claims.SessionID, subjectClaims.SessionID)
+	}
+
+	if err := helper.RevokeOtherRefreshTokens(ctx, user.ID, "", rdb); err != nil {
+		t.Fatalf("RevokeOtherRefreshTokens: %v", err)
+	}
+	if _, err := helper.ValidateAccessToken(ctx, res.AccessToken); err == nil {
+		t.Fatal("exchanged token outlived its session")
+	}
+}
@@ -4,22 +4,34 @@ import (
 	"context"
 	"fmt"
 
+	"auth/internal/helper"
 	"auth/internal/model"
 	"auth/internal/repository"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type UserService interface {
 	GetById(ctx context.Context, id int) (*model.User, error)
 	GetByUsername(ctx context.Context, username string) (*model.User, error)
 	GetMany(ctx context.Context, limit int, offset int) ([]model.User, error)
+	ChangePassword(ctx context.Context, userID int, body model.ChangePassword, refreshToken string) error
 }
 
 type userService struct {
-	repo repository.Repository
+	repo        repository.Repository
+	redisClient *redis.Client
 }
 
-func NewUserService(repo repository.Repository) UserService {
-	return &userService{repo: repo}
+func NewUserService(
+	repo repository.Repository,
+	redisClient *redis.Client,
+) UserService {
+	return &userService{
+		repo:        repo,
+		redisClient: redisClient,
+	}
 }
 
 func (h *userService) GetById(ctx context.Context, id int) (*model.User, error) {
@@ -48,3 +60,59 @@ func (h *userService) GetMany(ctx context.Context, limit int, offset int) ([]mod
 	}
 	return res, nil
 }
+
+// ChangePassword keeps the session of refreshToken alive when SignOutOthers is set,
+// every other session of the user is revoked
+func (h *userService) ChangePassword(
+	ctx context.Context,
+	userID int,
+	body model.ChangePassword,
+	refreshToken string,
+) error {
+	r := h.repo.User()
+	user, err := r.GetById(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed getting user: %w", err)
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(body.CurrentPassword)); err != nil {
+		return ErrWrongPassword
+	}
+
+	if err := helper.ValidatePassword(body.NewPassword); err != nil {
+		return err
+	}
+	if body.NewPassword == body.CurrentPassword {
+		return fmt.Errorf("new password must be different from the current one")
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(body.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hashing password: %w", err)
+	}
+
+	rA := h.repo.Auth()
+	if err := rA.UpdatePassword(ctx, userID, string(hashedPassword)); err != nil {
+		return fmt.Errorf("failed updating password: %w", err)
+	}
+
+	if !body.SignOutOthers {
+		return nil
+	}
+
+	// a cookie of another user or an invalid one keeps nothing alive. The cookie
+	// comes without a DPoP proof, its signature and state are all that is checked.
+	keepJTI := ""
+	if refreshToken != "" {
+		claims, err := helper.InspectRefreshToken(ctx, refreshToken, h.redisClient)
+		if err == nil && claims.UserID == userID {
+			keepJTI = claims.ID
+		}
+	}
+
+	if err := helper.RevokeOtherRefreshTokens(ctx, userID, keepJTI, h.redisClient); err != nil {
+		return fmt.Errorf("failed revoking sessions: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,530 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"time"
+
+	"auth/internal/helper"
+	"auth/internal/model"
+	"auth/internal/repository"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/redis/go-redis/v9"
+)
+
+const webauthnSessionTTL = 5 * time.Minute
+
+const (
+	webauthnPurposeRegister = "register"
+	webauthnPurposeLogin    = "login"
+	webauthnPurposeMFA      = "mfa"
+)
+
+var (
+	ErrWebAuthnSession    = errors.New("invalid or expired webauthn session")
+	ErrCredentialNotFound = errors.New("passkey not found")
+	ErrCredentialCloned   = errors.New("passkey rejected, the authenticator may have been cloned")
+)
+
+type WebAuthnService interface {
+	BeginRegistration(ctx context.Context, userID int) (*model.WebAuthnOptions, error)
+	FinishRegistration(ctx context.Context, userID int, sessionID string, name string, body io.Reader) (*model.WebAuthnCredential, error)
+	GetByUserId(ctx context.Context, userID int) ([]model.WebAuthnCredential, error)
+	Delete(ctx context.Context, userID int, id int) error
+	// BeginLogin falls back to a discoverable login when the username is empty or unknown
+	BeginLogin(ctx context.Context, username string) (*model.WebAuthnOptions, error)
+	FinishLogin(ctx context.Context, sessionID string, body io.Reader) (*model.User, string, string, error)
+	BeginMFA(ctx context.Context, mfaToken string) (*model.WebAuthnOptions, error)
+	FinishMFA(ctx context.Context, sessionID string, body io.Reader) (*model.User, string, string, error)
+}
+
+type webAuthnService struct {
+	repo        repository.Repository
+	redisClient *redis.Client
+	webAuthn    *webauthn.WebAuthn
+}
+
+func NewWebAuthnService(
+	repo repository.Repository,
+	redisClient *redis.Client,
+	webAuthn *webauthn.WebAuthn,
+) WebAuthnService {
+	return &webAuthnService{
+		repo:        repo,
+		redisClient: redisClient,
+		webAuthn:    webAuthn,
+	}
+}
+
+// webauthnUser adapts a user and its stored credentials to the library
+type webauthnUser struct {
+	user        model.User
+	credentials []webauthn.Credential
+}
+
+func (u *webauthnUser) WebAuthnID() []byte {
+	return webauthnUserHandle(u.user.ID)
+}
+
+func (u *webauthnUser) WebAuthnName() string {
+	return u.user.Username
+}
+
+func (u *webauthnUser) WebAuthnDisplayName() string {
+	return u.user.Name
+}
+
+func (u *webauthnUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+func webauthnUserHandle(userID int) []byte {
+	return []byte(strconv.Itoa(userID))
+}
+
+// the challenge lives in redis between begin and finish, the session id
+// is handed to the client and can only be redeemed once
+type webauthnSession struct {
+	Purpose  string               `json:"purpose"`
+	UserID   int                  `json:"user_id,omitempty"`
+	MFAToken string               `json:"mfa_token,omitempty"`
+	Data     webauthn.SessionData `json:"data"`
+}
+
+func webauthnSessionKey(id string) string {
+	return "webauthn:session:" + id
+}
+
+func (h *webAuthnService) saveSession(ctx context.Context, session webauthnSession) (string, error) {
+	id, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	raw, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+
+	if err := h.redisClient.Set(ctx, webauthnSessionKey(id), raw, webauthnSessionTTL).Err(); err != nil {
+		return "", fmt.Errorf("failed storing webauthn session: %w", err)
+	}
+
+	return id, nil
+}
+
+func (h *webAuthnService) takeSession(ctx context.Context, id string, purpose string) (*webauthnSession, error) {
+	if id == "" {
+		return nil, ErrWebAuthnSession
+	}
+
+	raw, err := h.redisClient.GetDel(ctx, webauthnSessionKey(id)).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, ErrWebAuthnSession
+		}
+		return nil, err
+	}
+
+	session := webauthnSession{}
+	if err := json.Unmarshal(raw, &session); err != nil {
+		return nil, err
+	}
+	if session.Purpose != purpose {
+		return nil, ErrWebAuthnSession
+	}
+
+	return &session, nil
+}
+
+func (h *webAuthnService) loadUser(ctx context.Context, user model.User) (*webauthnUser, error) {
+	r := h.repo.WebAuthn()
+	rows, err := r.GetByUserId(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting passkeys: %w", err)
+	}
+
+	credentials := make([]webauthn.Credential, 0, len(rows))
+	for _, row := range rows {
+		credential := webauthn.Credential{}
+		if err := json.Unmarshal(row.Credential, &credential); err != nil {
+			return nil, fmt.Errorf("failed decoding passkey %d: %w", row.ID, err)
+		}
+		// the column is the source of truth, it is the one updated atomically
+		credential.Authenticator.SignCount = uint32(row.SignCount)
+		credentials = append(credentials, credential)
+	}
+
+	return &webauthnUser{user: user, credentials: credentials}, nil
+}
+
+func (h *webAuthnService) loadUserById(ctx context.Context, userID int) (*webauthnUser, error) {
+	rU := h.repo.User()
+	user, err := rU.GetById(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting user: %w", err)
+	}
+	return h.loadUser(ctx, *user)
+}
+
+func (h *webAuthnService) BeginRegistration(ctx context.Context, userID int) (*model.WebAuthnOptions, error) {
+	user, err := h.loadUserById(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return h.beginRegistration(ctx, user)
+}
+
+// beginRegistration asks for a new passkey, the ones the user already has are excluded
+func (h *webAuthnService) beginRegistration(ctx context.Context, user *webauthnUser) (*model.WebAuthnOptions, error) {
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(user.credentials))
+	for _, credential := range user.credentials {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+
+	options, data, err := h.webAuthn.BeginRegistration(
+		user,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementPreferred,
+			UserVerification: protocol.VerificationPreferred,
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed starting registration: %w", err)
+	}
+
+	sessionID, err := h.saveSession(ctx, webauthnSession{
+		Purpose: webauthnPurposeRegister,
+		UserID:  user.user.ID,
+		Data:    *data,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.WebAuthnOptions{SessionID: sessionID, Options: options}, nil
+}
+
+func (h *webAuthnService) FinishRegistration(
+	ctx context.Context,
+	userID int,
+	sessionID string,
+	name string,
+	body io.Reader,
+) (*model.WebAuthnCredential, error) {
+	user, err := h.loadUserById(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	credential, err := h.finishRegistration(ctx, user, sessionID, body)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := json.Marshal(credential)
+	if err != nil {
+		return nil, err
+	}
+
+	if name == "" {
+		name = "Passkey"
+	}
+
+	r := h.repo.WebAuthn()
+	res, err := r.Create(ctx, model.WebAuthnCredential{
+		UserID:       userID,
+		CredentialID: credential.ID,
+		Name:         name,
+		Credential:   raw,
+		SignCount:    int64(credential.Authenticator.SignCount),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed saving passkey: %w", err)
+	}
+
+	return res, nil
+}
+
+// finishRegistration verifies the attestation against the challenge of the session
+func (h *webAuthnService) finishRegistration(
+	ctx context.Context,
+	user *webauthnUser,
+	sessionID string,
+	body io.Reader,
+) (*webauthn.Credential, error) {
+	session, err := h.takeSession(ctx, sessionID, webauthnPurposeRegister)
+	if err != nil {
+		return nil, err
+	}
+	if session.UserID != user.user.ID {
+		return nil, ErrWebAuthnSession
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid attestation: %w", err)
+	}
+
+	credential, err := h.webAuthn.CreateCredential(user, session.Data, parsed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid attestation: %w", err)
+	}
+
+	return credential, nil
+}
+
+func (h *webAuthnService) GetByUserId(ctx context.Context, userID int) ([]model.WebAuthnCredential, error) {
+	r := h.repo.WebAuthn()
+	res, err := r.GetByUserId(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting passkeys: %w", err)
+	}
+	return res, nil
+}
+
+func (h *webAuthnService) Delete(ctx context.Context, userID int, id int) error {
+	r := h.repo.WebAuthn()
+	if err := r.Delete(ctx, userID, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrCredentialNotFound
+		}
+		if errors.Is(err, repository.ErrLastLoginMethod) {
+			return err
+		}
+		return fmt.Errorf("failed deleting passkey: %w", err)
+	}
+	return nil
+}
+
+func (h *webAuthnService) BeginLogin(ctx context.Context, username string) (*model.WebAuthnOptions, error) {
+	var user *webauthnUser
+	if username != "" {
+		rU := h.repo.User()
+		res, err := rU.GetByUsername(ctx, username)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed getting user: %w", err)
+		}
+		if err == nil {
+			user, err = h.loadUser(ctx, *res)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// the passkey replaces the password, so user verification is the second factor
+	opts := []webauthn.LoginOption{webauthn.WithUserVerification(protocol.VerificationRequired)}
+
+	var (
+		options *protocol.CredentialAssertion
+		data    *webauthn.SessionData
+		err     error
+	)
+	// an unknown username gets the same answer as no username,
+	// so the response does not tell which accounts exist
+	if user != nil && len(user.credentials) > 0 {
+		options, data, err = h.webAuthn.BeginLogin(user, opts...)
+	} else {
+		options, data, err = h.webAuthn.BeginDiscoverableLogin(opts...)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed starting login: %w", err)
+	}
+
+	sessionID, err := h.saveSession(ctx, webauthnSession{
+		Purpose: webauthnPurposeLogin,
+		Data:    *data,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.WebAuthnOptions{SessionID: sessionID, Options: options}, nil
+}
+
+func (h *webAuthnService) FinishLogin(
+	ctx context.Context,
+	sessionID string,
+	body io.Reader,
+) (*model.User, string, string, error) {
+	session, err := h.takeSession(ctx, sessionID, webauthnPurposeLogin)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(body)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("invalid assertion: %w", err)
+	}
+
+	var (
+		user       *webauthnUser
+		credential *webauthn.Credential
+	)
+	if len(session.Data.UserID) == 0 {
+		handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+			userID, err := strconv.Atoi(string(userHandle))
+			if err != nil {
+				return nil, fmt.Errorf("invalid user handle")
+			}
+			u, err := h.loadUserById(ctx, userID)
+			if err != nil {
+				return nil, err
+			}
+			user = u
+			return u, nil
+		}
+		if _, credential, err = h.webAuthn.ValidatePasskeyLogin(handler, session.Data, parsed); err != nil {
+			return nil, "", "", fmt.Errorf("invalid assertion: %w", err)
+		}
+	} else {
+		userID, err := strconv.Atoi(string(session.Data.UserID))
+		if err != nil {
+			return nil, "", "", ErrWebAuthnSession
+		}
+		if user, err = h.loadUserById(ctx, userID); err != nil {
+			return nil, "", "", err
+		}
+		if credential, err = h.webAuthn.ValidateLogin(user, session.Data, parsed); err != nil {
+			return nil, "", "", fmt.Errorf("invalid assertion: %w", err)
+		}
+	}
+
+	if err := h.recordAssertion(ctx, user.user.ID, credential); err != nil {
+		return nil, "", "", err
+	}
+
+	refreshToken, token, err := issueTokens(ctx, user.user, h.redisClient)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	return &user.user, refreshToken, token, nil
+}
+
+// BeginMFA starts an assertion for a user who already passed the password check
+func (h *webAuthnService) BeginMFA(ctx context.Context, mfaToken string) (*model.WebAuthnOptions, error) {
+	claims, err := helper.ValidateMFAPendingToken(ctx, mfaToken, h.redisClient)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := h.loadUserById(ctx, claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if len(user.credentials) == 0 {
+		return nil, ErrMFANotEnrolled
+	}
+
+	options, data, err := h.webAuthn.BeginLogin(user)
+	if err != nil {
+		return nil, fmt.Errorf("failed starting login: %w", err)
+	}
+
+	sessionID, err := h.saveSession(ctx, webauthnSession{
+		Purpose:  webauthnPurposeMFA,
+		UserID:   claims.UserID,
+		MFAToken: mfaToken,
+		Data:     *data,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.WebAuthnOptions{SessionID: sessionID, Options: options}, nil
+}
+
+func (h *webAuthnService) FinishMFA(
+	ctx context.Context,
+	sessionID string,
+	body io.Reader,
+) (*model.User, string, string, error) {
+	session, err := h.takeSession(ctx, sessionID, webauthnPurposeMFA)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	// the pending token may have been used or burned since begin
+	claims, err := helper.ValidateMFAPendingToken(ctx, session.MFAToken, h.redisClient)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if claims.UserID != session.UserID {
+		return nil, "", "", ErrWebAuthnSession
+	}
+
+	user, err := h.loadUserById(ctx, claims.UserID)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(body)
+	if err == nil {
+		var credential *webauthn.Credential
+		credential, err = h.webAuthn.ValidateLogin(user, session.Data, parsed)
+		if err == nil {
+			err = h.recordAssertion(ctx, user.user.ID, credential)
+		}
+	}
+	if err != nil {
+		if failErr := helper.FailMFAPendingToken(ctx, claims, h.redisClient); failErr != nil {
+			return nil, "", "", failErr
+		}
+		if errors.Is(err, ErrCredentialCloned) {
+			return nil, "", "", err
+		}
+		return nil, "", "", fmt.Errorf("%w: %v", ErrInvalidMFACode, err)
+	}
+
+	consumed, err := helper.ConsumeMFAPendingToken(ctx, claims, h.redisClient)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if !consumed {
+		return nil, "", "", fmt.Errorf("mfa token expired or already used")
+	}
+
+	ctx = helper.WithRememberMe(ctx, claims.RememberMe)
+	refreshToken, token, err := issueTokens(ctx, user.user, h.redisClient)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	return &user.user, refreshToken, token, nil
+}
+
+// recordAssertion rejects a sign count that did not move forward and stores
+// the new one, the conditional update also catches two logins racing
+func (h *webAuthnService) recordAssertion(ctx context.Context, userID int, credential *webauthn.Credential) error {
+	if credential.Authenticator.CloneWarning {
+		log.Printf("passkey clone warning for user %d, credential %x", userID, credential.ID)
+		return ErrCredentialCloned
+	}
+
+	raw, err := json.Marshal(credential)
+	if err != nil {
+		return err
+	}
+
+	r := h.repo.WebAuthn()
+	if err := r.UpdateSignCount(ctx, credential.ID, int64(credential.Authenticator.SignCount), raw); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Printf("passkey sign count not advanced for user %d, credential %x", userID, credential.ID)
+			return ErrCredentialCloned
+		}
+		return fmt.Errorf("failed updating passkey: %w", err)
+	}
+
+	return nil
+}
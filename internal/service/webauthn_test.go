@@ -0,0 +1,201 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"auth/internal/model"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+const (
+	testRPID   = "auth.example.com"
+	testOrigin = "https://auth.example.com"
+)
+
+// softAuthenticator is a passkey in memory, it answers a registration
+// with a "none" attestation the way a platform authenticator would
+type softAuthenticator struct {
+	key *ecdsa.PrivateKey
+	id  []byte
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		t.Fatal(err)
+	}
+	return &softAuthenticator{key: key, id: id}
+}
+
+// create builds the attestation response to a registration challenge
+func (a *softAuthenticator) create(t *testing.T, challenge []byte, origin string) []byte {
+	t.Helper()
+
+	clientData, err := json.Marshal(map[string]any{
+		"type":      "webauthn.create",
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    origin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicKey, err := cbor.Marshal(map[int]any{
+		1:  2,  // kty EC2
+		3:  -7, // alg ES256
+		-1: 1,  // crv P-256
+		-2: a.key.X.FillBytes(make([]byte, 32)),
+		-3: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	authData := bytes.NewBuffer(rpIDHash[:])
+	authData.WriteByte(0x45) // user present, user verified, attested credential data
+	authData.Write([]byte{0, 0, 0, 0})
+	authData.Write(make([]byte, 16)) // aaguid
+	_ = binary.Write(authData, binary.BigEndian, uint16(len(a.id)))
+	authData.Write(a.id)
+	authData.Write(publicKey)
+
+	attestation, err := cbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": authData.Bytes(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"id":    base64.RawURLEncoding.EncodeToString(a.id),
+		"rawId": base64.RawURLEncoding.EncodeToString(a.id),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientData),
+			"attestationObject": base64.RawURLEncoding.EncodeToString(attestation),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func newTestWebAuthnService(t *testing.T) *webAuthnService {
+	t.Helper()
+
+	rp, err := webauthn.New(&webauthn.Config{
+		RPID:          testRPID,
+		RPDisplayName: "auth-service",
+		RPOrigins:     []string{testOrigin},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &webAuthnService{redisClient: newTestRedis(t), webAuthn: rp}
+}
+
+func testWebAuthnUser(id int) *webauthnUser {
+	return &webauthnUser{user: model.User{ID: id, Username: "user", Name: "User"}}
+}
+
+func registrationChallenge(t *testing.T, options *model.WebAuthnOptions) []byte {
+	t.Helper()
+
+	creation, ok := options.Options.(*protocol.CredentialCreation)
+	if !ok {
+		t.Fatalf("unexpected options %T", options.Options)
+	}
+	if creation.Response.RelyingParty.ID != testRPID {
+		t.Fatalf("rp id = %q, want %q", creation.Response.RelyingParty.ID, testRPID)
+	}
+	return creation.Response.Challenge
+}
+
+func TestWebAuthnRegistration(t *testing.T) {
+	ctx := context.Background()
+	s := newTestWebAuthnService(t)
+	user := testWebAuthnUser(42)
+	authenticator := newSoftAuthenticator(t)
+
+	options, err := s.beginRegistration(ctx, user)
+	if err != nil {
+		t.Fatalf("beginRegistration: %v", err)
+	}
+	body := authenticator.create(t, registrationChallenge(t, options), testOrigin)
+
+	credential, err := s.finishRegistration(ctx, user, options.SessionID, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("finishRegistration: %v", err)
+	}
+	if !bytes.Equal(credential.ID, authenticator.id) {
+		t.Fatalf("credential id %x, want %x", credential.ID, authenticator.id)
+	}
+	if !credential.Flags.UserVerified {
+		t.Fatal("user verification flag was lost")
+	}
+
+	// the session is single use
+	if _, err := s.finishRegistration(ctx, user, options.SessionID, bytes.NewReader(body)); !errors.Is(err, ErrWebAuthnSession) {
+		t.Fatalf("got %v, want ErrWebAuthnSession", err)
+	}
+}
+
+func TestWebAuthnRegistrationRejects(t *testing.T) {
+	ctx := context.Background()
+	s := newTestWebAuthnService(t)
+	user := testWebAuthnUser(42)
+	authenticator := newSoftAuthenticator(t)
+
+	// a session started by another user
+	options, err := s.beginRegistration(ctx, testWebAuthnUser(7))
+	if err != nil {
+		t.Fatalf("beginRegistration: %v", err)
+	}
+	body := authenticator.create(t, registrationChallenge(t, options), testOrigin)
+	if _, err := s.finishRegistration(ctx, user, options.SessionID, bytes.NewReader(body)); !errors.Is(err, ErrWebAuthnSession) {
+		t.Fatalf("other user: got %v, want ErrWebAuthnSession", err)
+	}
+
+	// an answer to another challenge
+	options, err = s.beginRegistration(ctx, user)
+	if err != nil {
+		t.Fatalf("beginRegistration: %v", err)
+	}
+	body = authenticator.create(t, []byte("not-the-challenge"), testOrigin)
+	if _, err := s.finishRegistration(ctx, user, options.SessionID, bytes.NewReader(body)); err == nil {
+		t.Fatal("attestation for another challenge was accepted")
+	}
+
+	// made on a phishing origin
+	options, err = s.beginRegistration(ctx, user)
+	if err != nil {
+		t.Fatalf("beginRegistration: %v", err)
+	}
+	body = authenticator.create(t, registrationChallenge(t, options), "https://auth.example.com.evil.test")
+	if _, err := s.finishRegistration(ctx, user, options.SessionID, bytes.NewReader(body)); err == nil {
+		t.Fatal("attestation from another origin was accepted")
+	}
+}
@@ -15,11 +15,18 @@ func main() {
 		log.Println("ENV LOAD ERROR:", err)
 	}
 
-	app := app.New()
-
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 
+	if len(os.Args) > 1 {
+		if err := app.RunCommand(ctx, os.Args[1:]); err != nil {
+			log.Fatalf("command failed: %v", err)
+		}
+		return
+	}
+
+	app := app.New()
+
 	if err := app.Start(ctx); err != nil {
 		log.Fatalf("failed to start app: %v", err)
 	}
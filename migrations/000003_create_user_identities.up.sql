@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS user_identities (
+  id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+
+  user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  provider VARCHAR(64) NOT NULL,
+  subject VARCHAR(256) NOT NULL,
+  email VARCHAR(256),
+
+  linked_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  UNIQUE (provider, subject),
+  UNIQUE (user_id, provider)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities (user_id);
+
+-- social accounts created before this table have an empty password
+-- and a "<provider>_<subject>" username
+INSERT INTO user_identities (user_id, provider, subject)
+SELECT
+  id,
+  split_part(username, '_', 1),
+  substring(username FROM position('_' IN username) + 1)
+FROM users
+WHERE password = '' AND position('_' IN username) > 0
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+  id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+
+  user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  credential_id BYTEA NOT NULL UNIQUE,
+  name VARCHAR(128) NOT NULL DEFAULT '',
+
+  -- public key, flags, transports and attestation as returned by the library
+  credential JSONB NOT NULL,
+  sign_count BIGINT NOT NULL DEFAULT 0,
+
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  last_used_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_webauthn_credentials_user_id ON webauthn_credentials (user_id);
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS signing_keys (
+  id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+
+  kid VARCHAR(128) NOT NULL UNIQUE,
+  alg VARCHAR(16) NOT NULL,
+
+  -- PKCS8 private key or HMAC secret, AES-GCM sealed with DATA_ENCRYPTION_KEY
+  private_key TEXT NOT NULL,
+  state VARCHAR(16) NOT NULL DEFAULT 'active'
+    CHECK (state IN ('active', 'verify_only', 'retired')),
+
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  deactivated_at TIMESTAMPTZ,
+  retired_at TIMESTAMPTZ
+);
+
+-- only one key signs new tokens
+CREATE UNIQUE INDEX IF NOT EXISTS idx_signing_keys_active ON signing_keys (state) WHERE state = 'active';
@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS oauth_clients (
+  id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+
+  client_id VARCHAR(128) NOT NULL UNIQUE,
+  name VARCHAR(256) NOT NULL DEFAULT '',
+
+  -- bcrypt, empty for public clients
+  secret_hash VARCHAR(256) NOT NULL DEFAULT '',
+  is_public BOOLEAN NOT NULL DEFAULT FALSE,
+
+  redirect_uris TEXT[] NOT NULL DEFAULT '{}',
+  grant_types TEXT[] NOT NULL DEFAULT '{}',
+  scopes TEXT[] NOT NULL DEFAULT '{}',
+
+  -- seconds, 0 falls back to JWT_EXPIRED / JWT_REFRESH_EXPIRED
+  access_token_ttl INT NOT NULL DEFAULT 0,
+  refresh_token_ttl INT NOT NULL DEFAULT 0,
+
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+DROP TRIGGER IF EXISTS trigger_oauth_clients_updated_at ON oauth_clients;
+
+CREATE TRIGGER trigger_oauth_clients_updated_at
+BEFORE UPDATE ON oauth_clients
+FOR EACH ROW
+EXECUTE FUNCTION set_updated_at();
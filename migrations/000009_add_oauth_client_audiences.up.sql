@@ -0,0 +1,2 @@
+-- audiences a client may ask for through token exchange
+ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS audiences TEXT[] NOT NULL DEFAULT '{}';
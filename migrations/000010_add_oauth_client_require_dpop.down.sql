@@ -0,0 +1 @@
+ALTER TABLE oauth_clients DROP COLUMN IF EXISTS require_dpop;
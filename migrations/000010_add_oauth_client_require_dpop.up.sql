@@ -0,0 +1,2 @@
+-- a client that must bind its tokens with DPoP, others may still use bearer tokens
+ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS require_dpop BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,2 @@
+-- NULL follows the session policy of the role
+ALTER TABLE users ADD COLUMN IF NOT EXISTS max_sessions INT CHECK (max_sessions > 0);
@@ -0,0 +1,4 @@
+DELETE FROM signing_keys WHERE state = 'pending';
+ALTER TABLE signing_keys DROP CONSTRAINT IF EXISTS signing_keys_state_check;
+ALTER TABLE signing_keys ADD CONSTRAINT signing_keys_state_check
+  CHECK (state IN ('active', 'verify_only', 'retired'));
@@ -0,0 +1,5 @@
+-- a rotated key is published as pending before it signs,
+-- verifiers with a cached jwks know it by the time it is used
+ALTER TABLE signing_keys DROP CONSTRAINT IF EXISTS signing_keys_state_check;
+ALTER TABLE signing_keys ADD CONSTRAINT signing_keys_state_check
+  CHECK (state IN ('pending', 'active', 'verify_only', 'retired'));